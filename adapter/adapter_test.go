@@ -6,6 +6,7 @@ import (
 	"testing"
 	
 	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/utils"
 	"gopkg.in/yaml.v3"
 )
 
@@ -142,3 +143,81 @@ func TestYaml(t *testing.T) {
 	
 	t.Log(string(buf))
 }
+
+func TestParseLinkVless(t *testing.T) {
+	tests := []struct {
+		args string
+		want map[string]any
+	}{
+		{
+			args: "vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@reality.example.com:443?security=reality&pbk=SbVKOEMjK0sIlbwg4akyBg5mL5KZwwB-ed4eEE7YnRc&sid=6ba85179e30d4fc2&spx=%2F&fp=chrome&flow=xtls-rprx-vision&type=tcp&sni=www.microsoft.com#reality",
+			want: map[string]any{
+				"type":                    "vless",
+				"tls":                     "1",
+				"flow":                    "xtls-rprx-vision",
+				"servername":              "www.microsoft.com",
+				"client-fingerprint":      "chrome",
+				"reality-opts.public-key": "SbVKOEMjK0sIlbwg4akyBg5mL5KZwwB-ed4eEE7YnRc",
+				"reality-opts.short-id":   "6ba85179e30d4fc2",
+				"reality-opts.spider-x":   "/",
+			},
+		},
+		{
+			args: "vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@grpc.example.com:443?security=reality&pbk=pbk&fp=firefox&type=grpc&serviceName=grpc-svc&sni=grpc.example.com#grpc",
+			want: map[string]any{
+				"network":                     "grpc",
+				"grpc-opts.grpc-service-name": "grpc-svc",
+				"client-fingerprint":          "firefox",
+				"reality-opts.public-key":     "pbk",
+			},
+		},
+		{
+			args: "vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@ws.example.com:443?security=tls&type=ws&path=%2Fws&host=cdn.example.com#ws",
+			want: map[string]any{
+				"network":              "ws",
+				"ws-opts.path":         "/ws",
+				"ws-opts.headers.Host": "cdn.example.com",
+				"servername":           "cdn.example.com",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			p, err := adapter.ParseV2ray(tt.args)
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+			
+			// 导出的链接再解析一次，字段保持一致
+			link := p.Sub4V2ray()
+			rp, err := adapter.ParseV2ray(link)
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+			
+			for _, node := range []*adapter.ProxyAdapter{p, rp} {
+				m := utils.NewMap(node.ToNico()).EnableCut(".")
+				for key, want := range tt.want {
+					if got := m.GetString(key); got != want {
+						t.Errorf("%s: %s got %v, want %v", link, key, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseLinkVlessError(t *testing.T) {
+	for _, s := range []string{
+		"vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@reality.example.com:443?security=reality#missing-pbk",
+		"vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@reality.example.com:443?security=unknown#x",
+		"vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@reality.example.com:443?type=kcp#x",
+	} {
+		_, err := adapter.ParseV2ray(s)
+		if err == nil {
+			t.Errorf("%s: expect error", s)
+		}
+	}
+}
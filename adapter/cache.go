@@ -1,55 +1,183 @@
 package adapter
 
 import (
-	"bytes"
 	"errors"
+	"fmt"
+	"math"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/darabuchi/log"
+	"github.com/darabuchi/utils"
 )
 
+// 节点缓存中约定的 key
+const (
+	CacheAlive    = "alive"
+	CacheDelay    = "delay"
+	CacheSpeed    = "speed"
+	CacheSpeedStr = "speed_str"
+	// CacheSource 节点来源，如订阅地址
+	CacheSource = "source"
+	// CacheAddedAt 节点加入时间，unix 秒
+	CacheAddedAt = "added_at"
+	// CacheSuspect 节点建立连接失败，等待检测
+	CacheSuspect = "suspect"
+)
+
+// CacheEvent 缓存值变化，Deleted 为 true 时表示被删除或过期
+type CacheEvent struct {
+	Key      string
+	Old, New any
+	Deleted  bool
+}
+
 type Cache interface {
 	Reset()
 
 	Store(key string, val any)
+	// StoreWithTTL ttl 后自动过期并通知删除，ttl<=0 时永不过期
+	StoreWithTTL(key string, val any, ttl time.Duration)
+	// CompareAndSwap 当前值等于 old 时替换为 new，key 不存在时视为 nil，原有的过期时间不变
+	CompareAndSwap(key string, old, new any) bool
 
 	Load(key string) (val any, err error)
 	LoadBool(key string) bool
 	LoadUint16(key string) uint16
+	LoadInt64(key string) int64
 	LoadFloat64(key string) float64
+	LoadDuration(key string) time.Duration
+	LoadString(key string) string
 
 	Del(key string)
+
+	// Subscribe 值发生变化时回调，返回取消订阅的函数
+	Subscribe(logic func(e CacheEvent)) (cancel func())
 }
 
 var (
-	ErrCacheNotFound = errors.New("cache not found")
+	ErrCacheNotFound     = errors.New("cache not found")
+	ErrCacheTypeMismatch = errors.New("cache type mismatch")
 )
 
+// LoadAs 按类型读取，类型不一致时返回 ErrCacheTypeMismatch
+func LoadAs[T any](c Cache, key string) (T, error) {
+	var zero T
+
+	val, err := c.Load(key)
+	if err != nil {
+		return zero, err
+	}
+
+	v, ok := val.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: %s is %T", ErrCacheTypeMismatch, key, val)
+	}
+
+	return v, nil
+}
+
+type cacheItem struct {
+	val      any
+	expireAt time.Time
+
+	// timer 到期时删除并通知订阅者，不需要等到下一次读取
+	timer *time.Timer
+}
+
+func (p *cacheItem) stop() {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+func (p *cacheItem) expired(now time.Time) bool {
+	return !p.expireAt.IsZero() && !now.Before(p.expireAt)
+}
+
 type AdapterCache struct {
 	lock sync.RWMutex
 
-	m map[string]any
+	m map[string]*cacheItem
+
+	subLock     sync.RWMutex
+	subscribers map[uint64]func(e CacheEvent)
+	subId       uint64
 }
 
 func NewAdapterCache() Cache {
 	p := &AdapterCache{
-		m: map[string]any{},
+		m:           map[string]*cacheItem{},
+		subscribers: map[uint64]func(e CacheEvent){},
 	}
 
 	return p
 }
 
 func (p *AdapterCache) Store(key string, val any) {
-	p.set(key, val)
+	p.StoreWithTTL(key, val, 0)
+}
+
+func (p *AdapterCache) StoreWithTTL(key string, val any, ttl time.Duration) {
+	item := &cacheItem{
+		val: val,
+	}
+	if ttl > 0 {
+		expireAt := time.Now().Add(ttl)
+		item.expireAt = expireAt
+		item.timer = time.AfterFunc(ttl, func() {
+			p.expire(key, expireAt)
+		})
+	}
+
+	p.lock.Lock()
+	old, existed := p.getLocked(key)
+	if cur, ok := p.m[key]; ok {
+		cur.stop()
+	}
+	p.m[key] = item
+	p.lock.Unlock()
+
+	if !existed || !cacheEqual(old, val) {
+		p.notify(CacheEvent{
+			Key: key,
+			Old: old,
+			New: val,
+		})
+	}
 }
 
-func (p *AdapterCache) set(key string, val any) {
+func (p *AdapterCache) CompareAndSwap(key string, old, new any) bool {
 	p.lock.Lock()
-	defer p.lock.Unlock()
+	cur, _ := p.getLocked(key)
+	if !cacheEqual(cur, old) {
+		p.lock.Unlock()
+		return false
+	}
+
+	item := &cacheItem{
+		val: new,
+	}
+	// getLocked 已删除过期的，还在的沿用原来的过期时间
+	if cur, ok := p.m[key]; ok {
+		item.expireAt = cur.expireAt
+		item.timer = cur.timer
+	}
+	p.m[key] = item
+	p.lock.Unlock()
+
+	if !cacheEqual(old, new) {
+		p.notify(CacheEvent{
+			Key: key,
+			Old: old,
+			New: new,
+		})
+	}
 
-	p.m[key] = val
+	return true
 }
 
 func (p *AdapterCache) Load(key string) (any, error) {
@@ -66,38 +194,44 @@ func (p *AdapterCache) LoadBool(key string) bool {
 	switch x := val.(type) {
 	case bool:
 		return x
-	case int, int8, int16, int32, int64,
-		uint, uint8, uint16, uint32, uint64,
-		float32, float64:
-		return x != 0
 	case string:
-		switch strings.ToLower(x) {
-		case "true", "1":
-			return true
-		case "false", "0":
-			return false
-		case "":
-			return false
-		default:
-			return true
-		}
+		return parseCacheBool(x)
 	case []byte:
-		switch string(bytes.ToLower(x)) {
-		case "true", "1":
-			return true
-		case "false", "0":
-			return false
-		case "":
-			return false
-		default:
-			return true
-		}
+		return parseCacheBool(string(x))
+	}
+
+	f, err := toFloat64(val)
+	if err != nil {
+		log.Debugf("err:%v", err)
+		return false
+	}
+	return f != 0
+}
+
+func parseCacheBool(s string) bool {
+	switch strings.ToLower(s) {
+	case "true", "1":
+		return true
+	case "false", "0", "":
+		return false
 	default:
-		return val == nil
+		return true
 	}
 }
 
 func (p *AdapterCache) LoadUint16(key string) uint16 {
+	i := p.LoadInt64(key)
+	switch {
+	case i < 0:
+		return 0
+	case i > math.MaxUint16:
+		return math.MaxUint16
+	default:
+		return uint16(i)
+	}
+}
+
+func (p *AdapterCache) LoadInt64(key string) int64 {
 	val, err := p.get(key)
 	if err != nil {
 		log.Debugf("err:%v", err)
@@ -105,53 +239,28 @@ func (p *AdapterCache) LoadUint16(key string) uint16 {
 	}
 
 	switch x := val.(type) {
-	case bool:
-		if x {
-			return 1
-		}
-		return 0
-	case int:
-		return uint16(x)
-	case int8:
-		return uint16(x)
-	case int16:
-		return uint16(x)
-	case int32:
-		return uint16(x)
 	case int64:
-		return uint16(x)
-	case uint:
-		return uint16(x)
-	case uint8:
-		return uint16(x)
-	case uint16:
 		return x
-	case uint32:
-		return uint16(x)
-	case uint64:
-		return uint16(x)
-	case float32:
-		return uint16(x)
-	case float64:
-		return uint16(x)
+	case time.Duration:
+		return int64(x)
 	case string:
-		val, err := strconv.ParseUint(x, 10, 16)
-		if err != nil {
-			log.Debugf("err:%v", err)
-			return 0
+		i, err := strconv.ParseInt(x, 10, 64)
+		if err == nil {
+			return i
 		}
-		return uint16(val)
 	case []byte:
-		val, err := strconv.ParseUint(string(x), 10, 16)
-		if err != nil {
-			log.Debugf("err:%v", err)
-			return 0
+		i, err := strconv.ParseInt(string(x), 10, 64)
+		if err == nil {
+			return i
 		}
-		return uint16(val)
-	default:
-		log.Panic("unknown how to handle")
+	}
+
+	f, err := toFloat64(val)
+	if err != nil {
+		log.Debugf("err:%v", err)
 		return 0
 	}
+	return int64(f)
 }
 
 func (p *AdapterCache) LoadFloat64(key string) float64 {
@@ -161,81 +270,231 @@ func (p *AdapterCache) LoadFloat64(key string) float64 {
 		return 0
 	}
 
+	f, err := toFloat64(val)
+	if err != nil {
+		log.Debugf("err:%v", err)
+		return 0
+	}
+	return f
+}
+
+// LoadDuration 数字按纳秒处理，字符串支持 time.ParseDuration 格式
+func (p *AdapterCache) LoadDuration(key string) time.Duration {
+	val, err := p.get(key)
+	if err != nil {
+		log.Debugf("err:%v", err)
+		return 0
+	}
+
+	switch x := val.(type) {
+	case time.Duration:
+		return x
+	case string:
+		d, err := time.ParseDuration(x)
+		if err == nil {
+			return d
+		}
+	}
+
+	return time.Duration(p.LoadInt64(key))
+}
+
+func (p *AdapterCache) LoadString(key string) string {
+	val, err := p.get(key)
+	if err != nil {
+		log.Debugf("err:%v", err)
+		return ""
+	}
+
+	switch x := val.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
+
+func toFloat64(val any) (float64, error) {
 	switch x := val.(type) {
 	case bool:
 		if x {
-			return 1
+			return 1, nil
 		}
-		return 0
+		return 0, nil
 	case int:
-		return float64(x)
+		return float64(x), nil
 	case int8:
-		return float64(x)
+		return float64(x), nil
 	case int16:
-		return float64(x)
+		return float64(x), nil
 	case int32:
-		return float64(x)
+		return float64(x), nil
 	case int64:
-		return float64(x)
+		return float64(x), nil
 	case uint:
-		return float64(x)
+		return float64(x), nil
 	case uint8:
-		return float64(x)
+		return float64(x), nil
 	case uint16:
-		return float64(x)
+		return float64(x), nil
 	case uint32:
-		return float64(x)
+		return float64(x), nil
 	case uint64:
-		return float64(x)
+		return float64(x), nil
 	case float32:
-		return float64(x)
+		return float64(x), nil
 	case float64:
-		return x
+		return x, nil
+	case time.Duration:
+		return float64(x), nil
 	case string:
-		val, err := strconv.ParseUint(x, 10, 16)
-		if err != nil {
-			log.Debugf("err:%v", err)
-			return 0
-		}
-		return float64(val)
+		return strconv.ParseFloat(x, 64)
 	case []byte:
-		val, err := strconv.ParseFloat(string(x), 64)
-		if err != nil {
-			log.Debugf("err:%v", err)
-			return 0
-		}
-		return val
+		return strconv.ParseFloat(string(x), 64)
 	default:
-		log.Panic("unknown how to handle")
-		return 0
+		return 0, fmt.Errorf("%w: %T", ErrCacheTypeMismatch, val)
+	}
+}
+
+func cacheEqual(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// getLocked 调用方需持有写锁，过期的 key 会被直接删除
+func (p *AdapterCache) getLocked(key string) (any, bool) {
+	item, ok := p.m[key]
+	if !ok {
+		return nil, false
+	}
+
+	if item.expired(time.Now()) {
+		item.stop()
+		delete(p.m, key)
+		return nil, false
+	}
+
+	return item.val, true
+}
+
+// expire 到期时由 timer 调用，期间被重新写入（过期时间不同）时不处理
+func (p *AdapterCache) expire(key string, expireAt time.Time) {
+	p.lock.Lock()
+	item, ok := p.m[key]
+	if !ok || !item.expireAt.Equal(expireAt) {
+		p.lock.Unlock()
+		return
 	}
+	delete(p.m, key)
+	p.lock.Unlock()
+
+	p.notify(CacheEvent{
+		Key:     key,
+		Old:     item.val,
+		Deleted: true,
+	})
 }
 
 func (p *AdapterCache) get(key string) (any, error) {
 	p.lock.RLock()
-	defer p.lock.RUnlock()
+	item, ok := p.m[key]
+	p.lock.RUnlock()
+
+	if !ok {
+		return nil, ErrCacheNotFound
+	}
+
+	if item.expired(time.Now()) {
+		p.lock.Lock()
+		// 期间可能已被重新写入
+		if cur, ok := p.m[key]; ok && cur == item {
+			delete(p.m, key)
+		} else {
+			ok = false
+		}
+		p.lock.Unlock()
+
+		if ok {
+			p.notify(CacheEvent{
+				Key:     key,
+				Old:     item.val,
+				Deleted: true,
+			})
+		}
 
-	if val, ok := p.m[key]; ok {
-		return val, nil
+		return nil, ErrCacheNotFound
 	}
 
-	return nil, ErrCacheNotFound
+	return item.val, nil
 }
 
 func (p *AdapterCache) Reset() {
 	p.lock.Lock()
-	defer p.lock.RUnlock()
+	old := p.m
+	p.m = map[string]*cacheItem{}
+	p.lock.Unlock()
 
-	p.m = map[string]any{}
+	now := time.Now()
+	for key, item := range old {
+		item.stop()
+		if item.expired(now) {
+			continue
+		}
+		p.notify(CacheEvent{
+			Key:     key,
+			Old:     item.val,
+			Deleted: true,
+		})
+	}
 }
 
 func (p *AdapterCache) Del(key string) {
-	p.del(key)
+	p.lock.Lock()
+	old, existed := p.getLocked(key)
+	if item, ok := p.m[key]; ok {
+		item.stop()
+	}
+	delete(p.m, key)
+	p.lock.Unlock()
+
+	if existed {
+		p.notify(CacheEvent{
+			Key:     key,
+			Old:     old,
+			Deleted: true,
+		})
+	}
 }
 
-func (p *AdapterCache) del(key string) {
-	p.lock.Lock()
-	defer p.lock.Unlock()
+func (p *AdapterCache) Subscribe(logic func(e CacheEvent)) func() {
+	p.subLock.Lock()
+	p.subId++
+	id := p.subId
+	p.subscribers[id] = logic
+	p.subLock.Unlock()
 
-	delete(p.m, key)
+	return func() {
+		p.subLock.Lock()
+		delete(p.subscribers, id)
+		p.subLock.Unlock()
+	}
+}
+
+func (p *AdapterCache) notify(e CacheEvent) {
+	p.subLock.RLock()
+	subscribers := make([]func(e CacheEvent), 0, len(p.subscribers))
+	for _, logic := range p.subscribers {
+		subscribers = append(subscribers, logic)
+	}
+	p.subLock.RUnlock()
+
+	for _, logic := range subscribers {
+		func() {
+			defer utils.CachePanic()
+			logic(e)
+		}()
+	}
 }
@@ -0,0 +1,153 @@
+package adapter
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdapterCache_Load(t *testing.T) {
+	c := NewAdapterCache()
+	c.Store("int", 120)
+	c.Store("str", "1.5")
+	c.Store("dur", "1m")
+	c.Store("bool", "true")
+	c.Store("big", 70000)
+	c.Store("unknown", struct{}{})
+	
+	if got := c.LoadInt64("int"); got != 120 {
+		t.Errorf("LoadInt64 got %d", got)
+	}
+	if got := c.LoadFloat64("str"); got != 1.5 {
+		t.Errorf("LoadFloat64 got %v", got)
+	}
+	if got := c.LoadDuration("dur"); got != time.Minute {
+		t.Errorf("LoadDuration got %v", got)
+	}
+	if got := c.LoadString("int"); got != "120" {
+		t.Errorf("LoadString got %s", got)
+	}
+	if !c.LoadBool("bool") || !c.LoadBool("int") || c.LoadBool("missing") {
+		t.Errorf("LoadBool mismatch")
+	}
+	if got := c.LoadUint16("big"); got != 65535 {
+		t.Errorf("LoadUint16 got %d", got)
+	}
+	
+	// 未知类型不再 panic
+	if c.LoadUint16("unknown") != 0 || c.LoadFloat64("unknown") != 0 || c.LoadBool("unknown") {
+		t.Errorf("unknown type should be zero")
+	}
+	
+	if v, err := LoadAs[int](c, "int"); err != nil || v != 120 {
+		t.Errorf("LoadAs got %v, err:%v", v, err)
+	}
+	if _, err := LoadAs[string](c, "int"); !errors.Is(err, ErrCacheTypeMismatch) {
+		t.Errorf("LoadAs err:%v", err)
+	}
+	if _, err := LoadAs[string](c, "missing"); !errors.Is(err, ErrCacheNotFound) {
+		t.Errorf("LoadAs err:%v", err)
+	}
+}
+
+// TestAdapterCache_TTL 到期后不需要读取也会通知删除
+func TestAdapterCache_TTL(t *testing.T) {
+	c := NewAdapterCache()
+	
+	events := make(chan CacheEvent, 10)
+	cancel := c.Subscribe(func(e CacheEvent) {
+		events <- e
+	})
+	
+	c.StoreWithTTL("alive", true, time.Millisecond*10)
+	if !c.LoadBool("alive") {
+		t.Errorf("alive should exist")
+	}
+	if e := <-events; e.Deleted || e.New != true {
+		t.Errorf("got event %+v", e)
+	}
+	
+	select {
+	case e := <-events:
+		if !e.Deleted || e.Key != "alive" || e.Old != true {
+			t.Errorf("got event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no deleted event after expire")
+	}
+	if _, err := c.Load("alive"); !errors.Is(err, ErrCacheNotFound) {
+		t.Errorf("alive should expire, err:%v", err)
+	}
+	
+	// 到期前重新写入，不再过期
+	c.StoreWithTTL("delay", 100, time.Millisecond*10)
+	c.Store("delay", 200)
+	time.Sleep(time.Millisecond * 30)
+	if c.LoadUint16("delay") != 200 {
+		t.Errorf("delay should not expire")
+	}
+	
+	cancel()
+	c.Store("alive", true)
+	if len(events) != 2 {
+		t.Errorf("got %d events, want 2", len(events))
+	}
+}
+
+func TestAdapterCache_CompareAndSwap(t *testing.T) {
+	c := NewAdapterCache()
+	
+	var events []CacheEvent
+	c.Subscribe(func(e CacheEvent) {
+		events = append(events, e)
+	})
+	
+	if !c.CompareAndSwap("delay", nil, 100) {
+		t.Errorf("swap missing key should succeed")
+	}
+	if c.CompareAndSwap("delay", 200, 300) {
+		t.Errorf("swap with wrong old should fail")
+	}
+	if !c.CompareAndSwap("delay", 100, 300) {
+		t.Errorf("swap should succeed")
+	}
+	
+	// 值不变不通知
+	c.Store("delay", 300)
+	
+	if len(events) != 2 || events[1].Old != 100 || events[1].New != 300 {
+		t.Errorf("got events %+v", events)
+	}
+	
+	c.Reset()
+	if _, err := c.Load("delay"); err == nil {
+		t.Errorf("reset should clear")
+	}
+	if len(events) != 3 || !events[2].Deleted {
+		t.Errorf("got events %+v", events)
+	}
+}
+
+// TestAdapterCache_CompareAndSwapTTL 替换后仍按原来的时间过期
+func TestAdapterCache_CompareAndSwapTTL(t *testing.T) {
+	c := NewAdapterCache()
+	
+	c.StoreWithTTL("suspect", false, time.Millisecond*10)
+	if !c.CompareAndSwap("suspect", false, true) || !c.LoadBool("suspect") {
+		t.Errorf("swap should succeed")
+	}
+	
+	time.Sleep(time.Millisecond * 20)
+	if _, err := c.Load("suspect"); !errors.Is(err, ErrCacheNotFound) {
+		t.Errorf("suspect should expire, err:%v", err)
+	}
+	
+	// 过期后视为不存在，替换后不再过期
+	if !c.CompareAndSwap("suspect", nil, true) {
+		t.Errorf("swap expired key should succeed")
+	}
+	time.Sleep(time.Millisecond * 20)
+	if !c.LoadBool("suspect") {
+		t.Errorf("suspect should not expire")
+	}
+}
@@ -0,0 +1,250 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/log"
+)
+
+// DialerProxyKey 节点配置中跳板的名称，可以是节点名、UniqueId 或分组名，与 clash.meta 相同
+const DialerProxyKey = "dialer-proxy"
+
+var (
+	ErrDialerLoop     = errors.New("dialer proxy loop")
+	ErrDialerNotFound = errors.New("dialer proxy not found")
+	ErrDialerUDP      = errors.New("udp is not supported through dialer proxy")
+)
+
+// dialTimeout ctx 没有超时（如 GenDialContext 传入的 context.TODO）时，连接跳板和握手的超时
+var dialTimeout = constant.DefaultTCPTimeout
+
+// Dialer 建立到目标的 tcp 连接，节点或分组都可以作为跳板
+type Dialer interface {
+	DialContext(ctx context.Context, metadata *constant.Metadata, opts ...dialer.Option) (constant.Conn, error)
+}
+
+type dialHopsKey struct{}
+
+// DialHops 当前连接已经经过的、使用了跳板的节点
+func DialHops(ctx context.Context) []string {
+	hops, _ := ctx.Value(dialHopsKey{}).([]string)
+	return hops
+}
+
+// withDialHop 记录经过的节点，再次经过时说明跳板形成了环
+func withDialHop(ctx context.Context, uniqueId string) (context.Context, error) {
+	hops := DialHops(ctx)
+	for _, hop := range hops {
+		if hop == uniqueId {
+			return nil, fmt.Errorf("%w: %v -> %s", ErrDialerLoop, hops, uniqueId)
+		}
+	}
+
+	next := make([]string, 0, len(hops)+1)
+	next = append(next, hops...)
+	next = append(next, uniqueId)
+	return context.WithValue(ctx, dialHopsKey{}, next), nil
+}
+
+// addrMetadata 连接 host:port 的 metadata
+func addrMetadata(addr string) *constant.Metadata {
+	host, port := splitHostPort(addr)
+
+	metadata := &constant.Metadata{
+		NetWork: constant.TCP,
+		DstPort: port,
+	}
+
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err != nil:
+		metadata.AddrType = constant.AtypDomainName
+		metadata.Host = host
+	case ip.Is4():
+		metadata.AddrType = constant.AtypIPv4
+		metadata.DstIP = ip
+	default:
+		metadata.AddrType = constant.AtypIPv6
+		metadata.DstIP = ip
+	}
+
+	return metadata
+}
+
+// DialerProxy 配置的跳板名称，为空表示直接连接
+func (p *ProxyAdapter) DialerProxy() string {
+	name, _ := p.opt[DialerProxyKey].(string)
+	return name
+}
+
+// SetDialer 设置跳板，之后通过跳板连接节点的服务器，nil 表示直接连接
+func (p *ProxyAdapter) SetDialer(d Dialer) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.dialer = d
+}
+
+func (p *ProxyAdapter) getDialer() Dialer {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.dialer
+}
+
+// DialContext 设置了跳板时，先通过跳板连接到节点的服务器 Addr()，再在这个连接上握手
+func (p *ProxyAdapter) DialContext(ctx context.Context, metadata *constant.Metadata, opts ...dialer.Option) (constant.Conn, error) {
+	d := p.getDialer()
+	if d == nil {
+		return p.Proxy.DialContext(ctx, metadata, opts...)
+	}
+
+	ctx, err := withDialHop(ctx, p.UniqueId())
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+	}
+
+	c, err := d.DialContext(ctx, addrMetadata(p.Addr()), opts...)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, fmt.Errorf("%s connect error: %w", p.Addr(), err)
+	}
+
+	// StreamConn 没有 ctx，握手的超时使用 ctx 的
+	deadline, _ := ctx.Deadline()
+	_ = c.SetDeadline(deadline)
+
+	sc, err := p.Proxy.StreamConn(c, metadata)
+	if err != nil {
+		_ = c.Close()
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	_ = sc.SetDeadline(time.Time{})
+
+	host, _ := splitHostPort(p.Addr())
+	return &chainConn{
+		Conn:   sc,
+		chain:  append(constant.Chain{p.Name()}, c.Chains()...),
+		remote: host,
+	}, nil
+}
+
+func (p *ProxyAdapter) Dial(metadata *constant.Metadata) (constant.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), constant.DefaultTCPTimeout)
+	defer cancel()
+	return p.DialContext(ctx, metadata)
+}
+
+func (p *ProxyAdapter) ListenPacketContext(ctx context.Context, metadata *constant.Metadata, opts ...dialer.Option) (constant.PacketConn, error) {
+	if p.getDialer() != nil {
+		return nil, ErrDialerUDP
+	}
+	return p.Proxy.ListenPacketContext(ctx, metadata, opts...)
+}
+
+func (p *ProxyAdapter) SupportUDP() bool {
+	return p.getDialer() == nil && p.Proxy.SupportUDP()
+}
+
+// URLTest clash 的 URLTest 直接连接节点，设置了跳板时需要通过 DialContext 连接
+func (p *ProxyAdapter) URLTest(ctx context.Context, rawUrl string) (uint16, error) {
+	if p.getDialer() == nil {
+		return p.Proxy.URLTest(ctx, rawUrl)
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return 0, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+
+	start := time.Now()
+	instance, err := p.DialContext(ctx, addrMetadata(net.JoinHostPort(u.Hostname(), port)))
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return 0, err
+	}
+	defer instance.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawUrl, nil)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return 0, err
+	}
+
+	client := http.Client{
+		Timeout: time.Second * 30,
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return instance, nil
+			},
+			TLSHandshakeTimeout: time.Second * 10,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return 0, err
+	}
+	_ = resp.Body.Close()
+
+	return uint16(time.Since(start) / time.Millisecond), nil
+}
+
+// chainConn 通过跳板建立的连接，Chains 依次为节点和跳板
+type chainConn struct {
+	net.Conn
+
+	chain  constant.Chain
+	remote string
+}
+
+func (c *chainConn) Chains() constant.Chain {
+	return c.chain
+}
+
+func (c *chainConn) AppendToChains(a constant.ProxyAdapter) {
+	c.chain = append(c.chain, a.Name())
+}
+
+func (c *chainConn) RemoteDestination() string {
+	return c.remote
+}
+
+// Unwrap 返回握手后的连接，不改变读写内容
+func (c *chainConn) Unwrap() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,81 @@
+package adapter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/constant"
+)
+
+// silentDialer 连接到只接受连接、从不回复的服务端
+type silentDialer struct {
+	addr string
+}
+
+func (d silentDialer) DialContext(ctx context.Context, metadata *constant.Metadata, opts ...dialer.Option) (constant.Conn, error) {
+	c, err := (&net.Dialer{}).DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &chainConn{Conn: c, chain: constant.Chain{"silent"}}, nil
+}
+
+// TestProxyAdapter_DialTimeout ctx 没有超时时，握手也不会一直阻塞
+func TestProxyAdapter_DialTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer l.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				<-stop
+				_ = c.Close()
+			}()
+		}
+	}()
+
+	old := dialTimeout
+	dialTimeout = time.Millisecond * 200
+	defer func() {
+		dialTimeout = old
+	}()
+
+	p, err := ParseClash(map[string]any{
+		"name":         "exit",
+		"type":         "socks5",
+		"server":       "127.0.0.1",
+		"port":         l.Addr().(*net.TCPAddr).Port,
+		DialerProxyKey: "silent",
+	})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	p.SetDialer(silentDialer{addr: l.Addr().String()})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.DialContext(context.TODO(), addrMetadata("127.0.0.1:80"))
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Errorf("handshake with silent server should fail")
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("handshake not timeout")
+	}
+}
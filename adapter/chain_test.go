@@ -0,0 +1,141 @@
+package adapter_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/internal/testutil"
+)
+
+func TestProxyAdapter_DialerProxy(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	jumpServer := testutil.NewSocks5Server(t)
+	defer jumpServer.Close()
+	exitServer := testutil.NewSocks5Server(t)
+	defer exitServer.Close()
+
+	jump := jumpServer.Node(t, "jump", "")
+	exit := exitServer.Node(t, "exit", "jump")
+
+	if exit.DialerProxy() != "jump" || jump.DialerProxy() != "" {
+		t.Fatalf("dialer proxy got %q %q", exit.DialerProxy(), jump.DialerProxy())
+	}
+	// 跳板不同视为不同节点
+	if exit.UniqueId() == exitServer.Node(t, "exit", "").UniqueId() {
+		t.Errorf("unique id should include dialer proxy")
+	}
+
+	exit.SetDialer(jump)
+
+	// Addr 仍然是节点自己的服务器，而不是跳板
+	if exit.Addr() != exitServer.Addr().String() {
+		t.Errorf("addr got %s, want %s", exit.Addr(), exitServer.Addr())
+	}
+	if exit.SupportUDP() {
+		t.Errorf("udp should not be supported through dialer proxy")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := exit.DialContext(ctx, testutil.TcpMetadata(echo.Addr().String()))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, err:%v", buf, err)
+	}
+
+	if got := conn.Chains(); len(got) != 2 || got[0] != "exit" || got[1] != "jump" {
+		t.Errorf("chains got %v", got)
+	}
+	if conn.RemoteDestination() != "127.0.0.1" {
+		t.Errorf("remote destination got %s", conn.RemoteDestination())
+	}
+
+	// 跳板连接的是 exit 的服务器，exit 连接的是目标
+	if got := jumpServer.Targets(); len(got) != 1 || got[0] != exitServer.Addr().String() {
+		t.Errorf("jump targets got %v", got)
+	}
+	if got := exitServer.Targets(); len(got) != 1 || got[0] != echo.Addr().String() {
+		t.Errorf("exit targets got %v", got)
+	}
+}
+
+func TestProxyAdapter_DialerProxyURLTest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	jumpServer := testutil.NewSocks5Server(t)
+	defer jumpServer.Close()
+	exitServer := testutil.NewSocks5Server(t)
+	defer exitServer.Close()
+
+	exit := exitServer.Node(t, "exit", "jump")
+	exit.SetDialer(jumpServer.Node(t, "jump", ""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// 健康检查也经过跳板
+	_, err := exit.URLTest(ctx, srv.URL)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(jumpServer.Targets()) != 1 {
+		t.Errorf("health check not through dialer proxy, jump targets %v", jumpServer.Targets())
+	}
+
+	// 跳板不可用时健康检查失败，而不是绕过跳板直接连接
+	jumpServer.Close()
+	_, err = exit.URLTest(ctx, srv.URL)
+	if err == nil {
+		t.Errorf("health check should fail without dialer proxy")
+	}
+	if len(exitServer.Targets()) != 1 {
+		t.Errorf("exit targets got %v", exitServer.Targets())
+	}
+}
+
+func TestProxyAdapter_DialerProxyLoop(t *testing.T) {
+	aServer := testutil.NewSocks5Server(t)
+	defer aServer.Close()
+	bServer := testutil.NewSocks5Server(t)
+	defer bServer.Close()
+
+	a := aServer.Node(t, "a", "b")
+	b := bServer.Node(t, "b", "a")
+	a.SetDialer(b)
+	b.SetDialer(a)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := a.DialContext(ctx, testutil.TcpMetadata("127.0.0.1:80"))
+	if !errors.Is(err, adapter.ErrDialerLoop) {
+		t.Errorf("got err %v, want %v", err, adapter.ErrDialerLoop)
+	}
+
+	// 发现环时还没有建立任何连接
+	if len(aServer.Targets())+len(bServer.Targets()) != 0 {
+		t.Errorf("targets got %v %v", aServer.Targets(), bServer.Targets())
+	}
+}
@@ -0,0 +1,226 @@
+package adapter
+
+import (
+	"net"
+	"sort"
+	"strings"
+	
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/utils"
+)
+
+// DedupPolicy 比较两个重复节点，返回负数表示 a 更应该保留，0 表示无法区分
+type DedupPolicy func(a, b AdapterProxy) int
+
+// DedupByDelay 存活且延迟更低的节点优先
+func DedupByDelay(a, b AdapterProxy) int {
+	score := func(p AdapterProxy) int {
+		delay := int(p.LoadUint16(CacheDelay))
+		if !p.LoadBool(CacheAlive) || delay == 0 {
+			return 1 << 16
+		}
+		return delay
+	}
+	return score(a) - score(b)
+}
+
+// DedupByNewest 加入时间更晚的节点优先
+func DedupByNewest(a, b AdapterProxy) int {
+	at, bt := a.LoadFloat64(CacheAddedAt), b.LoadFloat64(CacheAddedAt)
+	switch {
+	case at > bt:
+		return -1
+	case at < bt:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// DedupBySource 来源在 sources 中越靠前越优先，不在其中的排在最后
+func DedupBySource(sources ...string) DedupPolicy {
+	rank := func(p AdapterProxy) int {
+		val, err := p.Load(CacheSource)
+		if err != nil {
+			return len(sources)
+		}
+		
+		source, _ := val.(string)
+		for i, s := range sources {
+			if s == source {
+				return i
+			}
+		}
+		return len(sources)
+	}
+	
+	return func(a, b AdapterProxy) int {
+		return rank(a) - rank(b)
+	}
+}
+
+// DedupChain 依次使用多个策略，直到能区分为止
+func DedupChain(policies ...DedupPolicy) DedupPolicy {
+	return func(a, b AdapterProxy) int {
+		for _, policy := range policies {
+			if c := policy(a, b); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
+
+type DedupOption struct {
+	// Resolver 解析节点域名，为空时使用 net.LookupHost，解析失败则按域名本身比较
+	Resolver func(host string) ([]string, error)
+	
+	// Policy 选出保留节点的策略，为空时使用 DedupByDelay
+	Policy DedupPolicy
+}
+
+// DedupGroup 一组被判定为重复的节点
+type DedupGroup struct {
+	// Key 近似身份的 sha256，身份中包含凭据，不直接展示
+	Key      string
+	Survivor AdapterProxy
+	Merged   ProxyList
+}
+
+// 只影响展示或伪装、不改变连接目标的字段
+// sni、ws-host、h2-host 在 cdn 中转时决定回源的节点，不能忽略
+var dedupCosmeticKeys = map[string]bool{
+	"server":      true,
+	"alter-id":    true,
+	"plugin-host": true,
+	"obfs-host":   true,
+}
+
+// fuzzyIdentity 在 canonicalIdentity 的基础上去掉展示字段，用解析后的地址代替 server
+func fuzzyIdentity(p AdapterProxy, resolve func(host string) string) string {
+	opt := p.ToNico()
+	server := strings.ToLower(strings.Trim(newExportOpt(opt).GetString("server"), "[]"))
+	
+	fields := map[string]string{}
+	for _, line := range strings.Split(canonicalIdentity(opt), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || dedupCosmeticKeys[kv[0]] {
+			continue
+		}
+		
+		switch kv[0] {
+		case "ws-path", "h2-path":
+			kv[1] = strings.ToLower(kv[1])
+		}
+		
+		fields[kv[0]] = kv[1]
+	}
+	
+	// canonicalIdentity 中与 server 相同的 sni、未设置的 ws Host 被省略，实际使用的是 server，
+	// 去掉 server 后需要补回，否则同一 IP 上的不同域名会被合并
+	if fields["tls"] == "1" && fields["sni"] == "" {
+		fields["sni"] = server
+	}
+	if fields["network"] == "ws" && fields["ws-host"] == "" {
+		fields["ws-host"] = server
+	}
+	
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	
+	var b strings.Builder
+	b.WriteString("addr=")
+	b.WriteString(resolve(server))
+	b.WriteString("\n")
+	
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(fields[key])
+		b.WriteString("\n")
+	}
+	
+	return b.String()
+}
+
+// Dedup 合并近似重复的节点（解析后地址、端口和凭据相同，仅名称、alterId、path 大小写等不同），
+// 返回去重后的列表（保持原有顺序）以及每组的合并情况
+func (ss ProxyList) Dedup(opt DedupOption) (ProxyList, []*DedupGroup) {
+	if opt.Resolver == nil {
+		opt.Resolver = net.LookupHost
+	}
+	
+	if opt.Policy == nil {
+		opt.Policy = DedupByDelay
+	}
+	
+	resolved := map[string]string{}
+	resolve := func(host string) string {
+		if addr, ok := resolved[host]; ok {
+			return addr
+		}
+		
+		addr := host
+		if ip := net.ParseIP(host); ip == nil {
+			addrs, err := opt.Resolver(host)
+			if err != nil || len(addrs) == 0 {
+				log.Debugf("resolve %s fail:%v", host, err)
+			} else {
+				sort.Strings(addrs)
+				addr = addrs[0]
+			}
+		} else {
+			addr = ip.String()
+		}
+		
+		resolved[host] = addr
+		return addr
+	}
+	
+	var keys []string
+	groupMap := map[string]*DedupGroup{}
+	for _, proxy := range ss {
+		key := utils.Sha256(fuzzyIdentity(proxy, resolve))
+		
+		group, ok := groupMap[key]
+		if !ok {
+			groupMap[key] = &DedupGroup{
+				Key:      key,
+				Survivor: proxy,
+			}
+			keys = append(keys, key)
+			continue
+		}
+		
+		if opt.Policy(proxy, group.Survivor) < 0 {
+			group.Merged = append(group.Merged, group.Survivor)
+			group.Survivor = proxy
+		} else {
+			group.Merged = append(group.Merged, proxy)
+		}
+	}
+	
+	survivors := map[AdapterProxy]bool{}
+	var groups []*DedupGroup
+	for _, key := range keys {
+		group := groupMap[key]
+		survivors[group.Survivor] = true
+		
+		if len(group.Merged) > 0 {
+			log.Infof("dedup %s, merged %d nodes", group.Survivor.Name(), len(group.Merged))
+			groups = append(groups, group)
+		}
+	}
+	
+	var list ProxyList
+	for _, proxy := range ss {
+		if survivors[proxy] {
+			list = append(list, proxy)
+		}
+	}
+	
+	return list, groups
+}
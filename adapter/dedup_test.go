@@ -0,0 +1,126 @@
+package adapter_test
+
+import (
+	"strings"
+	"testing"
+	
+	"github.com/darabuchi/nico/adapter"
+)
+
+// TestProxyList_Dedup a、b、c 的 Host 相同、解析到同一地址，只有 server、alterId、path 大小写不同
+func TestProxyList_Dedup(t *testing.T) {
+	var list adapter.ProxyList
+	for _, m := range []map[string]any{
+		{"name": "a", "type": "vmess", "server": "a.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "network": "ws", "ws-opts": map[string]any{"path": "/Ray", "headers": map[string]any{"Host": "a.example.com"}}},
+		{"name": "b", "type": "vmess", "server": "b.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 2, "cipher": "auto", "network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "a.example.com"}}},
+		{"name": "c", "type": "vmess", "server": "1.1.1.1", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "a.example.com"}}},
+		{"name": "d", "type": "vmess", "server": "a.example.com", "port": 443, "uuid": "9c5d2ff7-42d5-4fae-9f4c-5e6d0b4fd6e1", "alterId": 0, "cipher": "auto", "network": "ws", "ws-opts": map[string]any{"path": "/ray"}},
+		{"name": "e", "type": "trojan", "server": "a.example.com", "port": 443, "password": "pass"},
+	} {
+		p, err := adapter.ParseClash(m)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		list = append(list, p)
+	}
+	
+	list[0].Store(adapter.CacheAlive, true)
+	list[0].Store(adapter.CacheDelay, 300)
+	list[1].Store(adapter.CacheAlive, true)
+	list[1].Store(adapter.CacheDelay, 100)
+	list[1].Store(adapter.CacheAddedAt, 1)
+	list[2].Store(adapter.CacheAddedAt, 2)
+	list[2].Store(adapter.CacheSource, "preferred")
+	
+	resolver := func(host string) ([]string, error) {
+		return []string{"1.1.1.1"}, nil
+	}
+	
+	tests := []struct {
+		name     string
+		policy   adapter.DedupPolicy
+		survivor string
+	}{
+		{
+			name:     "delay",
+			policy:   adapter.DedupByDelay,
+			survivor: "b",
+		},
+		{
+			name:     "newest",
+			policy:   adapter.DedupByNewest,
+			survivor: "c",
+		},
+		{
+			name:     "source",
+			policy:   adapter.DedupChain(adapter.DedupBySource("preferred"), adapter.DedupByDelay),
+			survivor: "c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, groups := list.Dedup(adapter.DedupOption{
+				Resolver: resolver,
+				Policy:   tt.policy,
+			})
+			
+			if len(got) != 3 {
+				t.Errorf("got %d nodes, want 3", len(got))
+			}
+			
+			if len(groups) != 1 {
+				t.Errorf("got %d groups, want 1", len(groups))
+				return
+			}
+			
+			if groups[0].Survivor.Name() != tt.survivor {
+				t.Errorf("got survivor %s, want %s", groups[0].Survivor.Name(), tt.survivor)
+			}
+			
+			if len(groups[0].Merged) != 2 {
+				t.Errorf("got %d merged, want 2", len(groups[0].Merged))
+			}
+		})
+	}
+}
+
+// TestProxyList_DedupFronting 经过 cdn 中转的节点地址相同，由 sni、host 区分回源，不能合并
+func TestProxyList_DedupFronting(t *testing.T) {
+	var list adapter.ProxyList
+	for _, m := range []map[string]any{
+		{"name": "a", "type": "vmess", "server": "cdn.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "tls": true, "servername": "a.example.com", "network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "a.example.com"}}},
+		{"name": "b", "type": "vmess", "server": "cdn.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "tls": true, "servername": "b.example.com", "network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "b.example.com"}}},
+		{"name": "c", "type": "trojan", "server": "cdn.example.com", "port": 443, "password": "secret-pass", "sni": "a.example.com"},
+		{"name": "d", "type": "trojan", "server": "cdn.example.com", "port": 443, "password": "secret-pass", "sni": "a.example.com"},
+		// 没有 sni 时使用 server：e 与 c、d 实际的 sni 相同，可以合并；f 与 e 的域名不同，不能合并
+		{"name": "e", "type": "trojan", "server": "a.example.com", "port": 443, "password": "secret-pass"},
+		{"name": "f", "type": "trojan", "server": "b.example.com", "port": 443, "password": "secret-pass"},
+		{"name": "g", "type": "vmess", "server": "a.example.com", "port": 80, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "network": "ws", "ws-opts": map[string]any{"path": "/ray"}},
+		{"name": "h", "type": "vmess", "server": "b.example.com", "port": 80, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "network": "ws", "ws-opts": map[string]any{"path": "/ray"}},
+	} {
+		p, err := adapter.ParseClash(m)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		list = append(list, p)
+	}
+	
+	got, groups := list.Dedup(adapter.DedupOption{
+		Resolver: func(host string) ([]string, error) {
+			return []string{"1.1.1.1"}, nil
+		},
+	})
+	
+	if len(got) != 6 {
+		t.Errorf("got %d nodes, want 6", len(got))
+	}
+	
+	if len(groups) != 1 || len(groups[0].Merged) != 2 {
+		t.Fatalf("got groups %+v, want 1", groups)
+	}
+	
+	// Key 不包含明文凭据
+	if strings.Contains(groups[0].Key, "secret-pass") || len(groups[0].Key) != 64 {
+		t.Errorf("got key %s", groups[0].Key)
+	}
+}
@@ -0,0 +1,173 @@
+package adapter
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+	
+	"github.com/Dreamacro/clash/constant"
+)
+
+// DelayRecord 一次延迟探测，Delay 为 0 表示失败
+type DelayRecord struct {
+	Time  time.Time `json:"time"`
+	Delay uint16    `json:"delay"`
+}
+
+// DelayStats 最近探测的统计，延迟只统计成功的探测，单位 ms
+type DelayStats struct {
+	Count       int
+	SuccessRate float64
+	
+	Last uint16
+	P50  uint16
+	P95  uint16
+	// Jitter 相邻两次成功探测的延迟差的平均值
+	Jitter float64
+}
+
+// DelayHistory 固定容量的环形缓冲，保存最近的探测记录
+type DelayHistory struct {
+	lock    sync.RWMutex
+	records []DelayRecord
+	next    int
+	full    bool
+}
+
+func NewDelayHistory(size int) *DelayHistory {
+	if size <= 0 {
+		size = MaxHistory
+	}
+	
+	return &DelayHistory{
+		records: make([]DelayRecord, size),
+	}
+}
+
+func (p *DelayHistory) Record(r DelayRecord) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	
+	p.records[p.next] = r
+	p.next = (p.next + 1) % len(p.records)
+	if p.next == 0 {
+		p.full = true
+	}
+}
+
+// Records 按时间先后返回
+func (p *DelayHistory) Records() []DelayRecord {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	
+	if !p.full {
+		return append([]DelayRecord(nil), p.records[:p.next]...)
+	}
+	
+	records := make([]DelayRecord, 0, len(p.records))
+	records = append(records, p.records[p.next:]...)
+	records = append(records, p.records[:p.next]...)
+	return records
+}
+
+func (p *DelayHistory) Stats() DelayStats {
+	return NewDelayStats(p.Records())
+}
+
+func NewDelayStats(records []DelayRecord) DelayStats {
+	var stats DelayStats
+	stats.Count = len(records)
+	if stats.Count == 0 {
+		return stats
+	}
+	
+	var delays []int
+	var jitterSum float64
+	for _, r := range records {
+		if r.Delay == 0 {
+			continue
+		}
+		
+		if len(delays) > 0 {
+			jitterSum += math.Abs(float64(int(r.Delay) - delays[len(delays)-1]))
+		}
+		delays = append(delays, int(r.Delay))
+	}
+	
+	stats.Last = records[len(records)-1].Delay
+	stats.SuccessRate = float64(len(delays)) / float64(stats.Count)
+	
+	if len(delays) == 0 {
+		return stats
+	}
+	
+	if len(delays) > 1 {
+		stats.Jitter = jitterSum / float64(len(delays)-1)
+	}
+	
+	sort.Ints(delays)
+	stats.P50 = uint16(percentile(delays, 50))
+	stats.P95 = uint16(percentile(delays, 95))
+	
+	return stats
+}
+
+// percentile nearest-rank，sorted 需已排序
+func percentile(sorted []int, p float64) int {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// ScoreWeight 评分中延迟和速度所占的权重，作为各自因子的指数，为 0 时忽略该项
+type ScoreWeight struct {
+	Latency float64 `yaml:"latency" json:"latency"`
+	Speed   float64 `yaml:"speed" json:"speed"`
+}
+
+var DefaultScoreWeight = ScoreWeight{
+	Latency: 1,
+	Speed:   1,
+}
+
+// Score 综合成功率、延迟、抖动和速度给节点打分，越高越好，没有成功探测时为 0
+//
+// 延迟取 0.6*p50 + 0.4*p95 + jitter，成功率取平方以放大不稳定的惩罚，
+// 探测次数少时乘以 n/(n+2) 的置信度，避免只成功过一次的节点排在长期稳定的节点前面，
+// 速度（KB/s）按对数加成
+func Score(stats DelayStats, speed float64) float64 {
+	return WeightedScore(stats, speed, DefaultScoreWeight)
+}
+
+// WeightedScore 与 Score 相同，但延迟和速度因子按 w 加权
+func WeightedScore(stats DelayStats, speed float64, w ScoreWeight) float64 {
+	if stats.Count == 0 || stats.SuccessRate == 0 {
+		return 0
+	}
+	
+	latency := 0.6*float64(stats.P50) + 0.4*float64(stats.P95) + stats.Jitter
+	
+	score := stats.SuccessRate * stats.SuccessRate
+	score *= float64(stats.Count) / float64(stats.Count+2)
+	score *= 10 * math.Pow(100/(100+latency), w.Latency)
+	
+	if speed > 0 {
+		score *= math.Pow(1+math.Log10(1+speed)/4, w.Speed)
+	}
+	
+	return score
+}
+
+func toClashDelayHistory(records []DelayRecord) []constant.DelayHistory {
+	history := make([]constant.DelayHistory, 0, len(records))
+	for _, r := range records {
+		history = append(history, constant.DelayHistory{
+			Time:  r.Time,
+			Delay: r.Delay,
+		})
+	}
+	return history
+}
@@ -0,0 +1,118 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayHistory(t *testing.T) {
+	h := NewDelayHistory(4)
+	if len(h.Records()) != 0 {
+		t.Errorf("should be empty")
+	}
+	
+	for i, delay := range []uint16{100, 0, 120, 110, 130, 0} {
+		h.Record(DelayRecord{Time: time.Unix(int64(i), 0), Delay: delay})
+	}
+	
+	records := h.Records()
+	if len(records) != 4 {
+		t.Errorf("got %d records", len(records))
+		return
+	}
+	
+	for i, want := range []uint16{120, 110, 130, 0} {
+		if records[i].Delay != want {
+			t.Errorf("%d: got %d, want %d", i, records[i].Delay, want)
+		}
+	}
+}
+
+func TestNewDelayStats(t *testing.T) {
+	var records []DelayRecord
+	for _, delay := range []uint16{100, 0, 120, 110, 130, 0, 100, 140, 90, 150} {
+		records = append(records, DelayRecord{Delay: delay})
+	}
+	
+	stats := NewDelayStats(records)
+	if stats.Count != 10 || stats.SuccessRate != 0.8 {
+		t.Errorf("got %+v", stats)
+	}
+	if stats.P50 != 110 || stats.P95 != 150 || stats.Last != 150 {
+		t.Errorf("got %+v", stats)
+	}
+	// |120-100|+|110-120|+|130-110|+|100-130|+|140-100|+|90-140|+|150-90| = 230
+	if stats.Jitter != 230.0/7 {
+		t.Errorf("got jitter %v", stats.Jitter)
+	}
+	
+	if stats := NewDelayStats(nil); stats.Count != 0 || Score(stats, 1000) != 0 {
+		t.Errorf("empty stats got %+v", stats)
+	}
+}
+
+func TestScore(t *testing.T) {
+	stable := make([]DelayRecord, 0, 20)
+	for i := 0; i < 20; i++ {
+		stable = append(stable, DelayRecord{Delay: 150})
+	}
+	
+	lucky := []DelayRecord{{Delay: 50}}
+	
+	flaky := make([]DelayRecord, 0, 20)
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			flaky = append(flaky, DelayRecord{Delay: 80})
+		} else {
+			flaky = append(flaky, DelayRecord{})
+		}
+	}
+	
+	jittery := make([]DelayRecord, 0, 20)
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			jittery = append(jittery, DelayRecord{Delay: 50})
+		} else {
+			jittery = append(jittery, DelayRecord{Delay: 400})
+		}
+	}
+	
+	stableScore := Score(NewDelayStats(stable), 0)
+	for name, records := range map[string][]DelayRecord{
+		"lucky":   lucky,
+		"flaky":   flaky,
+		"jittery": jittery,
+	} {
+		if score := Score(NewDelayStats(records), 0); score >= stableScore {
+			t.Errorf("%s score %v should be lower than stable %v", name, score, stableScore)
+		}
+	}
+	
+	if Score(NewDelayStats(stable), 1024) <= stableScore {
+		t.Errorf("speed should increase score")
+	}
+}
+
+func TestWeightedScore(t *testing.T) {
+	fast := NewDelayStats([]DelayRecord{{Delay: 50}, {Delay: 50}, {Delay: 50}})
+	slow := NewDelayStats([]DelayRecord{{Delay: 400}, {Delay: 400}, {Delay: 400}})
+	
+	if WeightedScore(fast, 100, DefaultScoreWeight) != Score(fast, 100) {
+		t.Errorf("default weight should equal Score")
+	}
+	
+	// 只看速度时，慢但带宽大的节点排在前面
+	speedOnly := ScoreWeight{Speed: 1}
+	if WeightedScore(slow, 4096, speedOnly) <= WeightedScore(fast, 128, speedOnly) {
+		t.Errorf("speed only weight should prefer faster throughput")
+	}
+	
+	// 只看延迟时，忽略速度
+	latencyOnly := ScoreWeight{Latency: 1}
+	if WeightedScore(fast, 0, latencyOnly) != WeightedScore(fast, 4096, latencyOnly) {
+		t.Errorf("latency only weight should ignore speed")
+	}
+	if WeightedScore(slow, 4096, latencyOnly) >= WeightedScore(fast, 128, latencyOnly) {
+		t.Errorf("latency only weight should prefer lower latency")
+	}
+}
@@ -0,0 +1,125 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/utils"
+)
+
+var (
+	ErrUnsupportedFeature = errors.New("unsupported feature")
+)
+
+// UnsupportedError 目标格式无法表达节点的某个特性
+type UnsupportedError struct {
+	Format  string
+	Type    string
+	Feature string
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Feature == "" {
+		return fmt.Sprintf("%s does not support %s", e.Format, e.Type)
+	}
+	return fmt.Sprintf("%s does not support %s with %s", e.Format, e.Type, e.Feature)
+}
+
+func (e *UnsupportedError) Unwrap() error {
+	return ErrUnsupportedFeature
+}
+
+func unsupported(format, typ, feature string) error {
+	return &UnsupportedError{
+		Format:  format,
+		Type:    typ,
+		Feature: feature,
+	}
+}
+
+// exportOpt 对导出时用到的节点字段做统一读取，兼容 clash map 与链接解析出来的两种结构
+type exportOpt struct {
+	*utils.Map
+}
+
+func newExportOpt(opt map[string]any) *exportOpt {
+	return &exportOpt{
+		Map: utils.NewMap(opt).EnableCut("."),
+	}
+}
+
+func (p *exportOpt) network() string {
+	switch network := strings.ToLower(p.GetString("network")); network {
+	case "", "tcp":
+		return "tcp"
+	default:
+		return network
+	}
+}
+
+func (p *exportOpt) wsPath() string {
+	if path := p.GetString("ws-opts.path"); path != "" {
+		return path
+	}
+	return p.GetString("ws-path")
+}
+
+func (p *exportOpt) wsHost() string {
+	for _, key := range []string{"ws-opts.headers.Host", "ws-opts.headers.host", "ws-headers.Host", "ws-headers.host"} {
+		if host := p.GetString(key); host != "" {
+			return host
+		}
+	}
+	return ""
+}
+
+func (p *exportOpt) serverName() string {
+	if sni := p.GetString("servername"); sni != "" {
+		return sni
+	}
+	return p.GetString("sni")
+}
+
+func (p *exportOpt) alpn() []string {
+	var alpn []string
+	for _, val := range p.GetStringSlice("alpn") {
+		if val != "" {
+			alpn = append(alpn, val)
+		}
+	}
+
+	// hysteria 的 alpn 为字符串
+	if len(alpn) == 0 && p.GetString("type") == "hysteria" && p.GetString("alpn") != "" {
+		alpn = append(alpn, p.GetString("alpn"))
+	}
+
+	return alpn
+}
+
+func checkExportName(format, typ, name string) error {
+	if strings.ContainsAny(name, ",=\n") {
+		return unsupported(format, typ, fmt.Sprintf("name %q", name))
+	}
+	return nil
+}
+
+func joinExport(format string, ss ProxyList, logic func(proxy AdapterProxy) (string, error)) ([]string, error) {
+	var lines []string
+	for _, proxy := range ss {
+		line, err := logic(proxy)
+		if err != nil {
+			if errors.Is(err, ErrUnsupportedFeature) {
+				log.Warnf("skip %s for %s:%v", proxy.Name(), format, err)
+				continue
+			}
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
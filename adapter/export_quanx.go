@@ -0,0 +1,176 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+)
+
+const formatQuanX = "quantumult x"
+
+func (p *ProxyAdapter) Sub4QuanX() (string, error) {
+	opt := newExportOpt(p.cloneOpt())
+	typ := opt.GetString("type")
+
+	err := checkExportName(formatQuanX, typ, p.Name())
+	if err != nil {
+		return "", err
+	}
+
+	var params []string
+	add := func(key string, value any) {
+		params = append(params, fmt.Sprintf("%s=%v", key, value))
+	}
+
+	tlsParams := func() {
+		add("tls-verification", !opt.GetBool("skip-cert-verify"))
+		if sni := opt.serverName(); sni != "" {
+			add("tls-host", sni)
+		}
+	}
+
+	// ws 需要区分是否套了 tls
+	obfsParams := func(tls bool) error {
+		switch network := opt.network(); network {
+		case "tcp":
+			if tls {
+				add("over-tls", true)
+				tlsParams()
+			}
+		case "ws":
+			if tls {
+				add("obfs", "wss")
+			} else {
+				add("obfs", "ws")
+			}
+			if host := opt.wsHost(); host != "" {
+				add("obfs-host", host)
+			}
+			if path := opt.wsPath(); path != "" {
+				add("obfs-uri", path)
+			}
+			if tls {
+				tlsParams()
+			}
+		default:
+			return unsupported(formatQuanX, typ, "network "+network)
+		}
+		return nil
+	}
+
+	var head string
+	switch typ {
+	case "ss":
+		head = "shadowsocks"
+		add("method", opt.GetString("cipher"))
+		add("password", opt.GetString("password"))
+
+		switch plugin := opt.GetString("plugin"); plugin {
+		case "":
+		case "obfs":
+			add("obfs", opt.GetString("plugin-opts.mode"))
+			if host := opt.GetString("plugin-opts.host"); host != "" {
+				add("obfs-host", host)
+			}
+		case "v2ray-plugin":
+			if opt.GetString("plugin-opts.mode") != "websocket" {
+				return "", unsupported(formatQuanX, typ, "v2ray-plugin mode "+opt.GetString("plugin-opts.mode"))
+			}
+			if opt.GetBool("plugin-opts.tls") {
+				add("obfs", "wss")
+			} else {
+				add("obfs", "ws")
+			}
+			if host := opt.GetString("plugin-opts.host"); host != "" {
+				add("obfs-host", host)
+			}
+			if path := opt.GetString("plugin-opts.path"); path != "" {
+				add("obfs-uri", path)
+			}
+		default:
+			return "", unsupported(formatQuanX, typ, "plugin "+plugin)
+		}
+
+		add("udp-relay", opt.GetBool("udp"))
+	case "ssr":
+		head = "shadowsocks"
+		add("method", opt.GetString("cipher"))
+		add("password", opt.GetString("password"))
+		add("ssr-protocol", opt.GetString("protocol"))
+		if param := opt.GetString("protocol-param"); param != "" {
+			add("ssr-protocol-param", param)
+		}
+		add("obfs", opt.GetString("obfs"))
+		if param := opt.GetString("obfs-param"); param != "" {
+			add("obfs-host", param)
+		}
+		add("udp-relay", opt.GetBool("udp"))
+	case "vmess":
+		head = "vmess"
+
+		method := opt.GetString("cipher")
+		switch method {
+		case "", "auto":
+			method = "chacha20-poly1305"
+		case "none", "chacha20-poly1305", "aes-128-gcm":
+		default:
+			return "", unsupported(formatQuanX, typ, "cipher "+method)
+		}
+		add("method", method)
+		add("password", opt.GetString("uuid"))
+
+		err = obfsParams(opt.GetBool("tls"))
+		if err != nil {
+			return "", err
+		}
+
+		if opt.GetInt("alterId") != 0 {
+			add("aead", false)
+		}
+	case "trojan":
+		head = "trojan"
+		add("password", opt.GetString("password"))
+
+		err = obfsParams(true)
+		if err != nil {
+			return "", err
+		}
+	case "http":
+		head = "http"
+		if username := opt.GetString("username"); username != "" {
+			add("username", username)
+			add("password", opt.GetString("password"))
+		}
+		if opt.GetBool("tls") {
+			add("over-tls", true)
+			tlsParams()
+		}
+	case "socks5":
+		head = "socks5"
+		if username := opt.GetString("username"); username != "" {
+			add("username", username)
+			add("password", opt.GetString("password"))
+		}
+		if opt.GetBool("tls") {
+			add("over-tls", true)
+			tlsParams()
+		}
+	default:
+		return "", unsupported(formatQuanX, typ, "")
+	}
+
+	add("tag", p.Name())
+
+	return fmt.Sprintf("%s=%s:%d, %s", head, opt.GetString("server"), opt.GetInt("port"), strings.Join(params, ", ")), nil
+}
+
+// Sub4QuanX 导出 Quantumult X 的 [server_local] 段，无法表达的节点会被跳过
+func (ss ProxyList) Sub4QuanX() (string, error) {
+	lines, err := joinExport(formatQuanX, ss, func(proxy AdapterProxy) (string, error) {
+		return proxy.Sub4QuanX()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "[server_local]\n" + strings.Join(lines, "\n") + "\n", nil
+}
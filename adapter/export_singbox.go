@@ -0,0 +1,283 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/darabuchi/log"
+)
+
+const formatSingBox = "sing-box"
+
+var singBoxMbpsRe = regexp.MustCompile(`^\s*([0-9]+)`)
+
+func (p *ProxyAdapter) singBoxOutbound() (map[string]any, error) {
+	opt := newExportOpt(p.cloneOpt())
+	typ := opt.GetString("type")
+
+	out := map[string]any{
+		"tag":         p.Name(),
+		"server":      opt.GetString("server"),
+		"server_port": opt.GetInt("port"),
+	}
+
+	tlsOpt := func(enabled bool) map[string]any {
+		m := map[string]any{
+			"enabled": enabled,
+		}
+		if sni := opt.serverName(); sni != "" {
+			m["server_name"] = sni
+		}
+		if opt.GetBool("skip-cert-verify") {
+			m["insecure"] = true
+		}
+		if alpn := opt.alpn(); len(alpn) > 0 {
+			m["alpn"] = alpn
+		}
+		if fp := opt.GetString("client-fingerprint"); fp != "" {
+			m["utls"] = map[string]any{
+				"enabled":     true,
+				"fingerprint": fp,
+			}
+		}
+		if pbk := opt.GetString("reality-opts.public-key"); pbk != "" {
+			m["reality"] = map[string]any{
+				"enabled":    true,
+				"public_key": pbk,
+				"short_id":   opt.GetString("reality-opts.short-id"),
+			}
+		}
+		return m
+	}
+
+	transport := func() (map[string]any, error) {
+		switch network := opt.network(); network {
+		case "tcp":
+			return nil, nil
+		case "ws":
+			m := map[string]any{
+				"type": "ws",
+				"path": opt.wsPath(),
+			}
+			if host := opt.wsHost(); host != "" {
+				m["headers"] = map[string]any{
+					"Host": host,
+				}
+			}
+			if early := opt.GetInt("ws-opts.max-early-data"); early > 0 {
+				m["max_early_data"] = early
+				m["early_data_header_name"] = opt.GetString("ws-opts.early-data-header-name")
+			}
+			return m, nil
+		case "grpc":
+			return map[string]any{
+				"type":         "grpc",
+				"service_name": opt.GetString("grpc-opts.grpc-service-name"),
+			}, nil
+		case "h2":
+			m := map[string]any{
+				"type": "http",
+				"path": opt.GetString("h2-opts.path"),
+			}
+			if host := opt.GetStringSlice("h2-opts.host"); len(host) > 0 {
+				m["host"] = host
+			}
+			return m, nil
+		case "http":
+			m := map[string]any{
+				"type": "http",
+			}
+			if method := opt.GetString("http-opts.method"); method != "" {
+				m["method"] = method
+			}
+			if path := opt.GetStringSlice("http-opts.path"); len(path) > 0 {
+				m["path"] = path[0]
+			}
+			return m, nil
+		default:
+			return nil, unsupported(formatSingBox, typ, "network "+network)
+		}
+	}
+
+	withTransport := func() error {
+		t, err := transport()
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			out["transport"] = t
+		}
+		return nil
+	}
+
+	switch typ {
+	case "ss":
+		out["type"] = "shadowsocks"
+		out["method"] = opt.GetString("cipher")
+		out["password"] = opt.GetString("password")
+
+		switch plugin := opt.GetString("plugin"); plugin {
+		case "":
+		case "obfs":
+			out["plugin"] = "obfs-local"
+			pluginOpts := "obfs=" + opt.GetString("plugin-opts.mode")
+			if host := opt.GetString("plugin-opts.host"); host != "" {
+				pluginOpts += ";obfs-host=" + host
+			}
+			out["plugin_opts"] = pluginOpts
+		case "v2ray-plugin":
+			out["plugin"] = "v2ray-plugin"
+			pluginOpts := []string{"mode=" + opt.GetString("plugin-opts.mode")}
+			if host := opt.GetString("plugin-opts.host"); host != "" {
+				pluginOpts = append(pluginOpts, "host="+host)
+			}
+			if path := opt.GetString("plugin-opts.path"); path != "" {
+				pluginOpts = append(pluginOpts, "path="+path)
+			}
+			if opt.GetBool("plugin-opts.tls") {
+				pluginOpts = append(pluginOpts, "tls")
+			}
+			out["plugin_opts"] = strings.Join(pluginOpts, ";")
+		default:
+			return nil, unsupported(formatSingBox, typ, "plugin "+plugin)
+		}
+	case "ssr":
+		out["type"] = "shadowsocksr"
+		out["method"] = opt.GetString("cipher")
+		out["password"] = opt.GetString("password")
+		out["obfs"] = opt.GetString("obfs")
+		out["obfs_param"] = opt.GetString("obfs-param")
+		out["protocol"] = opt.GetString("protocol")
+		out["protocol_param"] = opt.GetString("protocol-param")
+	case "vmess":
+		out["type"] = "vmess"
+		out["uuid"] = opt.GetString("uuid")
+		out["alter_id"] = opt.GetInt("alterId")
+		out["security"] = opt.GetString("cipher")
+		if opt.GetBool("tls") {
+			out["tls"] = tlsOpt(true)
+		}
+		err := withTransport()
+		if err != nil {
+			return nil, err
+		}
+	case "vless":
+		out["type"] = "vless"
+		out["uuid"] = opt.GetString("uuid")
+		if flow := opt.GetString("flow"); flow != "" {
+			out["flow"] = flow
+		}
+		if opt.GetBool("tls") {
+			out["tls"] = tlsOpt(true)
+		}
+		err := withTransport()
+		if err != nil {
+			return nil, err
+		}
+	case "trojan":
+		out["type"] = "trojan"
+		out["password"] = opt.GetString("password")
+		out["tls"] = tlsOpt(true)
+		err := withTransport()
+		if err != nil {
+			return nil, err
+		}
+	case "http":
+		out["type"] = "http"
+		if username := opt.GetString("username"); username != "" {
+			out["username"] = username
+			out["password"] = opt.GetString("password")
+		}
+		if opt.GetBool("tls") {
+			out["tls"] = tlsOpt(true)
+		}
+	case "socks5":
+		if opt.GetBool("tls") {
+			return nil, unsupported(formatSingBox, typ, "tls")
+		}
+		out["type"] = "socks"
+		out["version"] = "5"
+		if username := opt.GetString("username"); username != "" {
+			out["username"] = username
+			out["password"] = opt.GetString("password")
+		}
+	case "hysteria":
+		out["type"] = "hysteria"
+		up, err := singBoxMbps(opt.GetString("up"))
+		if err != nil {
+			return nil, err
+		}
+		down, err := singBoxMbps(opt.GetString("down"))
+		if err != nil {
+			return nil, err
+		}
+		out["up_mbps"] = up
+		out["down_mbps"] = down
+		if auth := opt.GetString("auth_str"); auth != "" {
+			out["auth_str"] = auth
+		}
+		if obfs := opt.GetString("obfs"); obfs != "" {
+			out["obfs"] = obfs
+		}
+		if protocol := opt.GetString("protocol"); protocol != "" && protocol != "udp" {
+			return nil, unsupported(formatSingBox, typ, "protocol "+protocol)
+		}
+		out["tls"] = tlsOpt(true)
+	default:
+		return nil, unsupported(formatSingBox, typ, "")
+	}
+
+	return out, nil
+}
+
+func singBoxMbps(s string) (int, error) {
+	match := singBoxMbpsRe.FindStringSubmatch(s)
+	if len(match) != 2 {
+		return 0, fmt.Errorf("invalid bandwidth %s", s)
+	}
+
+	return strconv.Atoi(match[1])
+}
+
+func (p *ProxyAdapter) Sub4SingBox() (string, error) {
+	out, err := p.singBoxOutbound()
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := json.Marshal(out)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+// Sub4SingBox 导出 sing-box 的 outbounds，无法表达的节点会被跳过
+func (ss ProxyList) Sub4SingBox() ([]byte, error) {
+	lines, err := joinExport(formatSingBox, ss, func(proxy AdapterProxy) (string, error) {
+		return proxy.Sub4SingBox()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outbounds := make([]json.RawMessage, 0, len(lines))
+	for _, line := range lines {
+		outbounds = append(outbounds, json.RawMessage(line))
+	}
+
+	buf, err := json.MarshalIndent(map[string]any{
+		"outbounds": outbounds,
+	}, "", "  ")
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,146 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+)
+
+const formatSurge = "surge"
+
+func (p *ProxyAdapter) Sub4Surge() (string, error) {
+	opt := newExportOpt(p.cloneOpt())
+	typ := opt.GetString("type")
+
+	err := checkExportName(formatSurge, typ, p.Name())
+	if err != nil {
+		return "", err
+	}
+
+	server := opt.GetString("server")
+	port := opt.GetInt("port")
+
+	var params []string
+	add := func(key string, value any) {
+		params = append(params, fmt.Sprintf("%s=%v", key, value))
+	}
+
+	tlsParams := func() {
+		if sni := opt.serverName(); sni != "" {
+			add("sni", sni)
+		}
+		add("skip-cert-verify", opt.GetBool("skip-cert-verify"))
+	}
+
+	wsParams := func() error {
+		switch network := opt.network(); network {
+		case "tcp":
+		case "ws":
+			add("ws", true)
+			if path := opt.wsPath(); path != "" {
+				add("ws-path", path)
+			}
+			if host := opt.wsHost(); host != "" {
+				add("ws-headers", "Host:"+host)
+			}
+		default:
+			return unsupported(formatSurge, typ, "network "+network)
+		}
+		return nil
+	}
+
+	var head string
+	switch typ {
+	case "ss":
+		head = "ss"
+		add("encrypt-method", opt.GetString("cipher"))
+		add("password", opt.GetString("password"))
+
+		switch plugin := opt.GetString("plugin"); plugin {
+		case "":
+		case "obfs":
+			add("obfs", opt.GetString("plugin-opts.mode"))
+			if host := opt.GetString("plugin-opts.host"); host != "" {
+				add("obfs-host", host)
+			}
+		default:
+			return "", unsupported(formatSurge, typ, "plugin "+plugin)
+		}
+
+		add("udp-relay", opt.GetBool("udp"))
+	case "vmess":
+		head = "vmess"
+		add("username", opt.GetString("uuid"))
+
+		err = wsParams()
+		if err != nil {
+			return "", err
+		}
+
+		if opt.GetBool("tls") {
+			add("tls", true)
+			tlsParams()
+		}
+
+		add("vmess-aead", opt.GetInt("alterId") == 0)
+	case "trojan":
+		head = "trojan"
+		add("password", opt.GetString("password"))
+
+		err = wsParams()
+		if err != nil {
+			return "", err
+		}
+
+		tlsParams()
+	case "http":
+		head = "http"
+		if opt.GetBool("tls") {
+			head = "https"
+		}
+	case "socks5":
+		head = "socks5"
+		if opt.GetBool("tls") {
+			head = "socks5-tls"
+		}
+	case "snell":
+		head = "snell"
+		add("psk", opt.GetString("psk"))
+		if version := opt.GetInt("version"); version > 0 {
+			add("version", version)
+		}
+		if mode := opt.GetString("obfs-opts.mode"); mode != "" {
+			add("obfs", mode)
+			if host := opt.GetString("obfs-opts.host"); host != "" {
+				add("obfs-host", host)
+			}
+		}
+	default:
+		return "", unsupported(formatSurge, typ, "")
+	}
+
+	fields := []string{head, server, fmt.Sprintf("%d", port)}
+
+	switch typ {
+	case "http", "socks5":
+		if username := opt.GetString("username"); username != "" {
+			fields = append(fields, username, opt.GetString("password"))
+		}
+		if opt.GetBool("tls") {
+			tlsParams()
+		}
+	}
+
+	return fmt.Sprintf("%s = %s", p.Name(), strings.Join(append(fields, params...), ", ")), nil
+}
+
+// Sub4Surge 导出 Surge 的 [Proxy] 段，无法表达的节点会被跳过
+func (ss ProxyList) Sub4Surge() (string, error) {
+	lines, err := joinExport(formatSurge, ss, func(proxy AdapterProxy) (string, error) {
+		return proxy.Sub4Surge()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "[Proxy]\n" + strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,112 @@
+package adapter_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/darabuchi/nico/adapter"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func exportFixtures(t *testing.T) adapter.ProxyList {
+	var list adapter.ProxyList
+	for _, m := range []map[string]any{
+		{"name": "ss", "type": "ss", "server": "ss.example.com", "port": 8388, "cipher": "aes-256-gcm", "password": "pass", "udp": true},
+		{"name": "ss-obfs", "type": "ss", "server": "ss.example.com", "port": 8389, "cipher": "chacha20-ietf-poly1305", "password": "pass", "plugin": "obfs", "plugin-opts": map[string]any{"mode": "http", "host": "bing.com"}},
+		{"name": "ssr", "type": "ssr", "server": "ssr.example.com", "port": 443, "cipher": "aes-128-cfb", "password": "pass", "obfs": "http_simple", "obfs-param": "bing.com", "protocol": "auth_aes128_md5", "protocol-param": "1:pass"},
+		{"name": "vmess-ws", "type": "vmess", "server": "vmess.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "tls": true, "servername": "cdn.example.com", "network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "cdn.example.com"}}},
+		{"name": "vmess-grpc", "type": "vmess", "server": "vmess.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 2, "cipher": "auto", "tls": true, "network": "grpc", "grpc-opts": map[string]any{"grpc-service-name": "gun"}},
+		{"name": "vless", "type": "vless", "server": "vless.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "tls": true, "servername": "vless.example.com"},
+		{"name": "vless-reality", "type": "vless", "server": "reality.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "tls": true, "flow": "xtls-rprx-vision", "servername": "www.microsoft.com", "client-fingerprint": "chrome", "reality-opts": map[string]any{"public-key": "pbk", "short-id": "6ba85179e30d4fc2"}},
+		{"name": "trojan-ws", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass", "sni": "trojan.example.com", "skip-cert-verify": true, "network": "ws", "ws-opts": map[string]any{"path": "/ws"}},
+		{"name": "http", "type": "http", "server": "http.example.com", "port": 443, "username": "user", "password": "pass", "tls": true},
+		{"name": "socks5", "type": "socks5", "server": "127.0.0.1", "port": 1080},
+		{"name": "snell", "type": "snell", "server": "snell.example.com", "port": 443, "psk": "psk", "version": 2, "obfs-opts": map[string]any{"mode": "tls", "host": "bing.com"}},
+	} {
+		p, err := adapter.ParseClash(m)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		list = append(list, p)
+	}
+
+	p, err := adapter.ParseV2ray("trojan://28b31550-9aae-40f5-9511-b3d7e475e3fc@s2.example.com:34501?security=tls&sni=sni.example.com&type=tcp&headerType=none#trojan-link")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	list = append(list, p)
+
+	return list
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", "export", name+".golden")
+	if *update {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+
+		err = os.WriteFile(path, got, 0644)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch\n--- got\n%s\n--- want\n%s", name, got, want)
+	}
+}
+
+func TestExportGolden(t *testing.T) {
+	list := exportFixtures(t)
+
+	tests := []struct {
+		name   string
+		export func(p adapter.AdapterProxy) (string, error)
+	}{
+		{name: "singbox", export: adapter.AdapterProxy.Sub4SingBox},
+		{name: "surge", export: adapter.AdapterProxy.Sub4Surge},
+		{name: "quanx", export: adapter.AdapterProxy.Sub4QuanX},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			for _, p := range list {
+				line, err := tt.export(p)
+				if err != nil {
+					fmt.Fprintf(&b, "# %s: %v\n", p.Name(), err)
+					continue
+				}
+				fmt.Fprintf(&b, "%s\n", line)
+			}
+
+			checkGolden(t, tt.name, b.Bytes())
+		})
+	}
+}
+
+func TestProxyList_Sub4Surge(t *testing.T) {
+	list := exportFixtures(t)
+
+	s, err := list.Sub4Surge()
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	if bytes.Contains([]byte(s), []byte("vless")) {
+		t.Errorf("vless should be skipped:\n%s", s)
+	}
+}
@@ -14,9 +14,11 @@ var (
 
 func init() {
 	t := reflect.TypeOf(ExtraInfo{})
-	skipUniqueKeyMap = make(map[string]bool, t.NumField()+2)
-	skipUniqueKeyMap["name"] = true
-	skipUniqueKeyMap["unique_id"] = true
+	skipUniqueKeyMap = map[string]bool{
+		"name":             true,
+		"unique_id":        true,
+		uniqueIdVersionKey: true,
+	}
 	
 	extraInfoTypeMap = make(map[string]reflect.Kind, t.NumField())
 	
@@ -39,48 +41,91 @@ func init() {
 }
 
 type ExtraInfo struct {
+	// Country 等为展示和分组使用的地区，优先取出口 ip 的地区，没有时取服务器地址的
 	Country      string `json:"country,omitempty"`
 	Region       string `json:"region,omitempty"`
 	City         string `json:"city,omitempty"`
 	CountryCode  string `json:"country_code,omitempty"`
 	CountryEmoji string `json:"country_emoji,omitempty"`
+	ExitIp       string `json:"exit_ip,omitempty"`
+	
+	// 服务器地址的地区
+	ServerCountry     string `json:"server_country,omitempty"`
+	ServerRegion      string `json:"server_region,omitempty"`
+	ServerCity        string `json:"server_city,omitempty"`
+	ServerCountryCode string `json:"server_country_code,omitempty"`
+	
+	// 出口 ip 的地区，cdn 中转或落地机时与服务器地址不同
+	ExitCountry     string `json:"exit_country,omitempty"`
+	ExitRegion      string `json:"exit_region,omitempty"`
+	ExitCity        string `json:"exit_city,omitempty"`
+	ExitCountryCode string `json:"exit_country_code,omitempty"`
 	
 	Delay       time.Duration `json:"delay,omitempty"`
 	GoogleDelay time.Duration `json:"google,omitempty"`
 }
 
+// strFields opt 中的键和对应的字段
+func (p *ExtraInfo) strFields() map[string]*string {
+	return map[string]*string{
+		"country":             &p.Country,
+		"region":              &p.Region,
+		"city":                &p.City,
+		"country_code":        &p.CountryCode,
+		"country_emoji":       &p.CountryEmoji,
+		"exit_ip":             &p.ExitIp,
+		"server_country":      &p.ServerCountry,
+		"server_region":       &p.ServerRegion,
+		"server_city":         &p.ServerCity,
+		"server_country_code": &p.ServerCountryCode,
+		"exit_country":        &p.ExitCountry,
+		"exit_region":         &p.ExitRegion,
+		"exit_city":           &p.ExitCity,
+		"exit_country_code":   &p.ExitCountryCode,
+	}
+}
+
 func ParseClash4Extra(m map[string]any) ExtraInfo {
 	p := ExtraInfo{}
 	
-	if val, ok := m["country"]; ok {
-		p.Country, _ = val.(string)
-	}
-	
-	if val, ok := m["region"]; ok {
-		p.Region, _ = val.(string)
-	}
-	
-	if val, ok := m["city"]; ok {
-		p.City, _ = val.(string)
-	}
-	
-	if val, ok := m["country_code"]; ok {
-		p.CountryCode, _ = val.(string)
-	}
-	
-	if val, ok := m["country_emoji"]; ok {
-		p.CountryEmoji, _ = val.(string)
+	for key, field := range p.strFields() {
+		if val, ok := m[key]; ok {
+			*field, _ = val.(string)
+		}
 	}
 	
 	return p
 }
 
-// CountryEmoji Country-Region-City Name
+// fill 与 ParseClash4Extra 相反，把非空字段写回 opt
+func (p ExtraInfo) fill(m map[string]any) {
+	for key, field := range p.strFields() {
+		if *field != "" {
+			m[key] = *field
+		}
+	}
+}
+
+// GenNameTpl 生成 `CountryEmoji Country-Region-City` 形式的名称前缀，空字段会被跳过
 func (p ExtraInfo) GenNameTpl() string {
 	var b bytes.Buffer
 	if p.CountryEmoji != "" {
 		b.WriteString(p.CountryEmoji)
 	}
 	
+	var parts []string
+	for _, part := range []string{p.Country, p.Region, p.City} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	
+	if len(parts) > 0 {
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(strings.Join(parts, "-"))
+	}
+	
 	return b.String()
 }
@@ -7,3 +7,20 @@ import (
 func TestExtraInit(t *testing.T) {
 
 }
+
+func TestExtraInfo_GenNameTpl(t *testing.T) {
+	tests := []struct {
+		args ExtraInfo
+		want string
+	}{
+		{args: ExtraInfo{}, want: ""},
+		{args: ExtraInfo{CountryEmoji: "🇯🇵"}, want: "🇯🇵"},
+		{args: ExtraInfo{CountryEmoji: "🇯🇵", Country: "Japan", City: "Tokyo"}, want: "🇯🇵 Japan-Tokyo"},
+		{args: ExtraInfo{Country: "United States", Region: "California", City: "San Jose"}, want: "United States-California-San Jose"},
+	}
+	for _, tt := range tests {
+		if got := tt.args.GenNameTpl(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	
+	"github.com/darabuchi/log"
+	"github.com/oschwald/geoip2-golang"
+)
+
+const DefaultGeoEchoUrl = "https://api.ipify.org"
+
+var ErrGeoNotFound = errors.New("geo not found")
+
+type GeoInfo struct {
+	Country     string
+	Region      string
+	City        string
+	CountryCode string
+}
+
+type GeoResolver interface {
+	Lookup(ip net.IP) (*GeoInfo, error)
+}
+
+// MmdbResolver 使用本地 MaxMind 格式（GeoLite2-City/GeoLite2-Country 等）数据库
+type MmdbResolver struct {
+	reader *geoip2.Reader
+}
+
+func NewMmdbResolver(path string) (*MmdbResolver, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+	
+	return &MmdbResolver{
+		reader: reader,
+	}, nil
+}
+
+func (p *MmdbResolver) Lookup(ip net.IP) (*GeoInfo, error) {
+	// Country 库中没有城市信息，City 查询同样兼容
+	record, err := p.reader.City(ip)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+	
+	info := &GeoInfo{
+		Country:     record.Country.Names["en"],
+		City:        record.City.Names["en"],
+		CountryCode: record.Country.IsoCode,
+	}
+	
+	if len(record.Subdivisions) > 0 {
+		info.Region = record.Subdivisions[0].Names["en"]
+	}
+	
+	if info.CountryCode == "" {
+		return nil, ErrGeoNotFound
+	}
+	
+	return info, nil
+}
+
+func (p *MmdbResolver) Close() error {
+	return p.reader.Close()
+}
+
+// CountryEmoji 由两位国家代码生成旗帜 emoji，如 US -> 🇺🇸
+func CountryEmoji(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 2 {
+		return ""
+	}
+	
+	var b strings.Builder
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		b.WriteRune(0x1F1E6 + c - 'A')
+	}
+	
+	return b.String()
+}
+
+type GeoEnricher struct {
+	resolver GeoResolver
+	
+	// EchoUrl 通过节点访问后返回出口 ip 的地址，支持纯文本或包含 ip/query/origin 字段的 json
+	EchoUrl string
+	Timeout time.Duration
+	
+	// LookupHost 解析节点的服务器域名
+	LookupHost func(host string) ([]string, error)
+}
+
+func NewGeoEnricher(resolver GeoResolver) *GeoEnricher {
+	return &GeoEnricher{
+		resolver:   resolver,
+		EchoUrl:    DefaultGeoEchoUrl,
+		Timeout:    time.Second * 10,
+		LookupHost: net.LookupHost,
+	}
+}
+
+// Enrich 分别定位服务器地址和通过节点看到的出口 ip，展示的地区优先使用出口 ip，两者都失败时返回错误
+func (p *GeoEnricher) Enrich(proxy AdapterProxy) error {
+	info := proxy.GetExtraInfo()
+	
+	serverGeo, serverErr := p.lookupServer(proxy)
+	if serverErr != nil {
+		log.Warnf("lookup server of %s fail:%v", proxy.Name(), serverErr)
+	}
+	
+	var exitGeo *GeoInfo
+	info.ExitIp = ""
+	exitIp, err := p.exitIp(proxy)
+	if err != nil {
+		log.Warnf("get exit ip for %s fail:%v", proxy.Name(), err)
+	} else {
+		info.ExitIp = exitIp.String()
+		exitGeo, err = p.resolver.Lookup(exitIp)
+		if err != nil {
+			log.Warnf("lookup exit ip %s fail:%v", exitIp, err)
+		}
+	}
+	
+	geo := exitGeo
+	if geo == nil {
+		geo = serverGeo
+	}
+	if geo == nil {
+		log.Errorf("err:%v", serverErr)
+		return serverErr
+	}
+	
+	if serverGeo == nil {
+		serverGeo = &GeoInfo{}
+	}
+	info.ServerCountry = serverGeo.Country
+	info.ServerRegion = serverGeo.Region
+	info.ServerCity = serverGeo.City
+	info.ServerCountryCode = serverGeo.CountryCode
+	
+	if exitGeo == nil {
+		exitGeo = &GeoInfo{}
+	}
+	info.ExitCountry = exitGeo.Country
+	info.ExitRegion = exitGeo.Region
+	info.ExitCity = exitGeo.City
+	info.ExitCountryCode = exitGeo.CountryCode
+	
+	info.Country = geo.Country
+	info.Region = geo.Region
+	info.City = geo.City
+	info.CountryCode = geo.CountryCode
+	info.CountryEmoji = CountryEmoji(geo.CountryCode)
+	
+	proxy.SetExtraInfo(info)
+	
+	return nil
+}
+
+// EnrichList 逐个补全，返回失败的节点数
+func (p *GeoEnricher) EnrichList(ss ProxyList) int {
+	var failed int
+	ss.Each(func(proxy AdapterProxy) {
+		if p.Enrich(proxy) != nil {
+			failed++
+		}
+	})
+	return failed
+}
+
+func (p *GeoEnricher) lookupServer(proxy AdapterProxy) (*GeoInfo, error) {
+	server, _ := proxy.ToNico()["server"].(string)
+	server = strings.Trim(server, "[]")
+	if server == "" {
+		return nil, ErrGeoNotFound
+	}
+	
+	ip := net.ParseIP(server)
+	if ip == nil {
+		addrs, err := p.LookupHost(server)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+		
+		for _, addr := range addrs {
+			ip = net.ParseIP(addr)
+			if ip != nil {
+				break
+			}
+		}
+		
+		if ip == nil {
+			return nil, fmt.Errorf("no ip found for %s", server)
+		}
+	}
+	
+	return p.resolver.Lookup(ip)
+}
+
+func (p *GeoEnricher) exitIp(proxy AdapterProxy) (net.IP, error) {
+	if p.EchoUrl == "" {
+		return nil, errors.New("echo url is empty")
+	}
+	
+	buf, err := proxy.Get(p.EchoUrl, p.Timeout, map[string]string{
+		"Accept": "application/json, text/plain",
+	})
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+	
+	return parseEchoIp(buf)
+}
+
+func parseEchoIp(buf []byte) (net.IP, error) {
+	s := strings.TrimSpace(string(buf))
+	if ip := net.ParseIP(s); ip != nil {
+		return ip, nil
+	}
+	
+	var m map[string]any
+	err := json.Unmarshal(buf, &m)
+	if err != nil {
+		return nil, fmt.Errorf("unknown echo response %s", s)
+	}
+	
+	for _, key := range []string{"ip", "query", "origin"} {
+		val, _ := m[key].(string)
+		// httpbin 的 origin 可能是 `a, b`
+		val = strings.TrimSpace(strings.Split(val, ",")[0])
+		if ip := net.ParseIP(val); ip != nil {
+			return ip, nil
+		}
+	}
+	
+	return nil, fmt.Errorf("unknown echo response %s", s)
+}
@@ -0,0 +1,133 @@
+package adapter
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeGeoResolver map[string]*GeoInfo
+
+func (p fakeGeoResolver) Lookup(ip net.IP) (*GeoInfo, error) {
+	if info, ok := p[ip.String()]; ok {
+		return info, nil
+	}
+	return nil, ErrGeoNotFound
+}
+
+func TestCountryEmoji(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{args: "US", want: "🇺🇸"},
+		{args: "jp", want: "🇯🇵"},
+		{args: "", want: ""},
+		{args: "USA", want: ""},
+		{args: "1A", want: ""},
+	}
+	for _, tt := range tests {
+		if got := CountryEmoji(tt.args); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestGeoEnricher_Enrich(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ip":"203.0.113.7"}`))
+	}))
+	defer srv.Close()
+	
+	resolver := fakeGeoResolver{
+		"203.0.113.7":  {Country: "Japan", City: "Tokyo", CountryCode: "JP"},
+		"198.51.100.1": {Country: "United States", CountryCode: "US"},
+	}
+	
+	p, err := NewProxyAdapter(NewProxyDirect(), map[string]any{
+		"type":   "direct",
+		"server": "direct.example.com",
+	})
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+	
+	e := NewGeoEnricher(resolver)
+	e.LookupHost = func(host string) ([]string, error) {
+		return []string{"198.51.100.1"}, nil
+	}
+	
+	// 出口 ip
+	e.EchoUrl = srv.URL
+	err = e.Enrich(p)
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+	
+	info := p.GetExtraInfo()
+	if info.CountryCode != "JP" || info.City != "Tokyo" || info.CountryEmoji != "🇯🇵" || info.ExitIp != "203.0.113.7" {
+		t.Errorf("got %+v", info)
+	}
+	
+	// 服务器地址和出口 ip 的地区都保留
+	if info.ExitCountryCode != "JP" || info.ServerCountryCode != "US" {
+		t.Errorf("got %+v", info)
+	}
+	
+	if p.ToNico()["country_code"] != "JP" || p.ToNico()["server_country_code"] != "US" {
+		t.Errorf("extra info should be exported, got %v", p.ToNico())
+	}
+	
+	// 出口 ip 获取失败时退回服务器地址
+	e.EchoUrl = srv.URL + "/404"
+	err = e.Enrich(p)
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+	
+	if info := p.GetExtraInfo(); info.CountryCode != "US" || info.ExitCountryCode != "" || info.ExitIp != "" {
+		t.Errorf("got %+v", info)
+	}
+	
+	// 服务器地址解析失败时只有出口 ip 的地区
+	e.EchoUrl = srv.URL
+	e.LookupHost = func(host string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+	err = e.Enrich(p)
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+	
+	if info := p.GetExtraInfo(); info.CountryCode != "JP" || info.ServerCountryCode != "" {
+		t.Errorf("got %+v", info)
+	}
+	
+	e.EchoUrl = srv.URL + "/404"
+	if err = e.Enrich(p); err == nil {
+		t.Errorf("expect error")
+	}
+}
+
+func TestParseEchoIp(t *testing.T) {
+	for _, s := range []string{"1.2.3.4\n", `{"query":"1.2.3.4"}`, `{"origin":"1.2.3.4, 5.6.7.8"}`} {
+		ip, err := parseEchoIp([]byte(s))
+		if err != nil || ip.String() != "1.2.3.4" {
+			t.Errorf("%s: got %v, err:%v", s, ip, err)
+		}
+	}
+	
+	if _, err := parseEchoIp([]byte("<html>")); err == nil {
+		t.Errorf("expect error")
+	}
+}
@@ -0,0 +1,50 @@
+package adapter
+
+import (
+	"time"
+)
+
+const (
+	// CacheSpeedHistory 最近的测速记录，[]SpeedRecord
+	CacheSpeedHistory = "speed_history"
+	
+	MaxHistory = 32
+)
+
+// SpeedRecord 一次测速，Speed 单位 KB/s，小于 0 表示失败
+type SpeedRecord struct {
+	Time  time.Time `json:"time"`
+	Speed float64   `json:"speed"`
+}
+
+// AppendHistory 追加一条记录，超过 MaxHistory 时丢弃最旧的
+func AppendHistory[T any](c Cache, key string, record T) {
+	for {
+		old, err := c.Load(key)
+		if err != nil {
+			old = nil
+		}
+		
+		history, _ := old.([]T)
+		
+		n := make([]T, 0, len(history)+1)
+		n = append(n, history...)
+		n = append(n, record)
+		if len(n) > MaxHistory {
+			n = n[len(n)-MaxHistory:]
+		}
+		
+		if c.CompareAndSwap(key, old, n) {
+			return
+		}
+	}
+}
+
+// LoadHistory 读取记录，不存在时返回 nil
+func LoadHistory[T any](c Cache, key string) []T {
+	history, err := LoadAs[[]T](c, key)
+	if err != nil {
+		return nil
+	}
+	return history
+}
@@ -0,0 +1,27 @@
+package adapter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppendHistory(t *testing.T) {
+	c := NewAdapterCache()
+	for i := 0; i < MaxHistory+5; i++ {
+		AppendHistory(c, CacheSpeedHistory, SpeedRecord{Time: time.Unix(int64(i), 0), Speed: float64(i)})
+	}
+	
+	history := LoadHistory[SpeedRecord](c, CacheSpeedHistory)
+	if len(history) != MaxHistory {
+		t.Errorf("got %d records", len(history))
+		return
+	}
+	
+	if history[0].Speed != 5 || history[MaxHistory-1].Speed != MaxHistory+4 {
+		t.Errorf("got %+v ... %+v", history[0], history[MaxHistory-1])
+	}
+	
+	if LoadHistory[SpeedRecord](c, "missing") != nil {
+		t.Errorf("missing history should be nil")
+	}
+}
@@ -0,0 +1,180 @@
+package adapter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	
+	"github.com/darabuchi/utils"
+)
+
+// UniqueIdVersion 当前 UniqueId 的计算版本，节点 opt 中以 unique_id_version 记录
+//
+// v1: 对 opt 整体反射拼接后取 sha256，同一节点从 clash 与链接导入时结果不同，且 map 遍历无序导致结果不稳定
+// v2: 只取协议语义字段并归一化，见 canonicalIdentity
+const UniqueIdVersion = 2
+
+const uniqueIdVersionKey = "unique_id_version"
+
+// canonicalIdentity 生成节点的规范化描述，每行一个 key=value，按 key 排序
+//
+// 参与计算的字段：
+//   - type、server（小写）、port
+//   - 凭据：ss/ssr 的 cipher、password 及插件/混淆参数，vmess/vless 的 uuid、alterId、cipher、flow、reality，
+//     trojan 的 password，http/socks5 的 username、password，snell 的 psk、version、obfs，hysteria 的 auth、protocol、obfs
+//   - 传输：network（空视为 tcp），ws 的 path（空视为 /）与 Host，grpc 的 service name，h2 的 path 与 host
+//   - tls：是否启用及 sni（与 server 相同时忽略）
+//   - 跳板 dialer-proxy，经过不同跳板的同一服务器视为不同节点
+//
+// 名称、地区、skip-cert-verify、udp 等不影响连接目标的字段不参与计算
+func canonicalIdentity(opt map[string]any) string {
+	o := newExportOpt(opt)
+	typ := strings.ToLower(o.GetString("type"))
+	server := strings.ToLower(strings.Trim(o.GetString("server"), "[]"))
+	
+	fields := map[string]string{
+		"type":   typ,
+		"server": server,
+		"port":   fmt.Sprintf("%d", o.GetInt("port")),
+	}
+	
+	set := func(key string, value string) {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	
+	withTls := func(enabled bool) {
+		if !enabled {
+			return
+		}
+		fields["tls"] = "1"
+		if sni := strings.ToLower(o.serverName()); sni != server {
+			set("sni", sni)
+		}
+	}
+	
+	withTransport := func() {
+		network := o.network()
+		if network == "http" && o.GetString("h2-opts.path") != "" {
+			network = "h2"
+		}
+		fields["network"] = network
+		
+		switch network {
+		case "ws":
+			path := o.wsPath()
+			if path == "" {
+				path = "/"
+			}
+			fields["ws-path"] = path
+			set("ws-host", strings.ToLower(o.wsHost()))
+		case "grpc":
+			set("grpc-service-name", o.GetString("grpc-opts.grpc-service-name"))
+		case "h2":
+			set("h2-path", o.GetString("h2-opts.path"))
+			host := o.GetStringSlice("h2-opts.host")
+			sort.Strings(host)
+			set("h2-host", strings.ToLower(strings.Join(host, ",")))
+		}
+	}
+	
+	set("dialer-proxy", o.GetString(DialerProxyKey))
+	
+	switch typ {
+	case "ss":
+		set("cipher", strings.ToLower(o.GetString("cipher")))
+		set("password", o.GetString("password"))
+		set("plugin", o.GetString("plugin"))
+		set("plugin-mode", o.GetString("plugin-opts.mode"))
+		set("plugin-host", strings.ToLower(o.GetString("plugin-opts.host")))
+		set("plugin-path", o.GetString("plugin-opts.path"))
+		if o.GetBool("plugin-opts.tls") {
+			fields["plugin-tls"] = "1"
+		}
+	case "ssr":
+		set("cipher", strings.ToLower(o.GetString("cipher")))
+		set("password", o.GetString("password"))
+		set("protocol", o.GetString("protocol"))
+		set("protocol-param", o.GetString("protocol-param"))
+		set("obfs", o.GetString("obfs"))
+		set("obfs-param", o.GetString("obfs-param"))
+	case "vmess":
+		set("uuid", strings.ToLower(o.GetString("uuid")))
+		fields["alter-id"] = fmt.Sprintf("%d", o.GetInt("alterId"))
+		cipher := strings.ToLower(o.GetString("cipher"))
+		if cipher == "" {
+			cipher = "auto"
+		}
+		fields["cipher"] = cipher
+		withTls(o.GetBool("tls"))
+		withTransport()
+	case "vless":
+		set("uuid", strings.ToLower(o.GetString("uuid")))
+		set("flow", o.GetString("flow"))
+		set("reality-public-key", o.GetString("reality-opts.public-key"))
+		set("reality-short-id", o.GetString("reality-opts.short-id"))
+		withTls(o.GetBool("tls"))
+		withTransport()
+	case "trojan":
+		set("password", o.GetString("password"))
+		withTls(true)
+		withTransport()
+	case "http", "socks5":
+		set("username", o.GetString("username"))
+		set("password", o.GetString("password"))
+		withTls(o.GetBool("tls"))
+	case "snell":
+		set("psk", o.GetString("psk"))
+		set("version", o.GetString("version"))
+		set("obfs-mode", o.GetString("obfs-opts.mode"))
+		set("obfs-host", strings.ToLower(o.GetString("obfs-opts.host")))
+	case "hysteria":
+		set("auth", o.GetString("auth_str"))
+		set("protocol", o.GetString("protocol"))
+		set("obfs", o.GetString("obfs"))
+		withTls(true)
+	}
+	
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(fields[key])
+		b.WriteString("\n")
+	}
+	
+	return b.String()
+}
+
+// CanonicalUniqueId 计算节点的 v2 UniqueId，同一节点无论来自 clash、链接还是 surge 等格式结果一致
+func CanonicalUniqueId(opt map[string]any) string {
+	return utils.Sha256(fmt.Sprintf("v%d\n%s", UniqueIdVersion, canonicalIdentity(opt)))
+}
+
+// MigrateUniqueId 根据旧版导出的节点（Sub4Nico/Sub4Clash 中带 unique_id）生成旧 id 到新 id 的映射
+//
+// v1 的 id 无法重新计算，只能从导出内容中读取，没有 unique_id 或已是当前版本的节点会被跳过
+func MigrateUniqueId(opts ...map[string]any) map[string]string {
+	m := make(map[string]string, len(opts))
+	for _, opt := range opts {
+		oldId, _ := opt["unique_id"].(string)
+		if oldId == "" {
+			continue
+		}
+		
+		if utils.ToString(opt[uniqueIdVersionKey]) == fmt.Sprintf("%d", UniqueIdVersion) {
+			continue
+		}
+		
+		m[oldId] = CanonicalUniqueId(opt)
+	}
+	
+	return m
+}
@@ -0,0 +1,121 @@
+package adapter_test
+
+import (
+	"testing"
+	
+	"github.com/darabuchi/nico/adapter"
+)
+
+func TestCanonicalUniqueId(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []func() (*adapter.ProxyAdapter, error)
+	}{
+		{
+			name: "vmess",
+			nodes: []func() (*adapter.ProxyAdapter, error){
+				func() (*adapter.ProxyAdapter, error) {
+					return adapter.ParseClash(map[string]any{"name": "a", "type": "vmess", "server": "Vmess.Example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "tls": true, "servername": "cdn.example.com", "network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "cdn.example.com"}}, "country": "US"})
+				},
+				func() (*adapter.ProxyAdapter, error) {
+					return adapter.ParseProxyLine(`b = vmess, vmess.example.com, 443, username=047184b7-6da2-3d3f-ac27-6a1a8701daf8, ws=true, ws-path=/ray, ws-headers=Host:cdn.example.com, tls=true, sni=cdn.example.com`)
+				},
+				func() (*adapter.ProxyAdapter, error) {
+					// {"v":"2","ps":"c","add":"vmess.example.com","port":"443","id":"047184b7-6da2-3d3f-ac27-6a1a8701daf8","aid":"0","scy":"auto","net":"ws","host":"cdn.example.com","path":"/ray","tls":"tls","sni":"cdn.example.com"}
+					return adapter.ParseV2ray("vmess://eyJ2IjoiMiIsInBzIjoiYyIsImFkZCI6InZtZXNzLmV4YW1wbGUuY29tIiwicG9ydCI6IjQ0MyIsImlkIjoiMDQ3MTg0YjctNmRhMi0zZDNmLWFjMjctNmExYTg3MDFkYWY4IiwiYWlkIjoiMCIsInNjeSI6ImF1dG8iLCJuZXQiOiJ3cyIsImhvc3QiOiJjZG4uZXhhbXBsZS5jb20iLCJwYXRoIjoiL3JheSIsInRscyI6InRscyIsInNuaSI6ImNkbi5leGFtcGxlLmNvbSJ9")
+				},
+			},
+		},
+		{
+			name: "trojan",
+			nodes: []func() (*adapter.ProxyAdapter, error){
+				func() (*adapter.ProxyAdapter, error) {
+					return adapter.ParseClash(map[string]any{"name": "a", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass", "udp": true})
+				},
+				func() (*adapter.ProxyAdapter, error) {
+					return adapter.ParseV2ray("trojan://pass@trojan.example.com:443?sni=trojan.example.com#b")
+				},
+				func() (*adapter.ProxyAdapter, error) {
+					return adapter.ParseProxyLine("trojan=trojan.example.com:443, password=pass, over-tls=true, tag=c")
+				},
+			},
+		},
+		{
+			name: "ss",
+			nodes: []func() (*adapter.ProxyAdapter, error){
+				func() (*adapter.ProxyAdapter, error) {
+					return adapter.ParseClash(map[string]any{"name": "a", "type": "ss", "server": "ss.example.com", "port": 8388, "cipher": "aes-256-gcm", "password": "pass"})
+				},
+				func() (*adapter.ProxyAdapter, error) {
+					// aes-256-gcm:pass
+					return adapter.ParseV2ray("ss://YWVzLTI1Ni1nY206cGFzcw@ss.example.com:8388#b")
+				},
+				func() (*adapter.ProxyAdapter, error) {
+					return adapter.ParseProxyLine("c = ss, ss.example.com, 8388, encrypt-method=aes-256-gcm, password=pass, udp-relay=true")
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want string
+			for i, logic := range tt.nodes {
+				p, err := logic()
+				if err != nil {
+					t.Errorf("err:%v", err)
+					return
+				}
+				
+				if i == 0 {
+					want = p.UniqueId()
+					continue
+				}
+				
+				if p.UniqueId() != want {
+					t.Errorf("node %d: got %s, want %s", i, p.UniqueId(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestCanonicalUniqueIdDiffer(t *testing.T) {
+	base := map[string]any{"type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass"}
+	want := adapter.CanonicalUniqueId(base)
+	
+	for key, val := range map[string]any{
+		"server":   "other.example.com",
+		"port":     8443,
+		"password": "other",
+		"sni":      "sni.example.com",
+		"network":  "grpc",
+	} {
+		opt := map[string]any{}
+		for k, v := range base {
+			opt[k] = v
+		}
+		opt[key] = val
+		
+		if adapter.CanonicalUniqueId(opt) == want {
+			t.Errorf("%s should change unique id", key)
+		}
+	}
+}
+
+func TestMigrateUniqueId(t *testing.T) {
+	p, err := adapter.ParseClash(map[string]any{"name": "a", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass"})
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+	
+	m := adapter.MigrateUniqueId(
+		map[string]any{"name": "a", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass", "unique_id": "old"},
+		map[string]any{"name": "b", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass"},
+		p.ToNico(),
+	)
+	
+	if len(m) != 1 || m["old"] != p.UniqueId() {
+		t.Errorf("got %v", m)
+	}
+}
@@ -0,0 +1,217 @@
+package adapter
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing-shadowsocks/shadowaead"
+	"github.com/sagernet/sing-shadowsocks/shadowaead_2022"
+	"github.com/sagernet/sing-shadowsocks/shadowstream"
+)
+
+var (
+	ErrInvalidLink = errors.New("invalid link")
+)
+
+// ParseError 描述节点解析失败的协议、字段和原因
+type ParseError struct {
+	Scheme string
+	Field  string
+	Reason string
+
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Scheme)
+	if e.Field != "" {
+		b.WriteString(" ")
+		b.WriteString(e.Field)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Reason)
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	if e.Err != nil {
+		return e.Err
+	}
+	return ErrInvalidLink
+}
+
+func newParseError(scheme, field, reason string, err error) *ParseError {
+	return &ParseError{
+		Scheme: scheme,
+		Field:  field,
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+type parseConfig struct {
+	strict bool
+}
+
+type ParseOption func(c *parseConfig)
+
+// WithStrict 缺少必填字段的节点直接报错，而不是构造一个不可用的节点
+func WithStrict() ParseOption {
+	return func(c *parseConfig) {
+		c.strict = true
+	}
+}
+
+func newParseConfig(opts []ParseOption) *parseConfig {
+	c := &parseConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// require 严格模式下检查必填字段
+func (c *parseConfig) require(scheme string, fields ...string) error {
+	if !c.strict {
+		return nil
+	}
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			return newParseError(scheme, fields[i], "missing required field", nil)
+		}
+	}
+
+	return nil
+}
+
+func parsePort(scheme, port string) (int, error) {
+	if port == "" {
+		return 0, newParseError(scheme, "port", "missing port", nil)
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, newParseError(scheme, "port", fmt.Sprintf("invalid port %q", port), nil)
+	}
+
+	if p <= 0 || p > 65535 {
+		return 0, newParseError(scheme, "port", fmt.Sprintf("port %d out of range", p), nil)
+	}
+
+	return p, nil
+}
+
+func parseLinkUrl(scheme, s string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		// url.Parse 对非法端口的报错比较含糊，单独标出来
+		if strings.Contains(err.Error(), "invalid port") {
+			return nil, newParseError(scheme, "port", "invalid port", err)
+		}
+		return nil, newParseError(scheme, "", "invalid url", err)
+	}
+	return u, nil
+}
+
+var uuidRe = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func checkUUID(scheme, uuid string) error {
+	if !uuidRe.MatchString(uuid) {
+		return newParseError(scheme, "uuid", fmt.Sprintf("invalid uuid %q", uuid), nil)
+	}
+	return nil
+}
+
+var (
+	ssCipherMap = map[string]bool{
+		"none":  true,
+		"plain": true,
+		"dummy": true,
+	}
+
+	// 与 clash 的 ssr 实现保持一致，只支持流加密
+	ssrCipherMap = map[string]bool{
+		"none":          true,
+		"dummy":         true,
+		"rc4-md5":       true,
+		"aes-128-ctr":   true,
+		"aes-192-ctr":   true,
+		"aes-256-ctr":   true,
+		"aes-128-cfb":   true,
+		"aes-192-cfb":   true,
+		"aes-256-cfb":   true,
+		"chacha20-ietf": true,
+		"xchacha20":     true,
+	}
+
+	ssrObfsMap = map[string]bool{
+		"plain":                  true,
+		"http_simple":            true,
+		"http_post":              true,
+		"random_head":            true,
+		"tls1.2_ticket_auth":     true,
+		"tls1.2_ticket_fastauth": true,
+	}
+
+	ssrProtocolMap = map[string]bool{
+		"origin":           true,
+		"auth_sha1_v4":     true,
+		"auth_aes128_md5":  true,
+		"auth_aes128_sha1": true,
+		"auth_chain_a":     true,
+		"auth_chain_b":     true,
+	}
+
+	vmessCipherMap = map[string]bool{
+		"auto":              true,
+		"none":              true,
+		"zero":              true,
+		"aes-128-cfb":       true,
+		"aes-128-gcm":       true,
+		"chacha20-poly1305": true,
+	}
+)
+
+func init() {
+	for _, list := range [][]string{shadowaead.List, shadowstream.List, shadowaead_2022.List} {
+		for _, cipher := range list {
+			ssCipherMap[cipher] = true
+		}
+	}
+}
+
+func checkSupported(scheme, field, value string, supported map[string]bool) error {
+	if !supported[value] {
+		return newParseError(scheme, field, fmt.Sprintf("unsupported %s %q", field, value), nil)
+	}
+	return nil
+}
+
+// decodeBase64 与 Base64Decode 不同，无法解码时返回错误
+func decodeBase64(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	for _, encoding := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	} {
+		buf, err := encoding.DecodeString(s)
+		if err == nil {
+			return string(buf), nil
+		}
+	}
+
+	return "", errors.New("illegal base64 data")
+}
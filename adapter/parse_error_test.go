@@ -0,0 +1,93 @@
+package adapter_test
+
+import (
+	"errors"
+	"testing"
+	
+	"github.com/darabuchi/nico/adapter"
+)
+
+func TestParseV2rayError(t *testing.T) {
+	tests := []struct {
+		args   string
+		opts   []adapter.ParseOption
+		scheme string
+		field  string
+	}{
+		{
+			args:   "trojan://pass@trojan.example.com:abc#x",
+			scheme: "trojan",
+			field:  "port",
+		},
+		{
+			args:   "trojan://pass@trojan.example.com:70000#x",
+			scheme: "trojan",
+			field:  "port",
+		},
+		{
+			args:   "vless://not-a-uuid@vless.example.com:443?security=tls#x",
+			scheme: "vless",
+			field:  "uuid",
+		},
+		{
+			// rc4-md5-6:pass
+			args:   "ss://cmM0LW1kNS02OnBhc3M@ss.example.com:8388#x",
+			scheme: "ss",
+			field:  "cipher",
+		},
+		{
+			args:   "ss://!!!not-base64!!!#x",
+			scheme: "ss",
+		},
+		{
+			// aes-256-gcm:
+			args:   "ss://YWVzLTI1Ni1nY206@ss.example.com:8388#x",
+			opts:   []adapter.ParseOption{adapter.WithStrict()},
+			scheme: "ss",
+			field:  "password",
+		},
+		{
+			// ssr.example.com:443:origin
+			args:   "ssr://c3NyLmV4YW1wbGUuY29tOjQ0MzpvcmlnaW4",
+			scheme: "ssr",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			_, err := adapter.ParseV2ray(tt.args, tt.opts...)
+			if err == nil {
+				t.Errorf("expect error")
+				return
+			}
+			
+			var pe *adapter.ParseError
+			if !errors.As(err, &pe) {
+				t.Errorf("err:%v is not ParseError", err)
+				return
+			}
+			
+			if pe.Scheme != tt.scheme || pe.Field != tt.field {
+				t.Errorf("got %s/%s, want %s/%s", pe.Scheme, pe.Field, tt.scheme, tt.field)
+			}
+		})
+	}
+}
+
+func TestParseV2rayStrict(t *testing.T) {
+	// aes-256-gcm:pass
+	s := "ss://YWVzLTI1Ni1nY206cGFzcw@ss.example.com:8388/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dbing.com#ss"
+	
+	p, err := adapter.ParseV2ray(s, adapter.WithStrict())
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+	
+	if p.Name() != "ss" {
+		t.Errorf("got name %s", p.Name())
+	}
+	
+	if p.ToNico()["plugin"] != "obfs" {
+		t.Errorf("got plugin %v", p.ToNico()["plugin"])
+	}
+}
@@ -0,0 +1,146 @@
+package adapter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/darabuchi/log"
+)
+
+var quanXTypeMap = map[string]string{
+	"shadowsocks": "ss",
+	"vmess":       "vmess",
+	"vless":       "vless",
+	"trojan":      "trojan",
+	"http":        "http",
+	"socks5":      "socks5",
+}
+
+// ParseQuanX 解析 Quantumult X 的 server_local 配置，如 `vmess=host:port, method=none, password=uuid, obfs=wss, tag=name`
+func ParseQuanX(s string) (*ProxyAdapter, error) {
+	line, err := parseQuanX(s)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return line.build()
+}
+
+func parseQuanX(s string) (*confLine, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, ErrEmptyDate
+	}
+
+	items := splitConf(s)
+
+	typ, addr, ok := splitKv(items[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid quantumult x proxy %s", s)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quantumult x address %s", addr)
+	}
+
+	line := &confLine{
+		format: "quantumult x",
+		server: host,
+		kv:     map[string]string{},
+	}
+
+	line.port, err = parseConfPort(line.format, port)
+	if err != nil {
+		return nil, err
+	}
+
+	line.typ, ok = quanXTypeMap[typ]
+	if !ok {
+		log.Warnf("unsupport quantumult x type:%s", typ)
+		return nil, ErrUnsupportedType
+	}
+
+	for _, item := range items[1:] {
+		key, val, ok := splitKv(item)
+		if !ok {
+			continue
+		}
+		line.kv[key] = val
+	}
+
+	line.name = line.get("tag")
+
+	// 转换成 surge 风格的参数，交给 confLine.build 统一处理
+	if line.typ == "ss" && line.get("ssr-protocol") != "" {
+		line.typ = "ssr"
+		line.kv["protocol"] = line.get("ssr-protocol")
+		line.kv["protocol-param"] = line.get("ssr-protocol-param")
+		line.kv["obfs-param"] = line.get("obfs-host")
+	}
+
+	switch line.get("tls-verification") {
+	case "false":
+		line.kv["skip-cert-verify"] = "true"
+	}
+
+	switch line.typ {
+	case "vmess", "vless", "trojan", "http", "socks5":
+		obfs := line.get("obfs")
+		delete(line.kv, "obfs")
+
+		switch obfs {
+		case "ws", "wss":
+			line.kv["transport"] = "ws"
+			line.kv["path"] = line.get("obfs-uri")
+			line.kv["host"] = line.get("obfs-host")
+			if obfs == "wss" {
+				line.kv["tls"] = "true"
+			}
+		case "over-tls":
+			line.kv["tls"] = "true"
+		case "":
+		default:
+			return nil, fmt.Errorf("unsupported quantumult x %s obfs %s", line.typ, obfs)
+		}
+
+		if line.typ == "vmess" || line.typ == "vless" {
+			line.kv["uuid"] = line.get("password")
+			delete(line.kv, "password")
+
+			switch method := line.get("method"); method {
+			case "chacha20-ietf-poly1305":
+				line.kv["method"] = "chacha20-poly1305"
+			}
+		}
+
+		// trojan 默认就是 tls
+		if line.typ == "trojan" {
+			delete(line.kv, "tls")
+		}
+	}
+
+	return line, nil
+}
+
+// ParseProxyLine 自动识别 Surge/Loon/Quantumult X 的单行节点配置
+func ParseProxyLine(s string) (*ProxyAdapter, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, ErrEmptyDate
+	}
+
+	idx := strings.Index(s, "=")
+	if idx > 0 {
+		if _, ok := quanXTypeMap[strings.ToLower(strings.TrimSpace(s[:idx]))]; ok {
+			addr := strings.TrimSpace(strings.SplitN(s[idx+1:], ",", 2)[0])
+			if _, _, err := net.SplitHostPort(addr); err == nil {
+				return ParseQuanX(s)
+			}
+		}
+	}
+
+	return ParseSurge(s)
+}
@@ -0,0 +1,477 @@
+package adapter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outbound"
+	"github.com/darabuchi/log"
+)
+
+// confLine Surge/Loon/Quantumult X 一行节点配置拆分后的结果
+type confLine struct {
+	format string
+
+	name string
+	typ  string
+
+	server string
+	port   int
+
+	// 没有 key 的参数，按出现顺序保存
+	args []string
+	kv   map[string]string
+}
+
+func (p *confLine) get(keys ...string) string {
+	for _, key := range keys {
+		if val, ok := p.kv[key]; ok && val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+func (p *confLine) arg(idx int) string {
+	if idx < len(p.args) {
+		return p.args[idx]
+	}
+	return ""
+}
+
+func (p *confLine) bool(keys ...string) bool {
+	switch strings.ToLower(p.get(keys...)) {
+	case "true", "1", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// splitConf 按逗号拆分，忽略引号内的逗号并去掉引号
+func splitConf(s string) []string {
+	var items []string
+	var b strings.Builder
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			items = append(items, strings.TrimSpace(b.String()))
+			b.Reset()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	items = append(items, strings.TrimSpace(b.String()))
+
+	return items
+}
+
+func splitKv(item string) (string, string, bool) {
+	idx := strings.Index(item, "=")
+	if idx < 0 {
+		return "", item, false
+	}
+	return strings.ToLower(strings.TrimSpace(item[:idx])), strings.TrimSpace(item[idx+1:]), true
+}
+
+func parseConfPort(format, port string) (int, error) {
+	p, err := strconv.Atoi(strings.TrimSpace(port))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s port %s", format, port)
+	}
+	return p, nil
+}
+
+// parseSurgeLike 解析 `name = type, server, port, ...` 格式，Surge 与 Loon 共用
+func parseSurgeLike(format, s string) (*confLine, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, ErrEmptyDate
+	}
+
+	idx := strings.Index(s, "=")
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid %s proxy %s", format, s)
+	}
+
+	items := splitConf(s[idx+1:])
+	if len(items) < 3 {
+		return nil, fmt.Errorf("invalid %s proxy %s", format, s)
+	}
+
+	port, err := parseConfPort(format, items[2])
+	if err != nil {
+		return nil, err
+	}
+
+	line := &confLine{
+		format: format,
+		name:   strings.TrimSpace(s[:idx]),
+		typ:    strings.ToLower(items[0]),
+		server: items[1],
+		port:   port,
+		kv:     map[string]string{},
+	}
+
+	for _, item := range items[3:] {
+		if item == "" {
+			continue
+		}
+
+		key, val, ok := splitKv(item)
+		if !ok {
+			line.args = append(line.args, val)
+			continue
+		}
+		line.kv[key] = val
+	}
+
+	return line, nil
+}
+
+func ParseSurge(s string) (*ProxyAdapter, error) {
+	line, err := parseSurgeLike("surge", s)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return line.build()
+}
+
+func ParseLoon(s string) (*ProxyAdapter, error) {
+	line, err := parseSurgeLike("loon", s)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return line.build()
+}
+
+func (p *confLine) wsHeaders() map[string]string {
+	headers := map[string]string{}
+
+	// surge: ws-headers=Host:example.com|User-Agent:xxx
+	for _, header := range strings.Split(p.get("ws-headers"), "|") {
+		idx := strings.Index(header, ":")
+		if idx < 0 {
+			continue
+		}
+		headers[strings.TrimSpace(header[:idx])] = strings.TrimSpace(header[idx+1:])
+	}
+
+	// loon: host=example.com
+	if host := p.get("host"); host != "" {
+		headers["Host"] = host
+	}
+
+	return headers
+}
+
+func (p *confLine) network() string {
+	if p.bool("ws") {
+		return "ws"
+	}
+
+	switch transport := strings.ToLower(p.get("transport")); transport {
+	case "", "tcp":
+		return "tcp"
+	default:
+		return transport
+	}
+}
+
+func (p *confLine) sni() string {
+	return p.get("sni", "tls-name", "tls-host")
+}
+
+func (p *confLine) obfsOpts() map[string]any {
+	mode := p.get("obfs", "obfs-name")
+	if mode == "" || mode == "none" {
+		return nil
+	}
+
+	m := map[string]any{
+		"mode": mode,
+	}
+	if host := p.get("obfs-host"); host != "" {
+		m["host"] = host
+	}
+
+	return m
+}
+
+func (p *confLine) build() (*ProxyAdapter, error) {
+	switch p.typ {
+	case "ss", "shadowsocks", "custom":
+		opt := outbound.ShadowSocksOption{
+			Name:     p.name,
+			Server:   p.server,
+			Port:     p.port,
+			Cipher:   p.get("encrypt-method", "method", "cipher"),
+			Password: p.get("password"),
+			UDP:      p.bool("udp-relay", "udp"),
+		}
+		if opt.Cipher == "" {
+			opt.Cipher = p.arg(0)
+		}
+		if opt.Password == "" {
+			opt.Password = p.arg(1)
+		}
+
+		switch mode := p.get("obfs", "obfs-name"); mode {
+		case "ws", "wss":
+			opt.Plugin = "v2ray-plugin"
+			opt.PluginOpts = map[string]any{
+				"mode": "websocket",
+				"host": p.get("obfs-host"),
+				"path": p.get("obfs-uri", "path"),
+				"tls":  mode == "wss",
+			}
+		default:
+			if obfs := p.obfsOpts(); obfs != nil {
+				opt.Plugin = "obfs"
+				opt.PluginOpts = obfs
+			}
+		}
+
+		log.Debugf("%s ss opt:%+v", p.format, opt)
+
+		at, err := outbound.NewShadowSocks(opt)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	case "ssr", "shadowsocksr":
+		opt := outbound.ShadowSocksROption{
+			Name:          p.name,
+			Server:        p.server,
+			Port:          p.port,
+			Cipher:        p.get("encrypt-method", "method", "cipher"),
+			Password:      p.get("password"),
+			Protocol:      p.get("protocol"),
+			ProtocolParam: p.get("protocol-param"),
+			Obfs:          p.get("obfs"),
+			ObfsParam:     p.get("obfs-param", "obfs-host"),
+			UDP:           p.bool("udp-relay", "udp"),
+		}
+		if opt.Cipher == "" {
+			opt.Cipher = p.arg(0)
+		}
+		if opt.Password == "" {
+			opt.Password = p.arg(1)
+		}
+
+		log.Debugf("%s ssr opt:%+v", p.format, opt)
+
+		at, err := outbound.NewShadowSocksR(opt)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	case "vmess":
+		opt := outbound.VmessOption{
+			Name:           p.name,
+			Server:         p.server,
+			Port:           p.port,
+			UUID:           p.get("username", "uuid"),
+			Cipher:         p.get("encrypt-method", "method", "cipher"),
+			UDP:            true,
+			Network:        p.network(),
+			TLS:            p.bool("tls", "over-tls"),
+			SkipCertVerify: p.bool("skip-cert-verify"),
+			ServerName:     p.sni(),
+		}
+
+		// loon: vmess, server, port, cipher, "uuid"
+		if opt.UUID == "" {
+			opt.Cipher, opt.UUID = p.arg(0), p.arg(1)
+		}
+		if opt.Cipher == "" {
+			opt.Cipher = "auto"
+		}
+
+		if aid := p.get("alterid", "alter-id"); aid != "" {
+			opt.AlterID, _ = strconv.Atoi(aid)
+		}
+
+		switch opt.Network {
+		case "ws":
+			opt.WSOpts = outbound.WSOptions{
+				Path:    p.get("ws-path", "path"),
+				Headers: p.wsHeaders(),
+			}
+		case "tcp":
+			opt.Network = ""
+		default:
+			return nil, fmt.Errorf("unsupported %s vmess transport %s", p.format, opt.Network)
+		}
+
+		log.Debugf("%s vmess opt:%+v", p.format, opt)
+
+		at, err := outbound.NewVmess(opt)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	case "vless":
+		opt := outbound.VlessOption{
+			Name:           p.name,
+			Server:         p.server,
+			Port:           p.port,
+			UUID:           p.get("username", "uuid", "password"),
+			UDP:            true,
+			Network:        p.network(),
+			TLS:            p.bool("tls", "over-tls"),
+			SkipCertVerify: p.bool("skip-cert-verify"),
+			ServerName:     p.sni(),
+			Flow:           p.get("flow"),
+		}
+		if opt.UUID == "" {
+			opt.UUID = p.arg(0)
+		}
+
+		switch opt.Network {
+		case "ws":
+			opt.WSOpts = outbound.WSOptions{
+				Path:    p.get("ws-path", "path"),
+				Headers: p.wsHeaders(),
+			}
+		case "tcp":
+		default:
+			return nil, fmt.Errorf("unsupported %s vless transport %s", p.format, opt.Network)
+		}
+
+		log.Debugf("%s vless opt:%+v", p.format, opt)
+
+		at, err := outbound.NewVless(opt)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	case "trojan":
+		opt := outbound.TrojanOption{
+			Name:           p.name,
+			Server:         p.server,
+			Port:           p.port,
+			Password:       p.get("password"),
+			SNI:            p.sni(),
+			SkipCertVerify: p.bool("skip-cert-verify"),
+			UDP:            p.bool("udp-relay", "udp"),
+			Network:        p.network(),
+		}
+		if opt.Password == "" {
+			opt.Password = p.arg(0)
+		}
+
+		switch opt.Network {
+		case "ws":
+			opt.WSOpts = outbound.WSOptions{
+				Path:    p.get("ws-path", "path"),
+				Headers: p.wsHeaders(),
+			}
+		case "tcp":
+			opt.Network = ""
+		default:
+			return nil, fmt.Errorf("unsupported %s trojan transport %s", p.format, opt.Network)
+		}
+
+		log.Debugf("%s trojan opt:%+v", p.format, opt)
+
+		at, err := outbound.NewTrojan(opt)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	case "http", "https":
+		opt := outbound.HttpOption{
+			Name:           p.name,
+			Server:         p.server,
+			Port:           p.port,
+			UserName:       p.get("username"),
+			Password:       p.get("password"),
+			TLS:            p.typ == "https" || p.bool("tls", "over-tls"),
+			SNI:            p.sni(),
+			SkipCertVerify: p.bool("skip-cert-verify"),
+		}
+		if opt.UserName == "" {
+			opt.UserName, opt.Password = p.arg(0), p.arg(1)
+		}
+
+		log.Debugf("%s http opt:%+v", p.format, opt)
+
+		at := outbound.NewHttp(opt)
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	case "socks5", "socks5-tls":
+		opt := outbound.Socks5Option{
+			Name:           p.name,
+			Server:         p.server,
+			Port:           p.port,
+			UserName:       p.get("username"),
+			Password:       p.get("password"),
+			TLS:            p.typ == "socks5-tls" || p.bool("tls", "over-tls"),
+			UDP:            p.bool("udp-relay", "udp"),
+			SkipCertVerify: p.bool("skip-cert-verify"),
+		}
+		if opt.UserName == "" {
+			opt.UserName, opt.Password = p.arg(0), p.arg(1)
+		}
+
+		log.Debugf("%s socks5 opt:%+v", p.format, opt)
+
+		at := outbound.NewSocks5(opt)
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	case "snell":
+		opt := outbound.SnellOption{
+			Name:     p.name,
+			Server:   p.server,
+			Port:     p.port,
+			Psk:      p.get("psk"),
+			ObfsOpts: p.obfsOpts(),
+		}
+		if version := p.get("version"); version != "" {
+			opt.Version, _ = strconv.Atoi(version)
+		}
+
+		log.Debugf("%s snell opt:%+v", p.format, opt)
+
+		at, err := outbound.NewSnell(opt)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+
+		return NewProxyAdapter(adapter.NewProxy(at), opt)
+
+	default:
+		log.Warnf("unsupport %s type:%s", p.format, p.typ)
+		return nil, ErrUnsupportedType
+	}
+}
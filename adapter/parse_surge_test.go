@@ -0,0 +1,83 @@
+package adapter_test
+
+import (
+	"testing"
+
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/utils"
+)
+
+func TestParseProxyLine(t *testing.T) {
+	tests := []struct {
+		args string
+		want map[string]any
+	}{
+		{
+			args: `surge-vmess = vmess, vmess.example.com, 443, username=047184b7-6da2-3d3f-ac27-6a1a8701daf8, ws=true, ws-path=/ray, ws-headers=Host:cdn.example.com, tls=true, sni=cdn.example.com`,
+			want: map[string]any{"name": "surge-vmess", "type": "vmess", "server": "vmess.example.com", "port": "443", "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "network": "ws", "ws-opts.path": "/ray", "ws-opts.headers.Host": "cdn.example.com", "tls": "1", "servername": "cdn.example.com"},
+		},
+		{
+			args: `surge-ss = ss, ss.example.com, 8388, encrypt-method=aes-256-gcm, password=pass, obfs=http, obfs-host=bing.com, udp-relay=true`,
+			want: map[string]any{"type": "ss", "cipher": "aes-256-gcm", "password": "pass", "plugin": "obfs", "plugin-opts.mode": "http", "plugin-opts.host": "bing.com", "udp": "1"},
+		},
+		{
+			args: `surge-http = https, http.example.com, 443, user, pass`,
+			want: map[string]any{"type": "http", "username": "user", "password": "pass", "tls": "1"},
+		},
+		{
+			args: `surge-snell = snell, snell.example.com, 443, psk=psk, version=2, obfs=tls`,
+			want: map[string]any{"type": "snell", "psk": "psk", "version": "2", "obfs-opts.mode": "tls"},
+		},
+		{
+			args: `loon-ss = Shadowsocks,ss.example.com,443,aes-128-gcm,"pa,ss",obfs-name=http,obfs-host=bing.com`,
+			want: map[string]any{"name": "loon-ss", "type": "ss", "cipher": "aes-128-gcm", "password": "pa,ss", "plugin-opts.mode": "http"},
+		},
+		{
+			args: `loon-vmess = vmess,vmess.example.com,443,aes-128-gcm,"047184b7-6da2-3d3f-ac27-6a1a8701daf8",transport=ws,path=/ws,host=cdn.example.com,over-tls=true,tls-name=cdn.example.com,alterId=0`,
+			want: map[string]any{"type": "vmess", "cipher": "aes-128-gcm", "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "ws-opts.path": "/ws", "tls": "1"},
+		},
+		{
+			args: `vmess=vmess.example.com:443, method=chacha20-ietf-poly1305, password=047184b7-6da2-3d3f-ac27-6a1a8701daf8, obfs=wss, obfs-host=cdn.example.com, obfs-uri=/ws, tls-verification=false, tag=quanx-vmess`,
+			want: map[string]any{"name": "quanx-vmess", "type": "vmess", "server": "vmess.example.com", "cipher": "chacha20-poly1305", "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "network": "ws", "ws-opts.headers.Host": "cdn.example.com", "tls": "1", "skip-cert-verify": "1"},
+		},
+		{
+			args: `shadowsocks=ssr.example.com:443, method=aes-128-cfb, password=pass, ssr-protocol=auth_aes128_md5, ssr-protocol-param=1:pass, obfs=http_simple, obfs-host=bing.com, tag=quanx-ssr`,
+			want: map[string]any{"type": "ssr", "protocol": "auth_aes128_md5", "obfs": "http_simple", "obfs-param": "bing.com"},
+		},
+		{
+			args: `trojan=trojan.example.com:443, password=pass, over-tls=true, tls-host=trojan.example.com, tag=quanx-trojan`,
+			want: map[string]any{"type": "trojan", "password": "pass", "sni": "trojan.example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			p, err := adapter.ParseProxyLine(tt.args)
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+
+			m := utils.NewMap(p.ToNico()).EnableCut(".")
+			for key, want := range tt.want {
+				if got := m.GetString(key); got != want {
+					t.Errorf("%s: got %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseProxyLineError(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"broken",
+		"x = vmess, vmess.example.com, abc",
+		"x = unknown, vmess.example.com, 443",
+		"vmess=vmess.example.com:443, method=none, password=047184b7-6da2-3d3f-ac27-6a1a8701daf8, obfs=http, tag=x",
+	} {
+		_, err := adapter.ParseProxyLine(s)
+		if err == nil {
+			t.Errorf("%s: expect error", s)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package adapter
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/darabuchi/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultClashProxyGroup = "Proxy"
+	defaultClashAutoGroup  = "Auto"
+	defaultClashTestUrl    = "https://www.gstatic.com/generate_204"
+)
+
+type ClashProfileOption struct {
+	MixedPort int
+
+	// 代理组名称，规则中的 Proxy 会指向这个组
+	ProxyGroup string
+	AutoGroup  string
+
+	TestUrl      string
+	TestInterval int
+	Tolerance    int
+
+	// 按 ExtraInfo.CountryCode 额外生成 url-test 分组
+	GroupByCountry bool
+
+	Rules []RuleInfo
+}
+
+type ClashProxyGroup struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type"`
+	Proxies   []string `yaml:"proxies"`
+	Url       string   `yaml:"url,omitempty"`
+	Interval  int      `yaml:"interval,omitempty"`
+	Tolerance int      `yaml:"tolerance,omitempty"`
+}
+
+type ClashProfile struct {
+	MixedPort   int               `yaml:"mixed-port,omitempty"`
+	AllowLan    bool              `yaml:"allow-lan"`
+	Mode        string            `yaml:"mode"`
+	LogLevel    string            `yaml:"log-level"`
+	Proxies     []map[string]any  `yaml:"proxies"`
+	ProxyGroups []ClashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+}
+
+func (opt *ClashProfileOption) fill() {
+	if opt.ProxyGroup == "" {
+		opt.ProxyGroup = defaultClashProxyGroup
+	}
+
+	if opt.AutoGroup == "" {
+		opt.AutoGroup = defaultClashAutoGroup
+	}
+
+	if opt.TestUrl == "" {
+		opt.TestUrl = defaultClashTestUrl
+	}
+
+	if opt.TestInterval <= 0 {
+		opt.TestInterval = 300
+	}
+
+	if opt.Tolerance <= 0 {
+		opt.Tolerance = 50
+	}
+}
+
+func (ss ProxyList) ToClashProfile(opt ClashProfileOption) *ClashProfile {
+	opt.fill()
+
+	profile := &ClashProfile{
+		MixedPort: opt.MixedPort,
+		AllowLan:  false,
+		Mode:      "rule",
+		LogLevel:  "info",
+	}
+
+	reserved := map[string]bool{
+		opt.ProxyGroup: true,
+		opt.AutoGroup:  true,
+		"DIRECT":       true,
+		"REJECT":       true,
+	}
+
+	var names []string
+	countryMap := map[string][]string{}
+	used := map[string]bool{}
+	ss.Each(func(proxy AdapterProxy) {
+		m := clashProxy(proxy)
+		if m == nil {
+			return
+		}
+
+		name := uniqueClashName(proxy.Name(), used, reserved)
+		m["name"] = name
+
+		profile.Proxies = append(profile.Proxies, m)
+		names = append(names, name)
+
+		if opt.GroupByCountry {
+			code := strings.ToUpper(proxy.GetExtraInfo().CountryCode)
+			if code != "" {
+				countryMap[code] = append(countryMap[code], name)
+			}
+		}
+	})
+
+	if len(names) == 0 {
+		// clash 不允许空的代理组
+		names = append(names, "DIRECT")
+	}
+
+	var countryGroups []string
+	for code := range countryMap {
+		countryGroups = append(countryGroups, code)
+	}
+	sort.Strings(countryGroups)
+
+	for i, code := range countryGroups {
+		group := uniqueClashName(code, used, reserved)
+		countryGroups[i] = group
+		profile.ProxyGroups = append(profile.ProxyGroups, ClashProxyGroup{
+			Name:      group,
+			Type:      "url-test",
+			Proxies:   countryMap[code],
+			Url:       opt.TestUrl,
+			Interval:  opt.TestInterval,
+			Tolerance: opt.Tolerance,
+		})
+	}
+
+	selectProxies := append([]string{opt.AutoGroup}, countryGroups...)
+	selectProxies = append(selectProxies, names...)
+	if names[0] != "DIRECT" {
+		selectProxies = append(selectProxies, "DIRECT")
+	}
+
+	profile.ProxyGroups = append([]ClashProxyGroup{
+		{
+			Name:    opt.ProxyGroup,
+			Type:    "select",
+			Proxies: selectProxies,
+		},
+		{
+			Name:      opt.AutoGroup,
+			Type:      "url-test",
+			Proxies:   names,
+			Url:       opt.TestUrl,
+			Interval:  opt.TestInterval,
+			Tolerance: opt.Tolerance,
+		},
+	}, profile.ProxyGroups...)
+
+	for _, info := range opt.Rules {
+		r, err := ClashRule(info, opt.ProxyGroup)
+		if err != nil {
+			log.Warnf("skip rule %s,%s,%s:%v", info.Rule, info.Payload, info.Adapter, err)
+			continue
+		}
+
+		profile.Rules = append(profile.Rules, r)
+	}
+
+	// nico 未命中规则时默认直连
+	profile.Rules = append(profile.Rules, "MATCH,DIRECT")
+
+	return profile
+}
+
+func (ss ProxyList) Sub4ClashProfile(opt ClashProfileOption) ([]byte, error) {
+	buf, err := yaml.Marshal(ss.ToClashProfile(opt))
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func clashProxy(proxy AdapterProxy) map[string]any {
+	m := proxy.ToNico()
+
+	switch m["type"] {
+	case "direct", "reject", "Unknown", nil:
+		return nil
+	}
+
+	// nico 内部字段，clash 不需要
+	for key := range skipUniqueKeyMap {
+		if key == "name" {
+			continue
+		}
+		delete(m, key)
+	}
+	delete(m, "")
+
+	return m
+}
+
+func uniqueClashName(name string, used, reserved map[string]bool) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "node"
+	}
+
+	newName := name
+	for i := 2; used[newName] || reserved[newName]; i++ {
+		newName = fmt.Sprintf("%s %d", name, i)
+	}
+
+	used[newName] = true
+
+	return newName
+}
+
+func ClashRule(info RuleInfo, proxyGroup string) (string, error) {
+	var target string
+	switch ParseAdapterType(info.Adapter) {
+	case Direct:
+		target = "DIRECT"
+	case Reject:
+		target = "REJECT"
+	case Proxy:
+		target = proxyGroup
+	default:
+		return "", fmt.Errorf("unknown adapter %s", info.Adapter)
+	}
+
+	hostCIDR := func(s string) (string, error) {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return "", err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+	}
+
+	var rule, payload string
+	var noResolve bool
+	switch info.Rule {
+	case Domain.String():
+		rule, payload = "DOMAIN", info.Payload
+	case DomainKey.String():
+		rule, payload = "DOMAIN-KEYWORD", info.Payload
+	case DomainSuffix.String():
+		rule, payload = "DOMAIN-SUFFIX", info.Payload
+	case ScrIp.String():
+		p, err := hostCIDR(info.Payload)
+		if err != nil {
+			return "", err
+		}
+		rule, payload = "SRC-IP-CIDR", p
+	case SrcIPCIDR.String():
+		rule, payload = "SRC-IP-CIDR", info.Payload
+	case SrcPort.String():
+		rule, payload = "SRC-PORT", info.Payload
+	case DstIp.String():
+		p, err := hostCIDR(info.Payload)
+		if err != nil {
+			return "", err
+		}
+		rule, payload, noResolve = "IP-CIDR", p, true
+	case DstIPCIDR.String():
+		rule, payload, noResolve = "IP-CIDR", info.Payload, true
+	case DstPort.String():
+		rule, payload = "DST-PORT", info.Payload
+	case Process.String():
+		rule, payload = "PROCESS-NAME", info.Payload
+	case ProcessPath.String():
+		rule, payload = "PROCESS-PATH", info.Payload
+	default:
+		return "", fmt.Errorf("unsupported rule %s", info.Rule)
+	}
+
+	if strings.Contains(payload, ",") {
+		return "", fmt.Errorf("invalid payload %s", payload)
+	}
+
+	if noResolve && strings.Contains(payload, ":") {
+		rule = "IP-CIDR6"
+	}
+
+	if noResolve {
+		return fmt.Sprintf("%s,%s,%s,no-resolve", rule, payload, target), nil
+	}
+
+	return fmt.Sprintf("%s,%s,%s", rule, payload, target), nil
+}
@@ -0,0 +1,88 @@
+package adapter_test
+
+import (
+	"testing"
+
+	"github.com/darabuchi/nico/adapter"
+	"gopkg.in/yaml.v3"
+)
+
+func TestProxyList_Sub4ClashProfile(t *testing.T) {
+	var list adapter.ProxyList
+	for _, m := range []map[string]any{
+		{"name": "jp", "type": "ss", "server": "1.1.1.1", "port": 443, "cipher": "aes-128-gcm", "password": "a", "country_code": "JP"},
+		{"name": "jp", "type": "ss", "server": "1.1.1.2", "port": 443, "cipher": "aes-128-gcm", "password": "b", "country_code": "jp"},
+		{"name": "Proxy", "type": "trojan", "server": "1.1.1.3", "port": 443, "password": "c"},
+	} {
+		p, err := adapter.ParseClash(m)
+		if err != nil {
+			t.Errorf("err:%v", err)
+			return
+		}
+		list = append(list, p)
+	}
+
+	buf, err := list.Sub4ClashProfile(adapter.ClashProfileOption{
+		GroupByCountry: true,
+		Rules: []adapter.RuleInfo{
+			{Rule: adapter.Domain.String(), Payload: "www.google.com", Adapter: adapter.Proxy.String()},
+			{Rule: adapter.DstIp.String(), Payload: "8.8.8.8", Adapter: adapter.Reject.String()},
+			{Rule: adapter.ProcessDir.String(), Payload: "/usr/bin", Adapter: adapter.Direct.String()},
+		},
+	})
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	var profile adapter.ClashProfile
+	err = yaml.Unmarshal(buf, &profile)
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	var names []string
+	for _, proxy := range profile.Proxies {
+		if _, ok := proxy["unique_id"]; ok {
+			t.Errorf("unique_id should not be exported")
+		}
+		names = append(names, proxy["name"].(string))
+	}
+
+	wantNames := []string{"jp", "jp 2", "Proxy 2"}
+	if len(names) != len(wantNames) {
+		t.Fatalf("names:%v", names)
+	}
+	for i := range names {
+		if names[i] != wantNames[i] {
+			t.Errorf("name %d: got %s, want %s", i, names[i], wantNames[i])
+		}
+	}
+
+	if len(profile.ProxyGroups) != 3 {
+		t.Fatalf("groups:%+v", profile.ProxyGroups)
+	}
+
+	if g := profile.ProxyGroups[0]; g.Name != "Proxy" || g.Type != "select" || g.Proxies[0] != "Auto" || g.Proxies[1] != "JP" {
+		t.Errorf("unexpected select group:%+v", g)
+	}
+
+	if g := profile.ProxyGroups[2]; g.Name != "JP" || g.Type != "url-test" || len(g.Proxies) != 2 {
+		t.Errorf("unexpected country group:%+v", g)
+	}
+
+	wantRules := []string{
+		"DOMAIN,www.google.com,Proxy",
+		"IP-CIDR,8.8.8.8/32,REJECT,no-resolve",
+		"MATCH,DIRECT",
+	}
+	if len(profile.Rules) != len(wantRules) {
+		t.Fatalf("rules:%v", profile.Rules)
+	}
+	for i := range wantRules {
+		if profile.Rules[i] != wantRules[i] {
+			t.Errorf("rule %d: got %s, want %s", i, profile.Rules[i], wantRules[i])
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package adapter
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
 	"net/http"
 	"net/url"
@@ -74,28 +76,48 @@ type AdapterProxy interface {
 	constant.Proxy
 	Cache
 	
+	SetName(name string)
 	HostName() string
 	Port() string
 	
 	Sub4Nico() string
 	Sub4Clash() string
 	Sub4V2ray() string
+	Sub4SingBox() (string, error)
+	Sub4Surge() (string, error)
+	Sub4QuanX() (string, error)
 	
 	ToNico() map[string]any
+	GetExtraInfo() ExtraInfo
+	SetExtraInfo(info ExtraInfo)
 	
 	UniqueId() string
 	UniqueIdShort() string
 	
+	// DialerProxy 配置的跳板名称，SetDialer 设置解析后的跳板
+	DialerProxy() string
+	SetDialer(d Dialer)
+	
+	// SetTlsConfig 节点探测请求使用的证书校验配置，nil 时使用全局配置
+	SetTlsConfig(c *tls.Config)
+	
 	GenDialContext(u *url.URL) (constant.Conn, error)
 	
 	GetClient() *http.Client
 	
 	DoRequest(method, rawUrl string, body io.Reader, timeout time.Duration, headers map[string]string, logic func(resp *http.Response, start time.Time) error) error
+	DoRequestContext(ctx context.Context, method, rawUrl string, body io.Reader, timeout time.Duration, headers map[string]string, logic func(resp *http.Response, start time.Time) error) error
 	
 	Get(url string, timeout time.Duration, headers map[string]string) ([]byte, error)
 	
 	GetTotalUpload() uint64
 	GetTotalDownload() uint64
+	RestoreTotal(upload, download uint64)
+	
+	RecordDelay(r DelayRecord)
+	DelayRecords() []DelayRecord
+	DelayStats() DelayStats
+	Score() float64
 }
 
 //go:generate pie ProxyList.*
@@ -14,6 +14,7 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 	
 	"github.com/Dreamacro/clash/adapter/outbound"
@@ -21,7 +22,6 @@ import (
 	"github.com/Luoxin/faker"
 	"github.com/darabuchi/log"
 	"github.com/darabuchi/utils"
-	"github.com/elliotchance/pie/pie"
 	"go.uber.org/atomic"
 	"gopkg.in/yaml.v3"
 )
@@ -30,6 +30,7 @@ type ProxyAdapter struct {
 	constant.Proxy
 	Cache
 	ExtraInfo
+	lock sync.RWMutex
 	
 	opt map[string]any
 	
@@ -40,6 +41,14 @@ type ProxyAdapter struct {
 	host string
 	
 	tracker *TotalTracker
+	
+	history *DelayHistory
+	
+	// dialer 跳板，为空时直接连接
+	dialer Dialer
+	
+	// tlsConfig 探测请求的证书校验配置，为空时使用全局配置
+	tlsConfig *tls.Config
 }
 
 func NewProxyAdapter(adapter constant.Proxy, opt any) (*ProxyAdapter, error) {
@@ -48,6 +57,7 @@ func NewProxyAdapter(adapter constant.Proxy, opt any) (*ProxyAdapter, error) {
 		name:    adapter.Name(),
 		opt:     map[string]any{},
 		tracker: NewTotalTracker(),
+		history: NewDelayHistory(MaxHistory),
 	}
 	
 	p.Cache = NewAdapterCache()
@@ -63,6 +73,7 @@ func NewProxyAdapter(adapter constant.Proxy, opt any) (*ProxyAdapter, error) {
 		outbound.HttpOption,
 		outbound.Socks5Option,
 		outbound.HysteriaOption,
+		outbound.SnellOption,
 		outbound.TrojanOption:
 		err := decode(p.opt, &v)
 		if err != nil {
@@ -76,6 +87,7 @@ func NewProxyAdapter(adapter constant.Proxy, opt any) (*ProxyAdapter, error) {
 		*outbound.HttpOption,
 		*outbound.Socks5Option,
 		*outbound.HysteriaOption,
+		*outbound.SnellOption,
 		*outbound.TrojanOption:
 		err := decode(p.opt, v)
 		if err != nil {
@@ -91,85 +103,14 @@ func NewProxyAdapter(adapter constant.Proxy, opt any) (*ProxyAdapter, error) {
 	
 	delete(p.opt, "Name")
 	
-	var updateUnionId func(opt any) string
-	updateUnionId = func(value any) string {
-		if value == nil {
-			return ""
-		}
-		
-		var unionId string
-		
-		switch opt := value.(type) {
-		case map[string]any:
-			var keys pie.Strings
-			for key := range opt {
-				keys = append(keys, key)
-			}
-			
-			keys.Each(func(key string) {
-				val := opt[key]
-				if value == nil {
-					return
-				}
-				
-				unionId += fmt.Sprintf(`%s:"%v",`, key, updateUnionId(val))
-			})
-			
-			unionId = fmt.Sprintf("{%s}", unionId)
-		case map[any]any:
-			var keys pie.Strings
-			nopt := map[string]any{}
-			for key := range opt {
-				// 跳过部分字段
-				if skipUniqueKeyMap[fmt.Sprintf("%v", key)] {
-					continue
-				}
-				
-				keys = append(keys, fmt.Sprintf("%v", key))
-				nopt[fmt.Sprintf("%v", key)] = opt[key]
-			}
-			
-			keys.Each(func(key string) {
-				val := nopt[key]
-				if value == nil {
-					return
-				}
-				
-				unionId += fmt.Sprintf(`%s:"%v"`, key, updateUnionId(val))
-			})
-			
-			unionId = fmt.Sprintf("{%s}", unionId)
-		case string, []byte,
-			uint32, uint64, uint, uint8,
-			float64, float32,
-			int32, int64, int, int8,
-			bool, json.Number:
-			unionId = fmt.Sprintf("%v", opt)
-		case []any:
-			var vals pie.Strings
-			for _, val := range opt {
-				vals = append(vals, updateUnionId(val))
-			}
-			unionId = fmt.Sprintf(`["%s"]`, vals.Join(`","`))
-		case constant.AdapterType:
-			unionId = fmt.Sprintf("%s", opt)
-		default:
-			log.Panicf("unknown type:%v,value:%v", reflect.TypeOf(value), value)
-		}
-		
-		return unionId
-	}
-	
-	p.opt["name"] = ""
-	
-	p.uniqueId = utils.Sha256(updateUnionId(p.opt))
-	// p.uniqueId = updateUnionId(p.opt)
+	p.uniqueId = CanonicalUniqueId(p.opt)
 	
 	if p.name == "" {
 		p.name = utils.ShortStr(p.uniqueId, 12)
 	}
 	
 	p.opt["unique_id"] = p.uniqueId
+	p.opt[uniqueIdVersionKey] = UniqueIdVersion
 	
 	p.name = strings.TrimSuffix(p.name, "\n")
 	p.name = strings.TrimSuffix(p.name, "\r")
@@ -186,7 +127,9 @@ func (p *ProxyAdapter) cloneOpt() map[string]any {
 		o[k] = v
 	}
 	
-	o["name"] = p.name
+	o["name"] = p.Name()
+	
+	p.GetExtraInfo().fill(o)
 	
 	return o
 }
@@ -204,6 +147,20 @@ func (p *ProxyAdapter) ToNico() map[string]any {
 	return p.cloneOpt()
 }
 
+func (p *ProxyAdapter) GetExtraInfo() ExtraInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	
+	return p.ExtraInfo
+}
+
+func (p *ProxyAdapter) SetExtraInfo(info ExtraInfo) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	
+	p.ExtraInfo = info
+}
+
 func (p *ProxyAdapter) Sub4Clash() string {
 	buf, err := yaml.Marshal(p.cloneOpt())
 	if err != nil {
@@ -261,6 +218,59 @@ func (p *ProxyAdapter) Sub4V2ray() string {
 		
 		setQuery("security", "tls")
 		setQuery("headerType", "none")
+	case constant.Vless:
+		o := newExportOpt(opt)
+		
+		u.Fragment = p.Name()
+		u.Host = p.Addr()
+		u.User = url.User(o.GetString("uuid"))
+		
+		network := o.network()
+		setQuery("type", network)
+		
+		switch {
+		case o.GetString("reality-opts.public-key") != "":
+			setQuery("security", "reality")
+			setQuery("pbk", o.GetString("reality-opts.public-key"))
+			if sid := o.GetString("reality-opts.short-id"); sid != "" {
+				setQuery("sid", sid)
+			}
+			if spx := o.GetString("reality-opts.spider-x"); spx != "" {
+				setQuery("spx", spx)
+			}
+		case o.GetBool("tls"):
+			setQuery("security", "tls")
+		default:
+			setQuery("security", "none")
+		}
+		
+		if sni := o.serverName(); sni != "" {
+			setQuery("sni", sni)
+		}
+		if fp := o.GetString("client-fingerprint"); fp != "" {
+			setQuery("fp", fp)
+		}
+		if flow := o.GetString("flow"); flow != "" {
+			setQuery("flow", flow)
+		}
+		if o.GetBool("skip-cert-verify") {
+			setQuery("allowInsecure", "1")
+		}
+		
+		switch network {
+		case "ws":
+			setQuery("path", o.wsPath())
+			if host := o.wsHost(); host != "" {
+				setQuery("host", host)
+			}
+		case "grpc":
+			setQuery("serviceName", o.GetString("grpc-opts.grpc-service-name"))
+		case "h2":
+			setQuery("path", o.GetString("h2-opts.path"))
+			if host := o.GetStringSlice("h2-opts.host"); len(host) > 0 {
+				setQuery("host", host[0])
+			}
+		}
 	case constant.Shadowsocks:
 		u.Fragment = p.Name()
 		u.User = url.UserPassword(
@@ -304,7 +314,7 @@ func (p *ProxyAdapter) coverAdapterType(adapterType constant.AdapterType) string
 	case constant.Snell:
 		return "snell"
 	case constant.Socks5:
-		return "socks_5"
+		return "socks5"
 	case constant.Http:
 		return "http"
 	case constant.Vmess:
@@ -313,6 +323,8 @@ func (p *ProxyAdapter) coverAdapterType(adapterType constant.AdapterType) string
 		return "trojan"
 	case constant.Vless:
 		return "vless"
+	case constant.Hysteria:
+		return "hysteria"
 	default:
 		return "Unknown"
 	}
@@ -327,7 +339,11 @@ func (p *ProxyAdapter) UniqueIdShort() string {
 }
 
 func (p *ProxyAdapter) GenDialContext(u *url.URL) (constant.Conn, error) {
-	return p.DialContext(context.TODO(), &constant.Metadata{
+	return p.dialUrl(context.TODO(), u)
+}
+
+func (p *ProxyAdapter) dialUrl(ctx context.Context, u *url.URL) (constant.Conn, error) {
+	return p.DialContext(ctx, &constant.Metadata{
 		AddrType: constant.AtypDomainName,
 		Host:     u.Hostname(),
 		DstPort: func() string {
@@ -384,7 +400,11 @@ func (p *ProxyAdapter) GetClient() *http.Client {
 }
 
 func (p *ProxyAdapter) DoRequest(method, rawUrl string, body io.Reader, timeout time.Duration, headers map[string]string, logic func(resp *http.Response, start time.Time) error) error {
-	
+	return p.DoRequestContext(context.Background(), method, rawUrl, body, timeout, headers, logic)
+}
+
+// DoRequestContext ctx 取消时中断连接节点和请求
+func (p *ProxyAdapter) DoRequestContext(ctx context.Context, method, rawUrl string, body io.Reader, timeout time.Duration, headers map[string]string, logic func(resp *http.Response, start time.Time) error) error {
 	if timeout == 0 {
 		timeout = time.Second * 5
 	}
@@ -394,7 +414,7 @@ func (p *ProxyAdapter) DoRequest(method, rawUrl string, body io.Reader, timeout
 		return err
 	}
 	
-	request, err := http.NewRequest(method, rawUrl, body)
+	request, err := http.NewRequestWithContext(ctx, method, rawUrl, body)
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
@@ -418,7 +438,7 @@ func (p *ProxyAdapter) DoRequest(method, rawUrl string, body io.Reader, timeout
 	
 	start := time.Now()
 	
-	instance, err := p.GenDialContext(u)
+	instance, err := p.dialUrl(ctx, u)
 	if err != nil {
 		log.Errorf("err:%v", err)
 		return err
@@ -430,9 +450,7 @@ func (p *ProxyAdapter) DoRequest(method, rawUrl string, body io.Reader, timeout
 			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 				return newTicker(instance, nil, p.tracker), nil
 			},
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+			TLSClientConfig:       p.getTlsConfig(),
 			TLSHandshakeTimeout:   time.Second * 3,
 			DisableCompression:    true,
 			IdleConnTimeout:       time.Second * 3,
@@ -538,21 +556,57 @@ func (p *ProxyAdapter) Clone() AdapterProxy {
 	np := &ProxyAdapter{
 		Proxy:     p.Proxy,
 		Cache:     NewAdapterCache(),
-		ExtraInfo: p.ExtraInfo,
+		ExtraInfo: p.GetExtraInfo(),
 		opt:       p.opt,
 		uniqueId:  p.uniqueId,
-		name:      p.name,
+		name:      p.Name(),
 		port:      p.port,
 		host:      p.host,
+		tracker:   NewTotalTracker(),
+		history:   NewDelayHistory(MaxHistory),
+		dialer:    p.getDialer(),
+		tlsConfig: p.loadTlsConfig(),
 	}
 	
 	return np
 }
 
 func (p *ProxyAdapter) Name() string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	
 	return p.name
 }
 
+func (p *ProxyAdapter) SetName(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	
+	p.name = name
+}
+
+// RecordDelay 记录一次探测，delay 为 0 表示失败
+func (p *ProxyAdapter) RecordDelay(r DelayRecord) {
+	p.history.Record(r)
+}
+
+func (p *ProxyAdapter) DelayRecords() []DelayRecord {
+	return p.history.Records()
+}
+
+func (p *ProxyAdapter) DelayStats() DelayStats {
+	return p.history.Stats()
+}
+
+// DelayHistory 使用 nico 自己的探测记录，而非 clash URLTest 内部的
+func (p *ProxyAdapter) DelayHistory() []constant.DelayHistory {
+	return toClashDelayHistory(p.history.Records())
+}
+
+func (p *ProxyAdapter) Score() float64 {
+	return Score(p.DelayStats(), p.LoadFloat64(CacheSpeed))
+}
+
 func (p *ProxyAdapter) HostName() string {
 	return p.host
 }
@@ -568,18 +622,25 @@ func (p *ProxyAdapter) GetTotalDownload() uint64 {
 	return p.tracker.Download()
 }
 
+// RestoreTotal 恢复历史流量统计，不会转发给注册的 Tracker
+func (p *ProxyAdapter) RestoreTotal(upload, download uint64) {
+	p.tracker.upload.Add(upload)
+	p.tracker.download.Add(download)
+}
+
 func (p *ProxyAdapter) RegisterTotalTracker(tracker Tracker) *ProxyAdapter {
 	p.tracker.RegisterTotalTracker(tracker)
 	return p
 }
 
-func decodeSlice(dst []any, src any) error {
+func decodeSlice(src any) ([]any, error) {
 	t := reflect.TypeOf(src)
 	if t.Kind() != reflect.Slice {
-		panic("src is not map")
+		panic("src is not slice")
 	}
 	
 	v := reflect.ValueOf(src)
+	dst := make([]any, 0, v.Len())
 	
 	for i := 0; i < v.Len(); i++ {
 		lv := v.Index(i)
@@ -611,16 +672,15 @@ func decodeSlice(dst []any, src any) error {
 			err := decodeMap(m, lv.Interface())
 			if err != nil {
 				log.Debugf("err:%v", err)
-				return err
+				return nil, err
 			}
 		case reflect.Slice:
-			l := []any{}
-			dst = append(dst, l)
-			err := decodeSlice(l, lv.Interface())
+			l, err := decodeSlice(lv.Interface())
 			if err != nil {
 				log.Debugf("err:%v", err)
-				return err
+				return nil, err
 			}
+			dst = append(dst, l)
 		case reflect.String:
 			dst = append(dst, lv.String())
 		case reflect.Struct, reflect.Ptr:
@@ -629,14 +689,14 @@ func decodeSlice(dst []any, src any) error {
 			err := decode(m, lv.Interface())
 			if err != nil {
 				log.Debugf("err:%v", err)
-				return err
+				return nil, err
 			}
 		default:
 			log.Debugf("unknown kind %s", lv.Kind())
 		}
 	}
 	
-	return nil
+	return dst, nil
 }
 
 func decodeMap(dst map[string]any, src any) error {
@@ -681,13 +741,12 @@ func decodeMap(dst map[string]any, src any) error {
 				return err
 			}
 		case reflect.Slice:
-			l := []any{}
-			dst[mk] = l
-			err := decodeSlice(l, mv.Interface())
+			l, err := decodeSlice(mv.Interface())
 			if err != nil {
 				log.Debugf("err:%v", err)
 				return err
 			}
+			dst[mk] = l
 		case reflect.String:
 			dst[mk] = mv.String()
 		case reflect.Struct, reflect.Ptr:
@@ -754,13 +813,12 @@ func decode(dst map[string]any, src any) error {
 				return err
 			}
 		case reflect.Slice:
-			l := []any{}
-			dst[tag] = l
-			err := decodeSlice(l, fv.Interface())
+			l, err := decodeSlice(fv.Interface())
 			if err != nil {
 				log.Debugf("err:%v", err)
 				return err
 			}
+			dst[tag] = l
 		case reflect.String:
 			dst[tag] = fv.String()
 		case reflect.Struct, reflect.Ptr:
@@ -0,0 +1,137 @@
+package adapter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	
+	"github.com/darabuchi/log"
+)
+
+// DefaultNameTpl 生成如 `🇯🇵 JP-Tokyo 03 | 120ms` 的名称
+const DefaultNameTpl = `{{.CountryEmoji}} {{or .CountryCode "UN"}}{{with .City}}-{{.}}{{end}} {{printf "%02d" .Seq}}{{with .Delay}} | {{.}}ms{{end}}`
+
+// NameData 渲染名称模板时可用的字段
+type NameData struct {
+	ExtraInfo
+	
+	// Name 原名称
+	Name string
+	// Type 协议，如 vmess、trojan
+	Type string
+	
+	Delay    uint16
+	Speed    float64
+	SpeedStr string
+	
+	// Seq 同一国家内的序号，从 1 开始
+	Seq int
+	// Index 在整个列表中的序号，从 1 开始
+	Index int
+}
+
+type Renamer struct {
+	tpl *template.Template
+}
+
+func NewRenamer(tpl string) (*Renamer, error) {
+	if tpl == "" {
+		tpl = DefaultNameTpl
+	}
+	
+	t, err := template.New("name").Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+	
+	return &Renamer{
+		tpl: t,
+	}, nil
+}
+
+func (p *Renamer) render(data *NameData) (string, error) {
+	var b bytes.Buffer
+	err := p.tpl.Execute(&b, data)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return "", err
+	}
+	
+	// 空字段留下的多余空格
+	return strings.Join(strings.Fields(b.String()), " "), nil
+}
+
+// Names 按列表顺序生成新名称，不修改节点；重名时依次追加 ` 2`、` 3`
+func (p *Renamer) Names(ss ProxyList) ([]string, error) {
+	seqMap := map[string]int{}
+	used := map[string]bool{}
+	
+	names := make([]string, 0, len(ss))
+	for i, proxy := range ss {
+		info := proxy.GetExtraInfo()
+		
+		code := strings.ToUpper(info.CountryCode)
+		seqMap[code]++
+		
+		name, err := p.render(&NameData{
+			ExtraInfo: info,
+			Name:      proxy.Name(),
+			Type:      fmt.Sprintf("%v", proxy.ToNico()["type"]),
+			Delay:     proxy.LoadUint16(CacheDelay),
+			Speed:     proxy.LoadFloat64(CacheSpeed),
+			SpeedStr: func() string {
+				val, err := proxy.Load(CacheSpeedStr)
+				if err != nil {
+					return ""
+				}
+				s, _ := val.(string)
+				return s
+			}(),
+			Seq:   seqMap[code],
+			Index: i + 1,
+		})
+		if err != nil {
+			return nil, err
+		}
+		
+		if name == "" {
+			name = proxy.UniqueIdShort()
+		}
+		
+		candidate := name
+		for n := 2; used[candidate]; n++ {
+			candidate = fmt.Sprintf("%s %d", name, n)
+		}
+		used[candidate] = true
+		
+		names = append(names, candidate)
+	}
+	
+	return names, nil
+}
+
+// Rename 按模板重命名列表中的所有节点
+func (p *Renamer) Rename(ss ProxyList) error {
+	names, err := p.Names(ss)
+	if err != nil {
+		return err
+	}
+	
+	for i, proxy := range ss {
+		proxy.SetName(names[i])
+	}
+	
+	return nil
+}
+
+// Rename 使用模板重命名，tpl 为空时使用 DefaultNameTpl
+func (ss ProxyList) Rename(tpl string) error {
+	r, err := NewRenamer(tpl)
+	if err != nil {
+		return err
+	}
+	
+	return r.Rename(ss)
+}
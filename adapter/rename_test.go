@@ -0,0 +1,78 @@
+package adapter_test
+
+import (
+	"testing"
+	
+	"github.com/darabuchi/nico/adapter"
+)
+
+func TestProxyList_Rename(t *testing.T) {
+	var list adapter.ProxyList
+	for _, m := range []map[string]any{
+		{"name": "a", "type": "trojan", "server": "a.example.com", "port": 443, "password": "pass", "country_code": "JP", "country_emoji": "🇯🇵", "city": "Tokyo"},
+		{"name": "b", "type": "trojan", "server": "b.example.com", "port": 443, "password": "pass", "country_code": "US", "country_emoji": "🇺🇸"},
+		{"name": "c", "type": "trojan", "server": "c.example.com", "port": 443, "password": "pass", "country_code": "JP", "country_emoji": "🇯🇵", "city": "Tokyo"},
+		{"name": "d", "type": "trojan", "server": "d.example.com", "port": 443, "password": "pass"},
+	} {
+		p, err := adapter.ParseClash(m)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		list = append(list, p)
+	}
+	
+	list[2].Store(adapter.CacheDelay, 120)
+	
+	tests := []struct {
+		tpl  string
+		want []string
+	}{
+		{
+			tpl:  "",
+			want: []string{"🇯🇵 JP-Tokyo 01", "🇺🇸 US 01", "🇯🇵 JP-Tokyo 02 | 120ms", "UN 01"},
+		},
+		{
+			tpl:  "{{.Type}}-{{.CountryCode}}",
+			want: []string{"trojan-JP", "trojan-US", "trojan-JP 2", "trojan-"},
+		},
+		{
+			tpl:  "{{.Index}}-{{.Name}}",
+			want: []string{"1-a", "2-b", "3-c", "4-d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tpl, func(t *testing.T) {
+			r, err := adapter.NewRenamer(tt.tpl)
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+			
+			got, err := r.Names(list)
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+			
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("%d: got %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+	
+	err := list.Rename("")
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+	
+	if list[0].Name() != "🇯🇵 JP-Tokyo 01" || list[0].ToNico()["name"] != "🇯🇵 JP-Tokyo 01" {
+		t.Errorf("got %s", list[0].Name())
+	}
+	
+	if _, err = adapter.NewRenamer("{{.Unknown"); err == nil {
+		t.Errorf("expect error")
+	}
+}
@@ -64,4 +64,7 @@ type RuleInfo struct {
 	Rule    string `json:"rule,omitempty" yaml:"rule,omitempty"`
 	Payload string `json:"payload,omitempty" yaml:"payload,omitempty"`
 	Adapter string `json:"adapter,omitempty" yaml:"adapter,omitempty"`
+
+	// Learned 运行中自动学习到的规则
+	Learned bool `json:"learned,omitempty" yaml:"learned,omitempty"`
 }
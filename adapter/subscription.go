@@ -0,0 +1,72 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/darabuchi/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseSubscription 解析订阅内容，支持 clash 配置（proxies）、base64 编码的链接列表
+// 以及逐行的 v2ray 链接或 Surge/Loon/Quantumult X 节点，无法解析的行会被跳过
+func ParseSubscription(b []byte) (ProxyList, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, ErrEmptyDate
+	}
+
+	var clash struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(b, &clash); err == nil && len(clash.Proxies) > 0 {
+		var list ProxyList
+		for _, m := range clash.Proxies {
+			p, err := ParseClash(m)
+			if err != nil {
+				log.Warnf("skip proxy %v:%v", m["name"], err)
+				continue
+			}
+			list = append(list, p)
+		}
+		return list, nil
+	}
+
+	// 整体不是 base64 时按明文处理
+	if s, err := decodeBase64(string(b)); err == nil {
+		b = []byte(s)
+	}
+
+	var list ProxyList
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		var p *ProxyAdapter
+		var err error
+		if strings.Contains(line, "://") {
+			p, err = ParseV2ray(line)
+		} else {
+			p, err = ParseProxyLine(line)
+		}
+		if err != nil {
+			log.Warnf("skip line %s:%v", line, err)
+			continue
+		}
+
+		list = append(list, p)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return list, nil
+}
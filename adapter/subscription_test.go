@@ -0,0 +1,73 @@
+package adapter_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/darabuchi/nico/adapter"
+)
+
+func TestParseSubscription(t *testing.T) {
+	links := "trojan://pass@a.example.com:443#a\n" +
+		"# comment\n" +
+		"not a node\n" +
+		"vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@b.example.com:443?security=tls&type=ws&path=%2Fws#b\n"
+
+	tests := []struct {
+		name    string
+		args    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			args: links,
+			want: []string{"a", "b"},
+		},
+		{
+			name: "base64",
+			args: base64.StdEncoding.EncodeToString([]byte(links)),
+			want: []string{"a", "b"},
+		},
+		{
+			name: "clash",
+			args: "proxies:\n" +
+				"  - {name: c, type: trojan, server: c.example.com, port: 443, password: pass}\n" +
+				"  - {name: bad, type: unknown}\n",
+			want: []string{"c"},
+		},
+		{
+			name: "surge",
+			args: "d = trojan, d.example.com, 443, password=pass",
+			want: []string{"d"},
+		},
+		{
+			name:    "empty",
+			args:    " \n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := adapter.ParseSubscription([]byte(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSubscription() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			var names []string
+			for _, p := range got {
+				names = append(names, p.Name())
+			}
+			if len(names) != len(tt.want) {
+				t.Errorf("ParseSubscription() got %v, want %v", names, tt.want)
+				return
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("ParseSubscription() got %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
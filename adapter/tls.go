@@ -0,0 +1,196 @@
+package adapter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	
+	"github.com/darabuchi/log"
+)
+
+var ErrFingerprintMismatch = errors.New("certificate fingerprint mismatch")
+
+// TlsOption nico 自身发出的 https 请求（测速、探测等）的证书校验配置
+//
+// 只作用于 DoRequest 发出的探测请求，不影响节点本身的 tls 连接，
+// 节点的证书校验由节点配置中的 skip-cert-verify、sni 等决定；
+// 后端建立节点连接时不支持证书指纹和自定义 CA（hysteria 的 ca、ca_str 除外），节点配置了这些字段时解析失败，见 checkNodeTls
+type TlsOption struct {
+	// SkipVerify 跳过证书校验，仅在确实需要时开启
+	SkipVerify bool `yaml:"skip_verify" json:"skip_verify"`
+	
+	// Fingerprints 叶子证书的 sha256 指纹，配置后只信任这些证书
+	Fingerprints []string `yaml:"fingerprints" json:"fingerprints"`
+	
+	// CaFile 额外信任的 CA 证书（PEM）
+	CaFile string `yaml:"ca_file" json:"ca_file"`
+	CaPem  string `yaml:"ca_pem" json:"ca_pem"`
+}
+
+var (
+	tlsLock   sync.RWMutex
+	tlsConfig = &tls.Config{}
+)
+
+// SetTlsOption 更新全局的证书校验配置，节点没有通过 SetTlsConfig 单独设置时使用
+func SetTlsOption(opt TlsOption) error {
+	c, err := opt.TlsConfig()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+	
+	tlsLock.Lock()
+	tlsConfig = c
+	tlsLock.Unlock()
+	
+	return nil
+}
+
+func getTlsConfig() *tls.Config {
+	tlsLock.RLock()
+	defer tlsLock.RUnlock()
+	
+	return tlsConfig.Clone()
+}
+
+// SetTlsConfig 只对这个节点生效，多个 Executor 可以使用不同的配置
+func (p *ProxyAdapter) SetTlsConfig(c *tls.Config) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	
+	p.tlsConfig = c
+}
+
+func (p *ProxyAdapter) loadTlsConfig() *tls.Config {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	
+	return p.tlsConfig
+}
+
+func (p *ProxyAdapter) getTlsConfig() *tls.Config {
+	c := p.loadTlsConfig()
+	if c == nil {
+		return getTlsConfig()
+	}
+	return c.Clone()
+}
+
+func (p TlsOption) TlsConfig() (*tls.Config, error) {
+	c := &tls.Config{
+		InsecureSkipVerify: p.SkipVerify,
+	}
+	
+	if p.CaFile != "" || p.CaPem != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			log.Warnf("load system cert pool fail:%v", err)
+			pool = x509.NewCertPool()
+		}
+		
+		if p.CaFile != "" {
+			buf, err := ioutil.ReadFile(p.CaFile)
+			if err != nil {
+				log.Errorf("err:%v", err)
+				return nil, err
+			}
+			
+			if !pool.AppendCertsFromPEM(buf) {
+				return nil, fmt.Errorf("no certificate found in %s", p.CaFile)
+			}
+		}
+		
+		if p.CaPem != "" {
+			if !pool.AppendCertsFromPEM([]byte(p.CaPem)) {
+				return nil, errors.New("no certificate found in ca_pem")
+			}
+		}
+		
+		c.RootCAs = pool
+	}
+	
+	if len(p.Fingerprints) > 0 {
+		var fingerprints [][]byte
+		for _, fp := range p.Fingerprints {
+			b, err := parseFingerprint(fp)
+			if err != nil {
+				log.Errorf("err:%v", err)
+				return nil, err
+			}
+			fingerprints = append(fingerprints, b)
+		}
+		
+		// 指纹固定时证书链由指纹决定，不再走系统校验
+		// 只比较叶子证书：握手只证明服务端持有叶子证书的私钥，链上其他证书任何人都可以附带
+		c.InsecureSkipVerify = true
+		c.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrFingerprintMismatch
+			}
+			
+			sum := sha256.Sum256(rawCerts[0])
+			for _, fp := range fingerprints {
+				if bytes.Equal(sum[:], fp) {
+					return nil
+				}
+			}
+			return ErrFingerprintMismatch
+		}
+	}
+	
+	return c, nil
+}
+
+// checkNodeTls 节点配置了后端不支持的证书指纹或 CA 时返回错误，避免在不做校验的情况下使用
+func checkNodeTls(m map[string]any) error {
+	typ, _ := m["type"].(string)
+	
+	keys := []string{"fingerprint", "pinned-peer-cert-sha256"}
+	if typ != "hysteria" {
+		keys = append(keys, "ca", "ca_str", "ca-str", "custom-ca")
+	}
+	
+	for _, key := range keys {
+		if val, ok := m[key]; ok && val != nil && val != "" {
+			log.Warnf("node %v configures %s, which is not supported when dialing %s nodes", m["name"], key, typ)
+			return unsupported("nico", typ, key)
+		}
+	}
+	
+	return nil
+}
+
+// parseFingerprint 支持 `AB:CD:...` 和不带分隔符的 hex
+func parseFingerprint(fp string) ([]byte, error) {
+	s := strings.ReplaceAll(strings.TrimSpace(fp), ":", "")
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fingerprint %s", fp)
+	}
+	
+	if len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid sha256 fingerprint %s", fp)
+	}
+	
+	return b, nil
+}
+
+// skipCertVerify 链接中显式声明 allowInsecure/insecure 时才跳过证书校验
+func skipCertVerify(query url.Values) bool {
+	for _, key := range []string{"allowInsecure", "insecure", "skip-cert-verify"} {
+		switch strings.ToLower(query.Get(key)) {
+		case "1", "true":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package adapter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	
+	"github.com/darabuchi/utils"
+)
+
+func TestTlsOption(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	
+	raw := srv.Certificate().Raw
+	sum := sha256.Sum256(raw)
+	caPem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}))
+	
+	tests := []struct {
+		name    string
+		opt     TlsOption
+		wantErr bool
+	}{
+		{
+			name:    "default",
+			opt:     TlsOption{},
+			wantErr: true,
+		},
+		{
+			name: "skip",
+			opt:  TlsOption{SkipVerify: true},
+		},
+		{
+			name: "ca",
+			opt:  TlsOption{CaPem: caPem},
+		},
+		{
+			name: "fingerprint",
+			opt:  TlsOption{Fingerprints: []string{hex.EncodeToString(sum[:])}},
+		},
+		{
+			name:    "fingerprint mismatch",
+			opt:     TlsOption{Fingerprints: []string{hex.EncodeToString(make([]byte, sha256.Size))}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := tt.opt.TlsConfig()
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+			
+			client := http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: c,
+				},
+			}
+			
+			resp, err := client.Get(srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err:%v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+// TestTlsOptionPinLeaf 指纹只和叶子证书比较，中间人附带被固定的公开证书也不能通过
+func TestTlsOptionPinLeaf(t *testing.T) {
+	pinned := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	pinned.Close()
+	pinnedRaw := pinned.Certificate().Raw
+	
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mitm"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+	}
+	leafRaw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{
+			{
+				Certificate: [][]byte{leafRaw, pinnedRaw},
+				PrivateKey:  key,
+			},
+		},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	
+	tests := []struct {
+		name    string
+		pin     []byte
+		wantErr bool
+	}{
+		{
+			name:    "pinned cert after other leaf",
+			pin:     pinnedRaw,
+			wantErr: true,
+		},
+		{
+			name: "leaf",
+			pin:  leafRaw,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := sha256.Sum256(tt.pin)
+			c, err := TlsOption{Fingerprints: []string{hex.EncodeToString(sum[:])}}.TlsConfig()
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+			
+			client := http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: c,
+				},
+			}
+			
+			resp, err := client.Get(srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err:%v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestSkipCertVerify(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{
+			args: "trojan://pass@trojan.example.com:443?sni=trojan.example.com#t",
+			want: "0",
+		},
+		{
+			args: "trojan://pass@trojan.example.com:443?allowInsecure=1#t",
+			want: "1",
+		},
+		{
+			args: "vless://047184b7-6da2-3d3f-ac27-6a1a8701daf8@vless.example.com:443?security=tls&insecure=true#v",
+			want: "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			p, err := ParseV2ray(tt.args)
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+			
+			got := utils.ToString(p.opt["skip-cert-verify"])
+			if got == "" {
+				got = "0"
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseClashNodeTls 后端不支持的证书指纹、CA 不能被静默忽略
+func TestParseClashNodeTls(t *testing.T) {
+	tests := []struct {
+		m       map[string]any
+		wantErr bool
+	}{
+		{
+			m: map[string]any{"name": "a", "type": "trojan", "server": "a.example.com", "port": 443, "password": "pass"},
+		},
+		{
+			m:       map[string]any{"name": "b", "type": "trojan", "server": "a.example.com", "port": 443, "password": "pass", "fingerprint": "ab:cd"},
+			wantErr: true,
+		},
+		{
+			m:       map[string]any{"name": "c", "type": "vmess", "server": "a.example.com", "port": 443, "uuid": "047184b7-6da2-3d3f-ac27-6a1a8701daf8", "alterId": 0, "cipher": "auto", "tls": true, "ca": "/etc/ca.pem"},
+			wantErr: true,
+		},
+		{
+			m: map[string]any{"name": "d", "type": "hysteria", "server": "a.example.com", "port": 443, "auth_str": "pass", "up": "10", "down": "50", "ca_str": "pem"},
+		},
+	}
+	for _, tt := range tests {
+		err := checkNodeTls(tt.m)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: err:%v, wantErr %v", tt.m["name"], err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrUnsupportedFeature) {
+			t.Errorf("%v: err:%v", tt.m["name"], err)
+		}
+		
+		if tt.wantErr {
+			if _, err = ParseClash(tt.m); err == nil {
+				t.Errorf("%v: parse should fail", tt.m["name"])
+			}
+		}
+	}
+}
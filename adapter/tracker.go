@@ -16,8 +16,8 @@ type Tracker interface {
 }
 
 type tracker struct {
-	conn     constant.Conn      `json:"-"`
-	metadata *constant.Metadata `json:"metadata"`
+	conn     constant.Conn
+	metadata *constant.Metadata
 
 	tracker Tracker
 	t       Tracker
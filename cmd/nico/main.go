@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub"
+)
+
+const usage = `usage:
+  nico config validate [file]    校验配置文件，默认为 nico.yaml
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) < 2 || args[0] != "config" || args[1] != "validate" {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	path := "nico.yaml"
+	if len(args) > 2 {
+		path = args[2]
+	}
+
+	_, err := hub.LoadConfig(path)
+	if err != nil {
+		var ve config.ValidationError
+		if errors.As(err, &ve) {
+			for _, fe := range ve {
+				_, _ = fmt.Fprintf(stderr, "%s: %s\n", path, fe)
+			}
+		} else {
+			_, _ = fmt.Fprintf(stderr, "%s: %v\n", path, err)
+		}
+		return 1
+	}
+
+	_, _ = fmt.Fprintf(stdout, "%s: ok\n", path)
+	return 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		yaml   string
+		code   int
+		output []string
+	}{
+		{
+			name:   "ok",
+			yaml:   "inbounds: [{listen: ':7890'}]",
+			code:   0,
+			output: []string{"ok"},
+		},
+		{
+			name: "invalid",
+			yaml: "inbounds: [{listen: '7890'}]\nselector: {strategy: fastest}\nhealth_check: {interval: -1s}",
+			code: 1,
+			output: []string{
+				"selector.strategy",
+				"inbounds[0].listen",
+				"health_check.interval",
+			},
+		},
+		{
+			name:   "unknown field",
+			yaml:   "inbound: [{listen: ':7890'}]",
+			code:   1,
+			output: []string{"field inbound not found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".yaml")
+			err := os.WriteFile(path, []byte(tt.yaml), 0644)
+			if err != nil {
+				t.Fatalf("err:%v", err)
+			}
+
+			var stdout, stderr bytes.Buffer
+			code := run([]string{"config", "validate", path}, &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("run() = %d, want %d, stderr:%s", code, tt.code, stderr.String())
+			}
+
+			out := stdout.String() + stderr.String()
+			for _, want := range tt.output {
+				if !strings.Contains(out, want) {
+					t.Errorf("output %q should contain %q", out, want)
+				}
+			}
+		})
+	}
+
+	if code := run([]string{"serve"}, &bytes.Buffer{}, &bytes.Buffer{}); code != 2 {
+		t.Errorf("unknown command got %d, want 2", code)
+	}
+}
@@ -1,13 +1,13 @@
 package config
 
 import (
-	"os"
+	"context"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 	
 	"github.com/darabuchi/log"
-	"github.com/darabuchi/utils"
-	"github.com/spf13/viper"
 	"go.uber.org/atomic"
 )
 
@@ -16,84 +16,198 @@ const defaultConfigName = "nico.yaml"
 var (
 	lock sync.RWMutex
 	
-	c          = viper.New()
 	configPath = defaultConfigName
 	
+	// c nico.yaml，主要由用户维护
+	c = newFile(configPath)
+	// state 程序维护的状态，如学习到的规则，与 nico.yaml 分开保存，避免改写用户的配置
+	state = newFile(StatePath(configPath))
+	
 	changed = atomic.NewBool(false)
 )
 
 func init() {
 	SetConfigPath(configPath)
-	go func(sign chan os.Signal) {
-		syncTicker := time.NewTicker(time.Minute)
-		defer syncTicker.Stop()
+}
+
+var (
+	syncLock   sync.Mutex
+	syncRefs   int
+	syncCancel context.CancelFunc
+)
+
+// Start 定时把修改写回配置文件，所有调用方的 ctx 都取消后停止，停止前会再写一次
+func Start(ctx context.Context) {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	
+	syncRefs++
+	if syncRefs == 1 {
+		var syncCtx context.Context
+		syncCtx, syncCancel = context.WithCancel(context.Background())
+		go runSync(syncCtx)
+	}
+	
+	go func() {
+		<-ctx.Done()
 		
-		for {
-			select {
-			case <-syncTicker.C:
-				if changed.Load() {
-					Sync()
-				}
-			case <-sign:
-				Sync()
-				return
-			}
+		syncLock.Lock()
+		defer syncLock.Unlock()
+		
+		syncRefs--
+		if syncRefs == 0 {
+			syncCancel()
+			syncCancel = nil
+		}
+	}()
+}
+
+func runSync(ctx context.Context) {
+	syncTicker := time.NewTicker(time.Minute)
+	defer syncTicker.Stop()
+	
+	for {
+		select {
+		case <-syncTicker.C:
+			Flush()
+		case <-ctx.Done():
+			Flush()
+			return
 		}
-	}(utils.GetExitSign())
+	}
 }
 
+// Flush 有修改时写回配置文件
+func Flush() {
+	if changed.Load() {
+		Sync()
+	}
+}
+
+// Get 读取 nico.yaml 中的值，key 以 . 分隔层级
 func Get(key string) any {
 	lock.RLock()
 	defer lock.RUnlock()
 	
-	return c.Get(key)
+	node := c.get(key)
+	if node == nil {
+		return nil
+	}
+	
+	var v any
+	err := node.Decode(&v)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil
+	}
+	
+	return v
 }
 
+// Set 修改 nico.yaml 中的值，只替换对应的节点，由 Flush 写回
 func Set(key string, value any) {
 	lock.Lock()
 	defer lock.Unlock()
 	
-	c.Set(key, value)
-	changed.Store(true)
+	ok, err := c.set(key, value)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return
+	}
+	if ok {
+		changed.Store(true)
+	}
+}
+
+// Unset 删除 nico.yaml 中的 key
+func Unset(key string) {
+	lock.Lock()
+	defer lock.Unlock()
+	
+	if c.del(key) {
+		changed.Store(true)
+	}
+}
+
+// GetState 把状态文件中 key 对应的值解析到 out，不存在时不修改 out
+func GetState(key string, out any) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	
+	node := state.get(key)
+	if node == nil {
+		return nil
+	}
+	
+	return node.Decode(out)
+}
+
+// SetState 修改状态文件中的值，由 Flush 写回
+func SetState(key string, value any) {
+	lock.Lock()
+	defer lock.Unlock()
+	
+	ok, err := state.set(key, value)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return
+	}
+	if ok {
+		changed.Store(true)
+	}
 }
 
+// StatePath 配置文件对应的状态文件，如 nico.yaml 对应 nico.state.yaml
+func StatePath(filePath string) string {
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + ".state" + ext
+}
+
+// ConfigPath 当前使用的配置文件
+func ConfigPath() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	
+	return configPath
+}
+
+// SetConfigPath 切换配置文件，文件不存在时视为空配置，不会创建文件
 func SetConfigPath(filePath string) {
 	lock.Lock()
 	defer lock.Unlock()
 	
 	configPath = filePath
 	
-	c.SetConfigFile(configPath)
+	c = newFile(configPath)
+	err := c.load()
+	if err != nil {
+		log.Debugf("load config fail:%v", err)
+	}
 	
-	err := c.ReadInConfig()
+	state = newFile(StatePath(configPath))
+	err = state.load()
 	if err != nil {
-		switch e := err.(type) {
-		case viper.ConfigFileNotFoundError:
-			err = c.WriteConfigAs(configPath)
-			if err != nil {
-				log.Errorf("err:%v", err)
-			}
-			log.Debug("not found conf file, use default")
-		case *os.PathError:
-			err = c.WriteConfigAs(configPath)
-			if err != nil {
-				log.Errorf("err:%v", err)
-			}
-			log.Debugf("not find conf file in %s", e.Path)
-		default:
-			log.Debugf("load config fail:%v", err)
-		}
+		log.Debugf("load state fail:%v", err)
 	}
+	
+	changed.Store(false)
 }
 
+// Sync 把修改写回文件，没有修改的文件不会被改写
 func Sync() {
 	lock.Lock()
 	defer lock.Unlock()
 	
-	err := c.WriteConfigAs(configPath)
-	if err != nil {
-		log.Errorf("err:%v", err)
-	} else {
+	var failed bool
+	for _, f := range []*file{c, state} {
+		err := f.sync()
+		if err != nil {
+			log.Errorf("err:%v", err)
+			failed = true
+		}
+	}
+	
+	if !failed {
 		changed.Store(false)
 	}
 }
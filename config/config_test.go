@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "nico.yaml")
+	if content != "" {
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+
+	SetConfigPath(path)
+	t.Cleanup(func() {
+		SetConfigPath(defaultConfigName)
+	})
+
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	return string(buf)
+}
+
+func TestSetConfigPath(t *testing.T) {
+	path := useConfig(t, "")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("config file should not be created, err:%v", err)
+	}
+
+	// 没有修改时不写文件
+	Flush()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("config file should not be created, err:%v", err)
+	}
+
+	Set("health_check.interval", "1m")
+	Flush()
+	if got := readFile(t, path); got != "health_check:\n  interval: 1m\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSync(t *testing.T) {
+	path := useConfig(t, `# nico 配置
+selector:
+  strategy: first # 默认策略
+
+# 健康检查
+health_check:
+  interval: 5m
+`)
+
+	if Get("selector.strategy") != "first" {
+		t.Errorf("get got %v", Get("selector.strategy"))
+	}
+
+	Set("selector.strategy", "round_robin")
+	Sync()
+
+	got := readFile(t, path)
+	for _, want := range []string{"# nico 配置", "strategy: round_robin # 默认策略", "# 健康检查", "interval: 5m"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q should contain %q", got, want)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode changed to %v", info.Mode().Perm())
+	}
+
+	tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+	if len(tmp) > 0 {
+		t.Errorf("temp files left: %v", tmp)
+	}
+}
+
+func TestSync_ExternalModify(t *testing.T) {
+	path := useConfig(t, "selector:\n  strategy: first\n")
+
+	Set("health_check.interval", "1m")
+
+	// 写回之前用户改了文件
+	err := os.WriteFile(path, []byte("# 手动修改\nselector:\n  strategy: lowest_latency\n"), 0600)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	Sync()
+
+	got := readFile(t, path)
+	for _, want := range []string{"# 手动修改", "strategy: lowest_latency", "interval: 1m"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q should contain %q", got, want)
+		}
+	}
+}
+
+func TestSync_ExternalModifyOrder(t *testing.T) {
+	path := useConfig(t, "health_check:\n  interval: 5m\n")
+
+	Set("health_check.interval", "1m")
+	Unset("health_check")
+	Set("health_check.url", "https://example.com")
+	Set("limit.max_conns", 10)
+	Unset("limit.max_conns")
+
+	err := os.WriteFile(path, []byte("# 手动修改\nselector:\n  strategy: lowest_latency\nhealth_check:\n  interval: 10m\nlimit:\n  max_conns: 5\n"), 0600)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	Sync()
+
+	got := readFile(t, path)
+	for _, want := range []string{"# 手动修改", "strategy: lowest_latency", "url: https://example.com"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q should contain %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"interval", "max_conns"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("%q should not contain %q", got, unwanted)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	path := useConfig(t, "rule:\n  - {rule: Domain, payload: example.com, adapter: Proxy}\n")
+
+	SetState("rule", []map[string]string{{"rule": "Domain", "payload": "example.com", "adapter": "Proxy"}})
+	Unset("rule")
+	Sync()
+
+	if got := readFile(t, path); strings.Contains(got, "rule") {
+		t.Errorf("legacy rule should be removed from config, got %q", got)
+	}
+
+	SetConfigPath(path)
+
+	var rules []map[string]string
+	err := GetState("rule", &rules)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(rules) != 1 || rules[0]["payload"] != "example.com" {
+		t.Errorf("state got %v", rules)
+	}
+
+	if StatePath(path) != strings.TrimSuffix(path, ".yaml")+".state.yaml" {
+		t.Errorf("state path got %s", StatePath(path))
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix 环境变量覆盖配置时使用的前缀，如 NICO_HEALTH_CHECK_INTERVAL=1m 覆盖 health_check.interval
+const EnvPrefix = "NICO"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyEnv 用环境变量覆盖配置中的标量字段，字符串列表以逗号分隔
+// 列表中的结构体（如 inbounds）不支持覆盖
+func ApplyEnv(c *Config, lookup func(key string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, "", lookup)
+}
+
+func applyEnv(v reflect.Value, env, path string, lookup func(key string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, inline := yamlName(field)
+		if name == "-" {
+			continue
+		}
+
+		fieldEnv, fieldPath := env, path
+		if !inline {
+			fieldEnv = env + "_" + strings.ToUpper(name)
+			fieldPath = strings.TrimPrefix(path+"."+name, ".")
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			err := applyEnv(fv, fieldEnv, fieldPath, lookup)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !scalar(fv.Type()) {
+			continue
+		}
+
+		s, ok := lookup(fieldEnv)
+		if !ok {
+			continue
+		}
+
+		err := setValue(fv, s)
+		if err != nil {
+			return &FieldError{
+				Path:   fieldPath,
+				Reason: fmt.Sprintf("invalid env %s=%q: %v", fieldEnv, s, err),
+			}
+		}
+	}
+
+	return nil
+}
+
+func yamlName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("yaml")
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			return "", true
+		}
+	}
+	if parts[0] == "" {
+		return strings.ToLower(field.Name), false
+	}
+	return parts[0], false
+}
+
+func scalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
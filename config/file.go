@@ -0,0 +1,362 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/darabuchi/log"
+	"gopkg.in/yaml.v3"
+)
+
+// file 以 yaml.Node 保存的配置文件，修改时只替换对应的节点，其余内容和注释原样保留
+type file struct {
+	path string
+	doc  *yaml.Node
+
+	// sum 最后一次读取或写入时文件内容的 hash，文件不存在时为空，用于发现外部修改
+	sum []byte
+
+	// dirty 尚未写回的修改，按修改的顺序保存
+	dirty []edit
+}
+
+// edit 一次修改，node 为 nil 表示删除
+type edit struct {
+	key  string
+	node *yaml.Node
+}
+
+func newFile(path string) *file {
+	return &file{
+		path: path,
+		doc:  emptyDoc(),
+	}
+}
+
+func emptyDoc() *yaml.Node {
+	return &yaml.Node{
+		Kind: yaml.DocumentNode,
+		Content: []*yaml.Node{
+			{Kind: yaml.MappingNode, Tag: "!!map"},
+		},
+	}
+}
+
+// read 读取文件内容，文件不存在时返回 nil
+func (p *file) read() ([]byte, error) {
+	buf, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if buf == nil {
+		buf = []byte{}
+	}
+	return buf, nil
+}
+
+// load 从磁盘重新读取，文件不存在时视为空配置，不会创建文件
+func (p *file) load() error {
+	buf, err := p.read()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	doc, err := parseDoc(buf)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	p.doc = doc
+	p.sum = checksum(buf)
+
+	return nil
+}
+
+func parseDoc(buf []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return emptyDoc(), nil
+	}
+
+	root := doc.Content[0]
+	switch {
+	case root.Kind == yaml.MappingNode:
+	case root.Kind == yaml.ScalarNode && root.Tag == "!!null":
+		// 只有注释的文件
+		root.Kind, root.Tag, root.Value = yaml.MappingNode, "!!map", ""
+	default:
+		return nil, fmt.Errorf("root of config must be a mapping, got %s", root.Tag)
+	}
+
+	return &doc, nil
+}
+
+func checksum(buf []byte) []byte {
+	if buf == nil {
+		return nil
+	}
+	sum := sha256.Sum256(buf)
+	return sum[:]
+}
+
+func (p *file) root() *yaml.Node {
+	return p.doc.Content[0]
+}
+
+func (p *file) get(key string) *yaml.Node {
+	node := p.root()
+	for _, k := range strings.Split(key, ".") {
+		node = lookup(node, k)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// set 修改 key，值没有变化时返回 false
+func (p *file) set(key string, value any) (bool, error) {
+	var node yaml.Node
+	err := node.Encode(value)
+	if err != nil {
+		return false, err
+	}
+
+	if same(p.get(key), &node) {
+		return false, nil
+	}
+
+	p.addEdit(key, &node)
+	setNode(p.root(), key, &node)
+
+	return true, nil
+}
+
+// same 比较两个节点的内容，忽略注释，不存在的 key 与空值视为相同
+func same(old, node *yaml.Node) bool {
+	if old == nil {
+		switch node.Kind {
+		case yaml.SequenceNode, yaml.MappingNode:
+			return len(node.Content) == 0
+		case yaml.ScalarNode:
+			return node.Tag == "!!null"
+		default:
+			return false
+		}
+	}
+
+	var a, b any
+	if old.Decode(&a) != nil || node.Decode(&b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// del 删除 key，不存在时返回 false
+func (p *file) del(key string) bool {
+	if !delNode(p.root(), key) {
+		return false
+	}
+	p.addEdit(key, nil)
+	return true
+}
+
+// addEdit 记录一次修改，同一个 key 及其子 key 之前的修改会被覆盖，不再保留
+func (p *file) addEdit(key string, node *yaml.Node) {
+	dirty := p.dirty[:0]
+	for _, e := range p.dirty {
+		if e.key == key || strings.HasPrefix(e.key, key+".") {
+			continue
+		}
+		dirty = append(dirty, e)
+	}
+	p.dirty = append(dirty, edit{key: key, node: node})
+}
+
+// sync 把修改写回文件。写入前若发现文件已被外部修改，先读取新的内容，
+// 再把尚未写回的修改应用上去，避免覆盖掉外部的修改
+func (p *file) sync() error {
+	if len(p.dirty) == 0 {
+		return nil
+	}
+
+	buf, err := p.read()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	if !bytes.Equal(checksum(buf), p.sum) {
+		log.Warnf("%s was modified externally, merge %d pending changes", p.path, len(p.dirty))
+
+		doc, err := parseDoc(buf)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return err
+		}
+
+		// 按修改的顺序重放，先删父 key 再设子 key 与先设后删的结果不同
+		for _, e := range p.dirty {
+			if e.node == nil {
+				delNode(doc.Content[0], e.key)
+			} else {
+				setNode(doc.Content[0], e.key, e.node)
+			}
+		}
+
+		p.doc = doc
+	}
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	err = enc.Encode(p.doc)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+	err = enc.Close()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	err = writeFile(p.path, b.Bytes())
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	p.sum = checksum(b.Bytes())
+	p.dirty = nil
+
+	return nil
+}
+
+func (p *file) bytes() ([]byte, error) {
+	return yaml.Marshal(p.doc)
+}
+
+func lookup(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setNode 设置 a.b.c 形式的 key，中间不存在的层级会自动创建，已有 key 的注释保留
+func setNode(mapping *yaml.Node, key string, value *yaml.Node) {
+	keys := strings.Split(key, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next := lookup(mapping, k)
+		if next == nil || next.Kind != yaml.MappingNode {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			putNode(mapping, k, next)
+		}
+		mapping = next
+	}
+	putNode(mapping, keys[len(keys)-1], value)
+}
+
+func putNode(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != key {
+			continue
+		}
+
+		old := mapping.Content[i+1]
+		if value.HeadComment == "" {
+			value.HeadComment = old.HeadComment
+		}
+		if value.LineComment == "" {
+			value.LineComment = old.LineComment
+		}
+		if value.FootComment == "" {
+			value.FootComment = old.FootComment
+		}
+		mapping.Content[i+1] = value
+		return
+	}
+
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		value,
+	)
+}
+
+func delNode(mapping *yaml.Node, key string) bool {
+	keys := strings.Split(key, ".")
+	for _, k := range keys[:len(keys)-1] {
+		mapping = lookup(mapping, k)
+		if mapping == nil || mapping.Kind != yaml.MappingNode {
+			return false
+		}
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == keys[len(keys)-1] {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// writeFile 先写临时文件再 rename，保证文件要么是旧内容要么是新内容，已有文件的权限保持不变
+func writeFile(path string, buf []byte) error {
+	perm := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/hub/selector"
+	"gopkg.in/yaml.v3"
+)
+
+// Config nico.yaml 的完整结构
+type Config struct {
+	// StateFile 节点状态的保存位置，为空时不保存
+	StateFile string `yaml:"state_file,omitempty"`
+
+	// Tls 只作用于 nico 自身的探测请求（健康检查、测速、出口 ip），不影响节点的 tls 连接
+	Tls      adapter.TlsOption   `yaml:"tls,omitempty"`
+	Geo      Geo                 `yaml:"geo,omitempty"`
+	Selector selector.Config     `yaml:"selector,omitempty"`
+	Rank     adapter.ScoreWeight `yaml:"rank,omitempty"`
+
+	// Rule 旧版本保存学习到的规则的位置，现在保存在状态文件中，只用于迁移
+	Rule  []adapter.RuleInfo `yaml:"rule,omitempty"`
+	Learn Learn              `yaml:"learn,omitempty"`
+
+	Inbounds      []Inbound          `yaml:"inbounds,omitempty"`
+	Nodes         []any              `yaml:"nodes,omitempty"`
+	Subscriptions []Subscription     `yaml:"subscriptions,omitempty"`
+	Groups        []Group            `yaml:"groups,omitempty"`
+	Rules         []adapter.RuleInfo `yaml:"rules,omitempty"`
+	DNS           DNS                `yaml:"dns,omitempty"`
+	HealthCheck   HealthCheck        `yaml:"health_check,omitempty"`
+	Limit         Limit              `yaml:"limit,omitempty"`
+	Failover      Failover           `yaml:"failover,omitempty"`
+	Breaker       Breaker            `yaml:"breaker,omitempty"`
+}
+
+type Geo struct {
+	// Mmdb GeoLite2/GeoIP2 City 数据库，为空时不补全地区信息
+	Mmdb    string `yaml:"mmdb,omitempty"`
+	EchoUrl string `yaml:"echo_url,omitempty"`
+}
+
+// Learn 直连失败后自动学习到的规则
+type Learn struct {
+	// TTL 超过这么久没有命中的规则会被移除，为 0 时不过期
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// Max 最多保留的规则数，超出时移除最久没有命中的，为 0 时不限制
+	Max int `yaml:"max,omitempty"`
+}
+
+type Inbound struct {
+	// Type 目前只支持 mixed（http + socks）
+	Type   string `yaml:"type,omitempty"`
+	Listen string `yaml:"listen"`
+}
+
+type Subscription struct {
+	Name string `yaml:"name,omitempty"`
+	Url  string `yaml:"url"`
+
+	// Interval 自动更新的间隔，为 0 时只在配置变化时更新
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type Group struct {
+	Name string `yaml:"name"`
+
+	// Filter 匹配节点名称的正则，为空时包含所有节点
+	Filter string `yaml:"filter,omitempty"`
+
+	selector.Config `yaml:",inline"`
+}
+
+type DNS struct {
+	// Nameservers 为空时使用系统的解析
+	Nameservers []string `yaml:"nameservers,omitempty"`
+	IPv6        bool     `yaml:"ipv6,omitempty"`
+}
+
+type HealthCheck struct {
+	Url      string        `yaml:"url,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// Limit 连接数和空闲时间的限制，为 0 时不限制
+type Limit struct {
+	MaxConns      int `yaml:"max_conns,omitempty"`
+	MaxConnsPerIP int `yaml:"max_conns_per_ip,omitempty"`
+
+	// IdleTimeout 两个方向都没有数据超过这么久时关闭连接
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+}
+
+// Failover 通过代理建立连接失败时依次尝试其他节点
+type Failover struct {
+	// Attempts 最多尝试的节点数，包括第一个
+	Attempts int `yaml:"attempts,omitempty"`
+	// Timeout 每个节点建立连接的超时时间
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Stagger 上一个节点超过这么久还没连上时，不等它失败就同时尝试下一个，为 0 时等失败后再尝试
+	Stagger time.Duration `yaml:"stagger,omitempty"`
+}
+
+// Breaker 根据实际连接的结果熔断节点：统计窗口内失败比例达到 Threshold 时暂停使用，
+// 经过 Cooldown 后放行一个连接试探，成功则恢复
+type Breaker struct {
+	// Window 统计失败比例的滑动窗口
+	Window time.Duration `yaml:"window,omitempty"`
+	// MinRequests 窗口内至少有这么多连接才判断
+	MinRequests int `yaml:"min_requests,omitempty"`
+	// Threshold 失败比例，0 表示不熔断
+	Threshold float64 `yaml:"threshold,omitempty"`
+	// Cooldown 熔断后多久开始试探
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+}
+
+// Default 未配置时使用的值
+func Default() *Config {
+	return &Config{
+		Geo: Geo{
+			EchoUrl: adapter.DefaultGeoEchoUrl,
+		},
+		Selector: selector.Config{
+			Strategy: selector.First,
+		},
+		Rank: adapter.DefaultScoreWeight,
+		Learn: Learn{
+			TTL: time.Hour * 24 * 7,
+			Max: 1000,
+		},
+		HealthCheck: HealthCheck{
+			Url:      "https://www.google.com",
+			Interval: time.Minute * 5,
+		},
+		Limit: Limit{
+			MaxConns:    4096,
+			IdleTimeout: time.Minute * 5,
+		},
+		Failover: Failover{
+			Attempts: 3,
+			Timeout:  time.Second * 5,
+			Stagger:  time.Millisecond * 300,
+		},
+		Breaker: Breaker{
+			Window:      time.Minute,
+			MinRequests: 5,
+			Threshold:   0.5,
+			Cooldown:    time.Second * 30,
+		},
+	}
+}
+
+// Parse 在默认值的基础上解析配置，再应用环境变量覆盖，最后校验
+// 未知的字段视为错误
+func Parse(buf []byte) (*Config, error) {
+	c := Default()
+
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	err := dec.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	err = ApplyEnv(c, os.LookupEnv)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Load 读取并校验配置文件
+func Load(filePath string) (*Config, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return Parse(buf)
+}
+
+// ValidateFile 即 nico config validate，返回配置文件中的所有错误
+func ValidateFile(filePath string) error {
+	_, err := Load(filePath)
+	return err
+}
+
+// Current 当前配置文件对应的结构，解析失败时返回默认值
+func Current() *Config {
+	lock.RLock()
+	buf, err := c.bytes()
+	lock.RUnlock()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return Default()
+	}
+
+	cfg, err := Parse(buf)
+	if err != nil {
+		log.Errorf("invalid config %s, use default:%v", configPath, err)
+		return Default()
+	}
+
+	return cfg
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(""))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if c.HealthCheck.Interval != time.Minute*5 || c.Selector.Strategy != "first" || c.Rank.Latency != 1 {
+		t.Errorf("defaults not applied: %+v", c)
+	}
+
+	c, err = Parse([]byte(`
+state_file: state.json
+selector:
+  strategy: lowest_latency
+  tolerance: 30ms
+health_check:
+  interval: 1m
+`))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if c.StateFile != "state.json" || c.Selector.Tolerance != time.Millisecond*30 || c.HealthCheck.Interval != time.Minute {
+		t.Errorf("parse got %+v", c)
+	}
+	// 未配置的字段保留默认值
+	if c.HealthCheck.Url != "https://www.google.com" {
+		t.Errorf("default health_check.url lost: %s", c.HealthCheck.Url)
+	}
+
+	if _, err = Parse([]byte("health_check: {intreval: 1m}")); err == nil {
+		t.Errorf("unknown field should fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, err := Parse([]byte(`
+selector: {strategy: fastest, top_n: -1}
+rank: {latency: -1}
+rule:
+  - {rule: ScrIp, payload: example.com, adapter: Proxy}
+inbounds:
+  - {type: tun, listen: ':7890'}
+  - {listen: ':7890'}
+subscriptions:
+  - {url: 'sub.example.com'}
+groups:
+  - {name: a, filter: '(', strategy: first}
+  - {name: a}
+rules:
+  - {rule: Domain, payload: example.com, adapter: proxy}
+dns:
+  nameservers: ['quic://dns.example.com']
+`))
+
+	var ve ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+
+	want := []string{
+		"selector.strategy",
+		"selector.top_n",
+		"rank.latency",
+		"rule[0].payload",
+		"inbounds[0].type",
+		"inbounds[1].listen",
+		"subscriptions[0].url",
+		"groups[0].filter",
+		"groups[1].name",
+		"rules[0].adapter",
+		"dns.nameservers[0]",
+	}
+	if len(ve) != len(want) {
+		t.Errorf("got %d errors, want %d: %v", len(ve), len(want), ve)
+		return
+	}
+	for i, fe := range ve {
+		if fe.Path != want[i] {
+			t.Errorf("error %d path = %s, want %s (%v)", i, fe.Path, want[i], fe)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"NICO_STATE_FILE":            "/var/lib/nico/state.json",
+		"NICO_TLS_SKIP_VERIFY":       "true",
+		"NICO_TLS_FINGERPRINTS":      "a, b",
+		"NICO_SELECTOR_STRATEGY":     "random_top_n",
+		"NICO_SELECTOR_TOP_N":        "5",
+		"NICO_RANK_SPEED":            "0.5",
+		"NICO_HEALTH_CHECK_INTERVAL": "30s",
+		"NICO_GROUPS":                "ignored",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	c := Default()
+	err := ApplyEnv(c, lookup)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if c.StateFile != "/var/lib/nico/state.json" ||
+		!c.Tls.SkipVerify ||
+		len(c.Tls.Fingerprints) != 2 || c.Tls.Fingerprints[1] != "b" ||
+		c.Selector.Strategy != "random_top_n" || c.Selector.TopN != 5 ||
+		c.Rank.Speed != 0.5 ||
+		c.HealthCheck.Interval != time.Second*30 {
+		t.Errorf("env not applied: %+v", c)
+	}
+
+	env["NICO_HEALTH_CHECK_INTERVAL"] = "soon"
+	var fe *FieldError
+	if err = ApplyEnv(Default(), lookup); !errors.As(err, &fe) || fe.Path != "health_check.interval" {
+		t.Errorf("invalid env got %v", err)
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nico.yaml")
+	err := os.WriteFile(path, []byte("inbounds: [{listen: '127.0.0.1:7890'}]"), 0644)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err = ValidateFile(path); err != nil {
+		t.Errorf("err:%v", err)
+	}
+
+	if err = ValidateFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("missing file should fail")
+	}
+}
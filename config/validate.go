@@ -0,0 +1,240 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/hub/selector"
+)
+
+// FieldError 某个字段的校验错误，Path 形如 groups[0].strategy
+type FieldError struct {
+	Path   string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Reason
+}
+
+// ValidationError 一次校验中发现的所有错误，按字段在配置中的顺序排列
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	var b []string
+	for _, fe := range e {
+		b = append(b, fe.Error())
+	}
+	return strings.Join(b, "; ")
+}
+
+type validator struct {
+	errs ValidationError
+}
+
+func (v *validator) add(path, format string, args ...any) {
+	v.errs = append(v.errs, &FieldError{
+		Path:   path,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) httpUrl(path, s string) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(path, "invalid url %q", s)
+	}
+}
+
+// Validate 检查配置的结构和取值，不会访问网络
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if _, err := c.Tls.TlsConfig(); err != nil {
+		v.add("tls", "%v", err)
+	}
+
+	if c.Geo.EchoUrl != "" {
+		v.httpUrl("geo.echo_url", c.Geo.EchoUrl)
+	}
+
+	validateSelector(v, "selector", c.Selector)
+
+	if c.Rank.Latency < 0 {
+		v.add("rank.latency", "must not be negative")
+	}
+	if c.Rank.Speed < 0 {
+		v.add("rank.speed", "must not be negative")
+	}
+
+	for i, r := range c.Rule {
+		validateRule(v, fmt.Sprintf("rule[%d]", i), r)
+	}
+
+	if c.Learn.TTL < 0 {
+		v.add("learn.ttl", "must not be negative")
+	}
+	if c.Learn.Max < 0 {
+		v.add("learn.max", "must not be negative")
+	}
+
+	listen := map[string]bool{}
+	for i, in := range c.Inbounds {
+		path := fmt.Sprintf("inbounds[%d]", i)
+		if in.Type != "" && in.Type != "mixed" {
+			v.add(path+".type", "unsupported type %q", in.Type)
+		}
+		if _, _, err := net.SplitHostPort(in.Listen); err != nil {
+			v.add(path+".listen", "%v", err)
+		} else if listen[in.Listen] {
+			v.add(path+".listen", "duplicate address %s", in.Listen)
+		}
+		listen[in.Listen] = true
+	}
+
+	for i, n := range c.Nodes {
+		switch n.(type) {
+		case string, map[string]any:
+		default:
+			v.add(fmt.Sprintf("nodes[%d]", i), "unsupported node %T", n)
+		}
+	}
+
+	subs := map[string]bool{}
+	for i, sub := range c.Subscriptions {
+		path := fmt.Sprintf("subscriptions[%d]", i)
+		v.httpUrl(path+".url", sub.Url)
+		if subs[sub.Url] {
+			v.add(path+".url", "duplicate url %s", sub.Url)
+		}
+		subs[sub.Url] = true
+		if sub.Interval < 0 {
+			v.add(path+".interval", "must not be negative")
+		}
+	}
+
+	groups := map[string]bool{}
+	for i, g := range c.Groups {
+		path := fmt.Sprintf("groups[%d]", i)
+		if g.Name == "" {
+			v.add(path+".name", "missing name")
+		} else if groups[g.Name] {
+			v.add(path+".name", "duplicate group %s", g.Name)
+		}
+		groups[g.Name] = true
+		if _, err := regexp.Compile(g.Filter); err != nil {
+			v.add(path+".filter", "%v", err)
+		}
+		validateSelector(v, path, g.Config)
+	}
+
+	for i, r := range c.Rules {
+		validateRule(v, fmt.Sprintf("rules[%d]", i), r)
+	}
+
+	for i, ns := range c.DNS.Nameservers {
+		path := fmt.Sprintf("dns.nameservers[%d]", i)
+		s := ns
+		if !strings.Contains(s, "://") {
+			s = "udp://" + s
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			v.add(path, "%v", err)
+			continue
+		}
+		switch u.Scheme {
+		case "udp", "tcp", "tls", "https":
+		default:
+			v.add(path, "unsupported scheme %q", u.Scheme)
+			continue
+		}
+		if u.Host == "" {
+			v.add(path, "missing host")
+		}
+	}
+
+	if c.HealthCheck.Url != "" {
+		v.httpUrl("health_check.url", c.HealthCheck.Url)
+	}
+	if c.HealthCheck.Interval < 0 {
+		v.add("health_check.interval", "must not be negative")
+	}
+
+	if c.Limit.MaxConns < 0 {
+		v.add("limit.max_conns", "must not be negative")
+	}
+	if c.Limit.MaxConnsPerIP < 0 {
+		v.add("limit.max_conns_per_ip", "must not be negative")
+	}
+	if c.Limit.IdleTimeout < 0 {
+		v.add("limit.idle_timeout", "must not be negative")
+	}
+
+	if c.Failover.Attempts < 0 {
+		v.add("failover.attempts", "must not be negative")
+	}
+	if c.Failover.Timeout < 0 {
+		v.add("failover.timeout", "must not be negative")
+	}
+	if c.Failover.Stagger < 0 {
+		v.add("failover.stagger", "must not be negative")
+	}
+
+	if c.Breaker.Window < 0 {
+		v.add("breaker.window", "must not be negative")
+	}
+	if c.Breaker.MinRequests < 0 {
+		v.add("breaker.min_requests", "must not be negative")
+	}
+	if c.Breaker.Threshold < 0 || c.Breaker.Threshold > 1 {
+		v.add("breaker.threshold", "must be between 0 and 1")
+	}
+	if c.Breaker.Cooldown < 0 {
+		v.add("breaker.cooldown", "must not be negative")
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+
+	return nil
+}
+
+func validateSelector(v *validator, path string, c selector.Config) {
+	if _, err := selector.New(c); err != nil {
+		v.add(path+".strategy", "%v", err)
+	}
+	if c.Tolerance < 0 {
+		v.add(path+".tolerance", "must not be negative")
+	}
+	if c.TopN < 0 {
+		v.add(path+".top_n", "must not be negative")
+	}
+}
+
+func validateRule(v *validator, path string, r adapter.RuleInfo) {
+	if adapter.ParseAdapterType(r.Adapter) < 0 {
+		v.add(path+".adapter", "unknown adapter %q", r.Adapter)
+	}
+
+	if r.Payload == "" {
+		v.add(path+".payload", "missing payload")
+		return
+	}
+
+	switch r.Rule {
+	case adapter.Domain.String():
+	case adapter.ScrIp.String(), adapter.DstIp.String():
+		if _, err := netip.ParseAddr(r.Payload); err != nil {
+			v.add(path+".payload", "%s is not ip", r.Payload)
+		}
+	default:
+		v.add(path+".rule", "unknown rule type %q", r.Rule)
+	}
+}
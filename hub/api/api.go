@@ -0,0 +1,329 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/hub/event"
+	"github.com/darabuchi/nico/hub/executor"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	pingInterval = time.Second * 30
+	writeTimeout = time.Second * 10
+)
+
+// ErrSecretRequired 监听非本机地址时必须设置 secret
+var ErrSecretRequired = errors.New("secret is required when listening on non-loopback address")
+
+// Server 控制接口
+//
+//	GET    /events                  websocket 事件流，?types=node_added,conn_opened 过滤类型，?buffer= 缓冲大小
+//	GET    /nodes                   所有节点
+//	GET    /rules                   所有生效的规则
+//	GET    /rules/learned           学习到的规则
+//	POST   /rules/learned/promote   把学习到的规则转为常驻规则，body 为规则列表
+//	DELETE /rules/learned           删除学习到的规则，body 为空时删除全部
+type Server struct {
+	ex     *executor.Executor
+	secret string
+	mux    *http.ServeMux
+
+	// allowOrigins 允许跨域连接 websocket 的来源，* 表示全部
+	allowOrigins map[string]bool
+
+	upgrader websocket.Upgrader
+}
+
+type Option func(p *Server)
+
+// WithAllowOrigins 允许这些来源的网页连接 websocket，如 http://localhost:8080，默认只允许同源或没有 Origin 的请求
+func WithAllowOrigins(origins ...string) Option {
+	return func(p *Server) {
+		for _, origin := range origins {
+			p.allowOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+}
+
+// New secret 不为空时需要带上 Authorization: Bearer <secret>，websocket 也可以用 ?token=
+// secret 为空时只能监听本机地址
+func New(ex *executor.Executor, secret string, opts ...Option) *Server {
+	p := &Server{
+		ex:           ex,
+		secret:       secret,
+		mux:          http.NewServeMux(),
+		allowOrigins: map[string]bool{},
+	}
+	p.upgrader.CheckOrigin = p.checkOrigin
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.mux.HandleFunc("/events", p.events)
+	p.mux.HandleFunc("/nodes", p.nodes)
+	p.mux.HandleFunc("/rules", p.rules)
+	p.mux.HandleFunc("/rules/learned", p.learned)
+	p.mux.HandleFunc("/rules/learned/promote", p.promote)
+
+	return p
+}
+
+func (p *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	p.mux.ServeHTTP(w, r)
+}
+
+func (p *Server) authorized(r *http.Request) bool {
+	if p.secret == "" {
+		return loopbackHost(r)
+	}
+
+	secret := []byte(p.secret)
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), secret) == 1 {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), secret) == 1
+}
+
+// checkOrigin 浏览器发起的 websocket 只允许同源或 WithAllowOrigins 中的来源，避免任意网页读取事件流
+func (p *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if p.allowOrigins["*"] || p.allowOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// ListenAndServe 阻塞直到 ctx 取消
+func (p *Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	return p.Serve(ctx, ln)
+}
+
+// Serve 返回时关闭 ln，secret 为空且 ln 不是本机地址时返回 ErrSecretRequired
+func (p *Server) Serve(ctx context.Context, ln net.Listener) error {
+	if p.secret == "" && !isLoopback(ln.Addr()) {
+		_ = ln.Close()
+		log.Errorf("listen on %s: %v", ln.Addr(), ErrSecretRequired)
+		return ErrSecretRequired
+	}
+
+	srv := &http.Server{
+		Handler: p,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	err := srv.Serve(ln)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	return nil
+}
+
+// isLoopback 0.0.0.0 等监听所有网卡的地址不是本机地址，unix socket 视为本机
+func isLoopback(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	default:
+		return false
+	}
+}
+
+// loopbackHost 没有 secret 时 Host 必须是本机名称或地址，避免网页通过 DNS rebinding 访问本机接口
+// unix socket 不经过浏览器，不检查
+func loopbackHost(r *http.Request) bool {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, ok := addr.(*net.UnixAddr); ok {
+			return true
+		}
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Errorf("err:%v", err)
+	}
+}
+
+func (p *Server) events(w http.ResponseWriter, r *http.Request) {
+	var types []event.Type
+	if s := r.URL.Query().Get("types"); s != "" {
+		for _, t := range strings.Split(s, ",") {
+			types = append(types, event.Type(strings.TrimSpace(t)))
+		}
+	}
+
+	size, _ := strconv.Atoi(r.URL.Query().Get("buffer"))
+
+	conn, err := p.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := p.ex.Events().Subscribe(size, types...)
+	defer sub.Close()
+
+	// 读取客户端的消息以处理 close 和 pong，客户端断开后结束
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e := <-sub.Events():
+			_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = conn.WriteJSON(e)
+			if err != nil {
+				log.Debugf("err:%v", err)
+				return
+			}
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+			if err != nil {
+				log.Debugf("err:%v", err)
+				return
+			}
+		case <-done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (p *Server) nodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodes := make([]*event.Node, 0)
+	p.ex.Nodes().Each(func(node adapter.AdapterProxy) {
+		nodes = append(nodes, event.NewNode(node))
+	})
+
+	writeJson(w, nodes)
+}
+
+func (p *Server) rules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJson(w, p.ex.AdapterRule().Export())
+}
+
+func (p *Server) learned(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, p.ex.AdapterRule().Learned())
+
+	case http.MethodDelete:
+		var infos []adapter.RuleInfo
+		if r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&infos)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(infos) == 0 {
+				writeJson(w, map[string]int{"count": 0})
+				return
+			}
+		}
+
+		ar := p.ex.AdapterRule()
+		n := ar.Purge(infos...)
+		ar.Sync()
+		writeJson(w, map[string]int{"count": n})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *Server) promote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var infos []adapter.RuleInfo
+	err := json.NewDecoder(r.Body).Decode(&infos)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ar := p.ex.AdapterRule()
+	n := ar.Promote(infos...)
+	ar.Sync()
+	writeJson(w, map[string]int{"count": n})
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub/event"
+	"github.com/darabuchi/nico/hub/executor"
+	"github.com/darabuchi/nico/hub/rule"
+	"github.com/gorilla/websocket"
+)
+
+func TestServer_Events(t *testing.T) {
+	ex := executor.NewExecutor()
+
+	srv := httptest.NewServer(New(ex, "secret"))
+	defer srv.Close()
+
+	wsUrl := "ws" + strings.TrimPrefix(srv.URL, "http") + "/events?types=rule_added,config_reloaded"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without token got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl+"&token=secret", nil)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer conn.Close()
+
+	// 等待订阅建立
+	for i := 0; ex.Events().Len() == 0; i++ {
+		if i > 100 {
+			t.Fatalf("subscription not ready")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	ex.Events().Publish(event.Event{Type: event.NodeAdded})
+	ex.Events().Publish(event.Event{Type: event.RuleAdded, Data: adapter.RuleInfo{Rule: "Domain", Payload: "a.com"}})
+	ex.Events().Publish(event.Event{Type: event.ConfigReloaded})
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, want := range []event.Type{event.RuleAdded, event.ConfigReloaded} {
+		var e struct {
+			Type event.Type       `json:"type"`
+			Data adapter.RuleInfo `json:"data"`
+		}
+		err = conn.ReadJSON(&e)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		if e.Type != want {
+			t.Errorf("got %s, want %s", e.Type, want)
+		}
+		if want == event.RuleAdded && e.Data.Payload != "a.com" {
+			t.Errorf("data got %+v", e.Data)
+		}
+	}
+
+	// 客户端断开后取消订阅
+	conn.Close()
+	for i := 0; ex.Events().Len() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("subscription not closed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestServer_Learned(t *testing.T) {
+	// promote 和 purge 会写状态文件
+	config.SetConfigPath(filepath.Join(t.TempDir(), "nico.yaml"))
+	defer config.SetConfigPath("nico.yaml")
+
+	ex := executor.NewExecutor()
+	ar := rule.NewAdapterRule()
+	ex.SetAdapterRule(ar)
+
+	for _, host := range []string{"a.com", "b.com", "c.com"} {
+		r, err := rule.NewDomain(host, adapter.Proxy)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		ar.Learn(r)
+	}
+
+	srv := httptest.NewServer(New(ex, ""))
+	defer srv.Close()
+
+	do := func(method, path, body string, out any) {
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s got %s", method, path, resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+
+	var learned []rule.LearnedRule
+	do(http.MethodGet, "/rules/learned", "", &learned)
+	if len(learned) != 3 || !learned[0].Learned {
+		t.Errorf("learned got %+v", learned)
+	}
+
+	var count map[string]int
+	do(http.MethodPost, "/rules/learned/promote", `[{"rule":"Domain","payload":"a.com"}]`, &count)
+	if count["count"] != 1 {
+		t.Errorf("promote got %v", count)
+	}
+
+	do(http.MethodDelete, "/rules/learned", `[{"rule":"Domain","payload":"b.com"}]`, &count)
+	if count["count"] != 1 {
+		t.Errorf("purge got %v", count)
+	}
+
+	var rules []adapter.RuleInfo
+	do(http.MethodGet, "/rules", "", &rules)
+	if len(rules) != 2 || rules[0].Payload != "a.com" || rules[0].Learned || !rules[1].Learned {
+		t.Errorf("rules got %+v", rules)
+	}
+}
+
+func TestServer_Origin(t *testing.T) {
+	ex := executor.NewExecutor()
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		origin  func(srv *httptest.Server) string
+		wantErr bool
+	}{
+		{
+			name:   "no origin",
+			origin: func(srv *httptest.Server) string { return "" },
+		},
+		{
+			name:   "same origin",
+			origin: func(srv *httptest.Server) string { return srv.URL },
+		},
+		{
+			name:    "cross origin",
+			origin:  func(srv *httptest.Server) string { return "http://evil.example.com" },
+			wantErr: true,
+		},
+		{
+			name:   "allowed origin",
+			opts:   []Option{WithAllowOrigins("http://dashboard.example.com/")},
+			origin: func(srv *httptest.Server) string { return "http://dashboard.example.com" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(New(ex, "secret", tt.opts...))
+			defer srv.Close()
+
+			header := http.Header{}
+			if origin := tt.origin(srv); origin != "" {
+				header.Set("Origin", origin)
+			}
+			header.Set("Authorization", "Bearer secret")
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/events", header)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err:%v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				conn.Close()
+			}
+		})
+	}
+}
+
+func TestServer_Authorized(t *testing.T) {
+	srv := httptest.NewServer(New(executor.NewExecutor(), "secret"))
+	defer srv.Close()
+
+	tests := []struct {
+		auth, token string
+		want        int
+	}{
+		{auth: "Bearer secret", want: http.StatusOK},
+		{token: "secret", want: http.StatusOK},
+		{auth: "secret", want: http.StatusUnauthorized},
+		{auth: "Bearer secre", want: http.StatusUnauthorized},
+		{token: "secrets", want: http.StatusUnauthorized},
+		{want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/nodes?token="+tt.token, nil)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("auth %q token %q got %d, want %d", tt.auth, tt.token, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+// TestServer_RequireSecret 没有 secret 时只能监听本机地址
+func TestServer_RequireSecret(t *testing.T) {
+	ex := executor.NewExecutor()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := New(ex, "").ListenAndServe(ctx, "0.0.0.0:0")
+	if !errors.Is(err, ErrSecretRequired) {
+		t.Errorf("got err %v, want %v", err, ErrSecretRequired)
+	}
+
+	done := make(chan error, 2)
+	go func() {
+		done <- New(ex, "").ListenAndServe(ctx, "127.0.0.1:0")
+	}()
+	go func() {
+		done <- New(ex, "secret").ListenAndServe(ctx, "0.0.0.0:0")
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	cancel()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-done:
+			if err != nil {
+				t.Errorf("err:%v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("server not stopped")
+		}
+	}
+}
+
+// TestServer_LoopbackHost 没有 secret 时拒绝 Host 不是本机的请求
+func TestServer_LoopbackHost(t *testing.T) {
+	srv := httptest.NewServer(New(executor.NewExecutor(), ""))
+	defer srv.Close()
+
+	tests := []struct {
+		host string
+		want int
+	}{
+		{host: "", want: http.StatusOK},
+		{host: "localhost:9090", want: http.StatusOK},
+		{host: "127.0.0.2", want: http.StatusOK},
+		{host: "[::1]:9090", want: http.StatusOK},
+		{host: "app.localhost", want: http.StatusOK},
+		{host: "evil.example.com", want: http.StatusUnauthorized},
+		{host: "evil.example.com:9090", want: http.StatusUnauthorized},
+		{host: "10.0.0.1", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/nodes", nil)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("host %q got %d, want %d", tt.host, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -1,5 +1,107 @@
 package hub
 
-func RefreshConfig(filePath string) {
+import (
+	"fmt"
+	"strings"
 
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+)
+
+// Config 声明式的完整配置，RefreshConfig 会让运行中的 Executor 与之保持一致
+type (
+	Config       = config.Config
+	Inbound      = config.Inbound
+	Subscription = config.Subscription
+	Group        = config.Group
+	DNS          = config.DNS
+	HealthCheck  = config.HealthCheck
+)
+
+// LoadConfig 读取并校验配置文件
+func LoadConfig(filePath string) (*Config, error) {
+	c, err := config.Load(filePath)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	err = validate(c)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func ParseConfig(buf []byte) (*Config, error) {
+	c, err := config.Parse(buf)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	err = validate(c)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// validate 在结构校验之外，检查节点能否解析以及 dns 能否启动
+func validate(c *Config) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	var errs config.ValidationError
+	for i, n := range c.Nodes {
+		_, err := parseNode(n)
+		if err != nil {
+			errs = append(errs, &config.FieldError{
+				Path:   fmt.Sprintf("nodes[%d]", i),
+				Reason: err.Error(),
+			})
+		}
+	}
+
+	servers, err := parseNameservers(c.DNS.Nameservers)
+	if err != nil {
+		errs = append(errs, &config.FieldError{
+			Path:   "dns.nameservers",
+			Reason: err.Error(),
+		})
+	} else if _, err = bootstrapNameservers(servers); err != nil {
+		errs = append(errs, &config.FieldError{
+			Path:   "dns.nameservers",
+			Reason: err.Error(),
+		})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// parseNode 节点可以是 v2ray 链接、Surge/QuanX 单行配置或 clash 格式
+func parseNode(n any) (*adapter.ProxyAdapter, error) {
+	switch x := n.(type) {
+	case string:
+		x = strings.TrimSpace(x)
+		if strings.Contains(x, "://") {
+			return adapter.ParseV2ray(x)
+		}
+		return adapter.ParseProxyLine(x)
+	case map[string]any:
+		return adapter.ParseClash(x)
+	default:
+		return nil, fmt.Errorf("unsupported node %T", n)
+	}
 }
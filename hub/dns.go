@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/resolver"
+	"github.com/Dreamacro/clash/dns"
+)
+
+// parseNameservers 支持 8.8.8.8、udp://、tcp://、tls:// 和 https:// 的写法
+func parseNameservers(servers []string) ([]dns.NameServer, error) {
+	var list []dns.NameServer
+	for i, server := range servers {
+		if !strings.Contains(server, "://") {
+			server = "udp://" + server
+		}
+
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %v", i, err)
+		}
+
+		ns := dns.NameServer{}
+		switch u.Scheme {
+		case "udp":
+			ns.Addr = withDefaultPort(u.Host, "53")
+		case "tcp":
+			ns.Net = "tcp"
+			ns.Addr = withDefaultPort(u.Host, "53")
+		case "tls":
+			ns.Net = "tcp-tls"
+			ns.Addr = withDefaultPort(u.Host, "853")
+		case "https":
+			ns.Net = "https"
+			ns.Addr = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+		default:
+			return nil, fmt.Errorf("[%d]: unsupported scheme %q", i, u.Scheme)
+		}
+
+		if u.Host == "" {
+			return nil, fmt.Errorf("[%d]: missing host", i)
+		}
+
+		list = append(list, ns)
+	}
+
+	return list, nil
+}
+
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// newResolver 按配置创建解析器，未配置时返回 nil 表示系统解析
+func newResolver(c DNS) (*dns.Resolver, error) {
+	servers, err := parseNameservers(c.Nameservers)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(servers) == 0 {
+		return nil, nil
+	}
+
+	bootstrap, err := bootstrapNameservers(servers)
+	if err != nil {
+		return nil, err
+	}
+
+	return dns.NewResolver(dns.Config{
+		Main:    servers,
+		Default: bootstrap,
+		IPv6:    c.IPv6,
+	}), nil
+}
+
+// applyDNS 替换全局的解析器，r 为空时恢复为系统解析
+func applyDNS(c DNS, r *dns.Resolver) {
+	resolver.DisableIPv6 = !c.IPv6
+
+	if r == nil {
+		resolver.DefaultResolver = nil
+		return
+	}
+	resolver.DefaultResolver = r
+}
+
+// bootstrapNameservers 用于解析 DoH/DoT 服务器自身的域名，只能使用 ip 形式的服务器
+func bootstrapNameservers(servers []dns.NameServer) ([]dns.NameServer, error) {
+	var list []dns.NameServer
+	for _, ns := range servers {
+		if ns.Net == "https" {
+			continue
+		}
+
+		host, _, err := net.SplitHostPort(ns.Addr)
+		if err != nil || net.ParseIP(host) == nil {
+			continue
+		}
+
+		list = append(list, ns)
+	}
+
+	if len(list) == 0 && len(servers) > 0 {
+		return nil, fmt.Errorf("need at least one udp/tcp nameserver with ip address")
+	}
+
+	return list, nil
+}
@@ -0,0 +1,207 @@
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/darabuchi/log"
+	"go.uber.org/atomic"
+)
+
+// DefaultBufferSize 订阅未指定缓冲大小时使用
+const DefaultBufferSize = 64
+
+// Bus 事件总线，发布不会阻塞，订阅方处理不过来时丢弃事件并计数，SubscribeUnbounded 和 On 不丢弃
+type Bus struct {
+	lock sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+type Subscription struct {
+	bus   *Bus
+	ch    chan Event
+	types map[Type]bool
+
+	dropped *atomic.Uint64
+	once    sync.Once
+
+	// 不限缓冲的订阅先放入 queue，由 pump 依次转发到 ch
+	unbounded bool
+	qlock     sync.Mutex
+	queue     []Event
+	notify    chan struct{}
+	done      chan struct{}
+}
+
+// Subscribe 订阅指定类型的事件，types 为空时订阅所有事件，size<=0 时使用 DefaultBufferSize
+func (b *Bus) Subscribe(size int, types ...Type) *Subscription {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+
+	s := newSubscription(b, make(chan Event, size), types)
+	b.add(s)
+
+	return s
+}
+
+// SubscribeUnbounded 与 Subscribe 相同，但缓冲没有上限，不会丢弃事件，订阅方处理慢时占用的内存会增长
+func (b *Bus) SubscribeUnbounded(types ...Type) *Subscription {
+	s := newSubscription(b, make(chan Event), types)
+	s.unbounded = true
+	s.notify = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	go s.pump()
+
+	b.add(s)
+
+	return s
+}
+
+func newSubscription(b *Bus, ch chan Event, types []Type) *Subscription {
+	s := &Subscription{
+		bus:     b,
+		ch:      ch,
+		dropped: atomic.NewUint64(0),
+	}
+	if len(types) > 0 {
+		s.types = map[Type]bool{}
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	return s
+}
+
+func (b *Bus) add(s *Subscription) {
+	b.lock.Lock()
+	b.subs[s] = struct{}{}
+	b.lock.Unlock()
+}
+
+// On 在单独的 goroutine 中依次处理事件，不会丢弃，返回取消订阅的函数
+func (b *Bus) On(fn func(e Event), types ...Type) func() {
+	s := b.SubscribeUnbounded(types...)
+	go func() {
+		for e := range s.Events() {
+			func() {
+				// utils.CachePanic 中的 recover 不是在 defer 的函数中直接调用，无法恢复
+				defer func() {
+					if err := recover(); err != nil {
+						log.Errorf("handle event %s panic:%v", e.Type, err)
+					}
+				}()
+				fn(e)
+			}()
+		}
+	}()
+	return s.Close
+}
+
+// Publish 发布事件，Time 为空时使用当前时间
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for s := range b.subs {
+		if s.types != nil && !s.types[e.Type] {
+			continue
+		}
+
+		if s.unbounded {
+			s.push(e)
+			continue
+		}
+
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Inc()
+		}
+	}
+}
+
+// Len 当前的订阅数
+func (b *Bus) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return len(b.subs)
+}
+
+// Events 订阅关闭后 channel 会被关闭
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped 缓冲已满而丢弃的事件数
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.lock.Lock()
+		defer s.bus.lock.Unlock()
+
+		delete(s.bus.subs, s)
+		// 不限缓冲的订阅由 pump 关闭 ch，还未转发的事件丢弃
+		if s.unbounded {
+			close(s.done)
+		} else {
+			close(s.ch)
+		}
+	})
+}
+
+func (s *Subscription) push(e Event) {
+	s.qlock.Lock()
+	s.queue = append(s.queue, e)
+	s.qlock.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) pump() {
+	defer close(s.ch)
+
+	for {
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+
+		for {
+			s.qlock.Lock()
+			if len(s.queue) == 0 {
+				s.queue = nil
+				s.qlock.Unlock()
+				break
+			}
+			e := s.queue[0]
+			s.queue[0] = Event{}
+			s.queue = s.queue[1:]
+			s.qlock.Unlock()
+
+			select {
+			case s.ch <- e:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
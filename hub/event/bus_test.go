@@ -0,0 +1,152 @@
+package event
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func recv(t *testing.T, s *Subscription) Event {
+	select {
+	case e, ok := <-s.Events():
+		if !ok {
+			t.Fatalf("subscription closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	return Event{}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+
+	all := bus.Subscribe(0)
+	nodes := bus.Subscribe(0, NodeAdded, NodeRemoved)
+
+	bus.Publish(Event{Type: NodeAdded, Data: &Node{Name: "a"}})
+	bus.Publish(Event{Type: ConnOpened, Data: &Conn{Id: "1"}})
+	bus.Publish(Event{Type: NodeRemoved, Data: &Node{Name: "a"}})
+
+	for _, want := range []Type{NodeAdded, ConnOpened, NodeRemoved} {
+		if e := recv(t, all); e.Type != want || e.Time.IsZero() {
+			t.Errorf("got %+v, want %s", e, want)
+		}
+	}
+	for _, want := range []Type{NodeAdded, NodeRemoved} {
+		if e := recv(t, nodes); e.Type != want {
+			t.Errorf("got %+v, want %s", e, want)
+		}
+	}
+
+	nodes.Close()
+	nodes.Close()
+	if _, ok := <-nodes.Events(); ok {
+		t.Errorf("events should be closed")
+	}
+	if bus.Len() != 1 {
+		t.Errorf("len got %d", bus.Len())
+	}
+
+	bus.Publish(Event{Type: NodeAdded})
+	recv(t, all)
+	all.Close()
+}
+
+func TestBus_Slow(t *testing.T) {
+	bus := NewBus()
+
+	slow := bus.Subscribe(2)
+	defer slow.Close()
+
+	fast := bus.Subscribe(100)
+	defer fast.Close()
+
+	// 订阅方不读取也不会阻塞发布
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			bus.Publish(Event{Type: RuleAdded})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("publish blocked")
+	}
+
+	if slow.Dropped() != 8 || len(slow.Events()) != 2 {
+		t.Errorf("slow dropped %d, buffered %d", slow.Dropped(), len(slow.Events()))
+	}
+	if fast.Dropped() != 0 || len(fast.Events()) != 10 {
+		t.Errorf("fast dropped %d, buffered %d", fast.Dropped(), len(fast.Events()))
+	}
+}
+
+func TestBus_On(t *testing.T) {
+	bus := NewBus()
+
+	var lock sync.Mutex
+	var got []string
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	for _, name := range []string{"a", "b"} {
+		name := name
+		bus.On(func(e Event) {
+			lock.Lock()
+			got = append(got, name+":"+string(e.Type))
+			lock.Unlock()
+			wg.Done()
+		}, ConfigReloaded)
+	}
+
+	cancel := bus.On(func(e Event) {
+		wg.Done()
+		panic("should not break the bus")
+	}, ConfigReloaded)
+
+	bus.Publish(Event{Type: NodeAdded})
+	bus.Publish(Event{Type: ConfigReloaded})
+	wg.Wait()
+
+	cancel()
+	if bus.Len() != 2 {
+		t.Errorf("len got %d", bus.Len())
+	}
+
+	if len(got) != 2 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestBus_Unbounded(t *testing.T) {
+	bus := NewBus()
+
+	s := bus.SubscribeUnbounded(NodeAdded)
+
+	for i := 0; i < DefaultBufferSize*4; i++ {
+		bus.Publish(Event{Type: NodeAdded, Data: i})
+		bus.Publish(Event{Type: ConnOpened})
+	}
+
+	// 订阅方不读取时缓冲继续增长，之后按顺序收到全部事件
+	for i := 0; i < DefaultBufferSize*4; i++ {
+		if e := recv(t, s); e.Data != i {
+			t.Fatalf("got %v, want %d", e.Data, i)
+		}
+	}
+	if s.Dropped() != 0 {
+		t.Errorf("dropped %d", s.Dropped())
+	}
+
+	bus.Publish(Event{Type: NodeAdded})
+	s.Close()
+	for range s.Events() {
+	}
+	if bus.Len() != 0 {
+		t.Errorf("len got %d", bus.Len())
+	}
+}
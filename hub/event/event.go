@@ -0,0 +1,98 @@
+package event
+
+import (
+	"time"
+
+	"github.com/darabuchi/nico/adapter"
+)
+
+type Type string
+
+const (
+	NodeAdded         Type = "node_added"
+	NodeRemoved       Type = "node_removed"
+	NodeAliveChanged  Type = "node_alive_changed"
+	NodeDelayChanged  Type = "node_delay_changed"
+	NodeDelayChecked  Type = "node_delay_checked"
+	NodeSpeedMeasured Type = "node_speed_measured"
+	// NodeEjected 实际连接失败过多被熔断，NodeRestored 熔断后试探成功
+	NodeEjected  Type = "node_ejected"
+	NodeRestored Type = "node_restored"
+
+	RuleAdded Type = "rule_added"
+
+	ConnOpened Type = "conn_opened"
+	ConnClosed Type = "conn_closed"
+
+	ConfigReloaded Type = "config_reloaded"
+)
+
+// Event Data 的类型由 Type 决定：节点事件为 *Node，NodeAliveChanged 和 NodeDelayChanged 为 *NodeChange，熔断事件为 *Circuit，
+// RuleAdded 为 adapter.RuleInfo，连接事件为 *Conn，ConfigReloaded 为配置的变更
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+type Node struct {
+	UniqueId string  `json:"unique_id"`
+	Name     string  `json:"name"`
+	Alive    bool    `json:"alive"`
+	Delay    int     `json:"delay"`
+	Speed    float64 `json:"speed,omitempty"`
+
+	Proxy adapter.AdapterProxy `json:"-"`
+}
+
+// NewNode 节点当前状态的快照，Delay 为 -1 表示检测失败
+func NewNode(proxy adapter.AdapterProxy) *Node {
+	n := &Node{
+		UniqueId: proxy.UniqueId(),
+		Name:     proxy.Name(),
+		Alive:    proxy.LoadBool(adapter.CacheAlive),
+		Delay:    int(proxy.LoadUint16(adapter.CacheDelay)),
+		Speed:    proxy.LoadFloat64(adapter.CacheSpeed),
+		Proxy:    proxy,
+	}
+	if !n.Alive {
+		n.Delay = -1
+	}
+	return n
+}
+
+// NodeChange 节点缓存中 alive 或 delay 的变化
+type NodeChange struct {
+	*Node
+
+	Key     string `json:"key"`
+	Old     any    `json:"old"`
+	New     any    `json:"new"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Circuit 节点熔断状态变化，计数为统计窗口内的连接结果
+type Circuit struct {
+	*Node
+
+	State     string  `json:"state"`
+	Success   int     `json:"success"`
+	Dial      int     `json:"dial"`
+	TLS       int     `json:"tls"`
+	Empty     int     `json:"empty"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+type Conn struct {
+	Id      string `json:"id"`
+	Network string `json:"network"`
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	Adapter string `json:"adapter"`
+	Proxy   string `json:"proxy"`
+
+	// Duration Upload Download 只有 ConnClosed 有
+	Duration time.Duration `json:"duration,omitempty"`
+	Upload   int64         `json:"upload,omitempty"`
+	Download int64         `json:"download,omitempty"`
+}
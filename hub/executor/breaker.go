@@ -0,0 +1,414 @@
+package executor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub/event"
+)
+
+var ErrCircuitOpen = errors.New("node is ejected by circuit breaker")
+
+// outcome 一次连接的结果
+type outcome int
+
+const (
+	// outcomeNone 没有结果，如被取消或两个方向都没有数据，只释放试探
+	outcomeNone outcome = iota
+	outcomeSuccess
+	// outcomeDial 连接节点的服务器失败
+	outcomeDial
+	// outcomeTLS 与节点 tls 握手失败
+	outcomeTLS
+	// outcomeEmpty 发送了数据但没有收到任何响应
+	outcomeEmpty
+)
+
+// breakerEmptyDests 窗口内至少有这么多个不同的目标没有响应，才把没有响应计为节点的失败，
+// 单个目标没有响应更可能是目标自己的问题
+const breakerEmptyDests = 3
+
+// dialOutcome 区分建立连接失败的原因，只有连接节点本身或与节点 tls 握手失败才计入，
+// 其他错误（如 socks5、http 节点连接目标被拒绝）可能是目标的问题，返回 outcomeNone
+func dialOutcome(err error) outcome {
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return outcomeTLS
+	}
+
+	// tls 的 alert 没有导出
+	if strings.Contains(err.Error(), "tls: ") {
+		return outcomeTLS
+	}
+
+	// 连接节点服务器的 tcp 连接失败
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return outcomeDial
+	}
+
+	return outcomeNone
+}
+
+// relayOutcome 转发结束后的结果，上传了数据却没有下载视为失败
+func relayOutcome(up, down int64) outcome {
+	switch {
+	case down > 0:
+		return outcomeSuccess
+	case up > 0:
+		return outcomeEmpty
+	default:
+		return outcomeNone
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerBuckets 滑动窗口分成的桶数
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	idx int64
+
+	success, dial, tls, empty int
+}
+
+// CircuitStats 统计窗口内的连接结果
+type CircuitStats struct {
+	State   string `json:"state"`
+	Success int    `json:"success"`
+	Dial    int    `json:"dial"`
+	TLS     int    `json:"tls"`
+	Empty   int    `json:"empty"`
+}
+
+func (s CircuitStats) Failures() int {
+	return s.Dial + s.TLS + s.Empty
+}
+
+func (s CircuitStats) Total() int {
+	return s.Success + s.Failures()
+}
+
+// ErrorRate 没有连接时为 0
+func (s CircuitStats) ErrorRate() float64 {
+	if s.Total() == 0 {
+		return 0
+	}
+	return float64(s.Failures()) / float64(s.Total())
+}
+
+type circuit struct {
+	state    circuitState
+	openedAt time.Time
+	// probing 半开状态下已经放行了一个连接，等待结果
+	probing bool
+
+	buckets [breakerBuckets]breakerBucket
+
+	// empties 窗口内没有响应的目标及最后一次的时间
+	empties map[string]time.Time
+}
+
+func (c *circuit) reset() {
+	c.buckets = [breakerBuckets]breakerBucket{}
+	c.empties = nil
+}
+
+// circuitChange 熔断状态的变化
+type circuitChange struct {
+	from, to circuitState
+	stats    CircuitStats
+}
+
+// breakers 按节点记录实际连接的结果，失败比例过高时熔断
+type breakers struct {
+	lock sync.Mutex
+	cfg  config.Breaker
+
+	circuits map[string]*circuit
+
+	now func() time.Time
+}
+
+func newBreakers(cfg config.Breaker) *breakers {
+	return &breakers{
+		cfg:      cfg,
+		circuits: map[string]*circuit{},
+		now:      time.Now,
+	}
+}
+
+func (p *breakers) setConfig(cfg config.Breaker) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.cfg = cfg
+	if cfg.Threshold <= 0 {
+		p.circuits = map[string]*circuit{}
+	}
+}
+
+func (p *breakers) remove(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.circuits, id)
+}
+
+func (p *breakers) bucketWidth() time.Duration {
+	width := p.cfg.Window / breakerBuckets
+	if width <= 0 {
+		width = time.Millisecond
+	}
+	return width
+}
+
+// ready 节点能否被选中，不占用半开状态的试探
+func (p *breakers) ready(id string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	c, ok := p.circuits[id]
+	if !ok {
+		return true
+	}
+
+	switch c.state {
+	case circuitOpen:
+		return !p.now().Before(c.openedAt.Add(p.cfg.Cooldown))
+	case circuitHalfOpen:
+		return !c.probing
+	default:
+		return true
+	}
+}
+
+// acquire 连接前调用，熔断时返回 false；冷却结束后只放行一个连接试探
+// 返回 true 时需要调用 done
+func (p *breakers) acquire(id string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	c, ok := p.circuits[id]
+	if !ok {
+		return true
+	}
+
+	switch c.state {
+	case circuitOpen:
+		if p.now().Before(c.openedAt.Add(p.cfg.Cooldown)) {
+			return false
+		}
+		c.state = circuitHalfOpen
+		c.probing = true
+		return true
+
+	case circuitHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+		return true
+
+	default:
+		return true
+	}
+}
+
+// done 记录连接的结果，状态变化时返回变化，dest 为连接的目标，用于区分 outcomeEmpty 是否来自不同的目标
+func (p *breakers) done(id string, o outcome, dest string) *circuitChange {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.cfg.Threshold <= 0 {
+		return nil
+	}
+
+	c, ok := p.circuits[id]
+	if !ok {
+		if o == outcomeNone {
+			return nil
+		}
+		c = &circuit{}
+		p.circuits[id] = c
+	}
+
+	if o == outcomeEmpty && !p.emptyAcrossDests(c, dest) {
+		o = outcomeNone
+	}
+
+	if c.state == circuitHalfOpen {
+		if !c.probing {
+			// 半开之前开始的连接，结果不作为试探
+			return nil
+		}
+		c.probing = false
+
+		switch o {
+		case outcomeNone:
+			return nil
+		case outcomeSuccess:
+			c.state = circuitClosed
+			c.reset()
+			p.add(c, o)
+			return &circuitChange{from: circuitHalfOpen, to: circuitClosed, stats: p.stats(c)}
+		default:
+			c.state = circuitOpen
+			c.openedAt = p.now()
+			p.add(c, o)
+			return &circuitChange{from: circuitHalfOpen, to: circuitOpen, stats: p.stats(c)}
+		}
+	}
+
+	if o == outcomeNone || c.state == circuitOpen {
+		return nil
+	}
+
+	p.add(c, o)
+
+	stats := p.stats(c)
+	if stats.Total() < p.cfg.MinRequests || stats.ErrorRate() < p.cfg.Threshold {
+		return nil
+	}
+
+	c.state = circuitOpen
+	c.openedAt = p.now()
+	stats.State = c.state.String()
+	return &circuitChange{from: circuitClosed, to: circuitOpen, stats: stats}
+}
+
+// emptyAcrossDests 记录没有响应的目标，窗口内不同的目标足够多时返回 true
+func (p *breakers) emptyAcrossDests(c *circuit, dest string) bool {
+	now := p.now()
+	if c.empties == nil {
+		c.empties = map[string]time.Time{}
+	}
+	for d, at := range c.empties {
+		if now.Sub(at) >= p.cfg.Window {
+			delete(c.empties, d)
+		}
+	}
+	c.empties[dest] = now
+
+	return len(c.empties) >= breakerEmptyDests
+}
+
+func (p *breakers) add(c *circuit, o outcome) {
+	idx := p.now().UnixNano() / int64(p.bucketWidth())
+	b := &c.buckets[idx%breakerBuckets]
+	if b.idx != idx {
+		*b = breakerBucket{idx: idx}
+	}
+
+	switch o {
+	case outcomeSuccess:
+		b.success++
+	case outcomeDial:
+		b.dial++
+	case outcomeTLS:
+		b.tls++
+	case outcomeEmpty:
+		b.empty++
+	}
+}
+
+func (p *breakers) stats(c *circuit) CircuitStats {
+	idx := p.now().UnixNano() / int64(p.bucketWidth())
+
+	s := CircuitStats{State: c.state.String()}
+	for _, b := range c.buckets {
+		if b.idx <= idx-breakerBuckets || b.idx > idx {
+			continue
+		}
+		s.Success += b.success
+		s.Dial += b.dial
+		s.TLS += b.tls
+		s.Empty += b.empty
+	}
+	return s
+}
+
+// get 节点的熔断状态
+func (p *breakers) get(id string) CircuitStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	c, ok := p.circuits[id]
+	if !ok {
+		return CircuitStats{State: circuitClosed.String()}
+	}
+	return p.stats(c)
+}
+
+// SetBreaker 修改熔断的配置，Threshold 为 0 时清除所有熔断
+func (p *Executor) SetBreaker(b config.Breaker) {
+	p.breakers.setConfig(b)
+}
+
+// Circuit 节点的熔断状态和统计窗口内的连接结果
+func (p *Executor) Circuit(uniqueId string) CircuitStats {
+	return p.breakers.get(uniqueId)
+}
+
+// circuitDone 记录节点连接 dest 的结果，熔断或恢复时发送事件
+func (p *Executor) circuitDone(cc constant.ProxyAdapter, o outcome, dest string) {
+	node, ok := cc.(adapter.AdapterProxy)
+	if !ok {
+		return
+	}
+
+	change := p.breakers.done(node.UniqueId(), o, dest)
+	if change == nil {
+		return
+	}
+
+	info := &event.Circuit{
+		Node:      event.NewNode(node),
+		State:     change.stats.State,
+		Success:   change.stats.Success,
+		Dial:      change.stats.Dial,
+		TLS:       change.stats.TLS,
+		Empty:     change.stats.Empty,
+		ErrorRate: change.stats.ErrorRate(),
+	}
+
+	switch change.to {
+	case circuitOpen:
+		log.Warnf("node %s[%s] ejected, error rate %.2f", node.Name(), node.UniqueId(), info.ErrorRate)
+		p.publish(event.NodeEjected, info)
+	case circuitClosed:
+		log.Infof("node %s[%s] restored", node.Name(), node.UniqueId())
+		p.publish(event.NodeRestored, info)
+	}
+}
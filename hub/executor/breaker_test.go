@@ -0,0 +1,283 @@
+package executor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+	"github.com/Dreamacro/clash/constant"
+	clashctx "github.com/Dreamacro/clash/context"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub/event"
+	"github.com/darabuchi/nico/hub/rule"
+	"github.com/darabuchi/nico/internal/testutil"
+)
+
+func TestDialOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want outcome
+	}{
+		{err: fmt.Errorf("1.2.3.4:443 connect error: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}), want: outcomeDial},
+		// 节点连接目标失败、握手中断等无法确定是节点的问题
+		{err: errors.New("general SOCKS server failure"), want: outcomeNone},
+		{err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, want: outcomeNone},
+		{err: context.DeadlineExceeded, want: outcomeNone},
+		{err: fmt.Errorf("vmess: %w", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), want: outcomeTLS},
+		{err: fmt.Errorf("trojan: %w", x509.HostnameError{Certificate: &x509.Certificate{}, Host: "a.com"}), want: outcomeTLS},
+		{err: errors.New("remote error: tls: handshake failure"), want: outcomeTLS},
+	}
+
+	for _, tt := range tests {
+		if got := dialOutcome(tt.err); got != tt.want {
+			t.Errorf("%v got %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRelayOutcome(t *testing.T) {
+	tests := []struct {
+		up, down int64
+		want     outcome
+	}{
+		{up: 10, down: 20, want: outcomeSuccess},
+		{up: 0, down: 20, want: outcomeSuccess},
+		{up: 10, down: 0, want: outcomeEmpty},
+		{up: 0, down: 0, want: outcomeNone},
+	}
+
+	for _, tt := range tests {
+		if got := relayOutcome(tt.up, tt.down); got != tt.want {
+			t.Errorf("up %d down %d got %d, want %d", tt.up, tt.down, got, tt.want)
+		}
+	}
+}
+
+func TestBreakers(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := newBreakers(config.Breaker{
+		Window:      time.Second * 10,
+		MinRequests: 4,
+		Threshold:   0.5,
+		Cooldown:    time.Second * 5,
+	})
+	p.now = func() time.Time { return now }
+
+	done := func(o outcome) *circuitChange {
+		if !p.acquire("a") {
+			t.Fatalf("acquire failed, state %s", p.get("a").State)
+		}
+		return p.done("a", o, "dest")
+	}
+
+	// 连接数不足时不熔断
+	done(outcomeSuccess)
+	done(outcomeDial)
+	if c := done(outcomeTLS); c != nil {
+		t.Fatalf("ejected before min requests")
+	}
+
+	// 窗口外的结果不统计
+	now = now.Add(time.Second * 11)
+	done(outcomeSuccess)
+	done(outcomeSuccess)
+	done(outcomeTLS)
+	if c := done(outcomeSuccess); c != nil {
+		t.Fatalf("ejected with error rate %.2f", c.stats.ErrorRate())
+	}
+
+	now = now.Add(time.Second)
+	done(outcomeDial)
+	c := done(outcomeDial)
+	if c == nil || c.to != circuitOpen {
+		t.Fatalf("not ejected, stats %+v", p.get("a"))
+	}
+	if c.stats.Success != 3 || c.stats.Failures() != 3 || c.stats.State != "open" {
+		t.Errorf("stats got %+v", c.stats)
+	}
+
+	// 冷却中不放行
+	if p.ready("a") || p.acquire("a") {
+		t.Fatalf("open circuit should reject")
+	}
+
+	// 冷却后只放行一个试探
+	now = now.Add(time.Second * 5)
+	if !p.ready("a") || !p.acquire("a") {
+		t.Fatalf("half open circuit should allow probe")
+	}
+	if p.ready("a") || p.acquire("a") {
+		t.Fatalf("only one probe allowed")
+	}
+
+	// 没有结果的试探不改变状态
+	if c := p.done("a", outcomeNone, "dest"); c != nil {
+		t.Fatalf("got change %+v", c)
+	}
+
+	// 试探失败重新熔断
+	if c := done(outcomeDial); c == nil || c.from != circuitHalfOpen || c.to != circuitOpen {
+		t.Fatalf("got change %+v", c)
+	}
+	if p.acquire("a") {
+		t.Fatalf("open circuit should reject")
+	}
+
+	// 试探成功恢复，之前的统计清空
+	now = now.Add(time.Second * 5)
+	if c := done(outcomeSuccess); c == nil || c.to != circuitClosed {
+		t.Fatalf("got change %+v", c)
+	}
+	if s := p.get("a"); s.State != "closed" || s.Total() != 1 {
+		t.Errorf("stats got %+v", s)
+	}
+
+	// 关闭熔断
+	done(outcomeDial)
+	p.setConfig(config.Breaker{})
+	for i := 0; i < 10; i++ {
+		if c := done(outcomeDial); c != nil {
+			t.Fatalf("breaker disabled but got change %+v", c)
+		}
+	}
+}
+
+// TestBreakers_Empty 同一个目标没有响应不计入，不同的目标都没有响应才是节点的问题
+func TestBreakers_Empty(t *testing.T) {
+	p := newBreakers(config.Breaker{
+		Window:      time.Minute,
+		MinRequests: 3,
+		Threshold:   0.5,
+		Cooldown:    time.Minute,
+	})
+
+	for i := 0; i < 5; i++ {
+		if c := p.done("a", outcomeEmpty, "a.com:443"); c != nil {
+			t.Fatalf("got change %+v", c)
+		}
+	}
+	if s := p.get("a"); s.Total() != 0 {
+		t.Errorf("stats got %+v", s)
+	}
+
+	var c *circuitChange
+	for _, dest := range []string{"b.com:443", "c.com:443", "d.com:443", "e.com:443"} {
+		c = p.done("a", outcomeEmpty, dest)
+	}
+	if c == nil || c.to != circuitOpen || c.stats.Empty != 3 {
+		t.Fatalf("got change %+v", c)
+	}
+}
+
+// TestExecutor_Breaker 连接失败过多的节点被熔断，发送事件，之后不再尝试
+func TestExecutor_Breaker(t *testing.T) {
+	p := NewExecutor(
+		WithFailover(config.Failover{Timeout: time.Second}),
+		WithBreaker(config.Breaker{Window: time.Minute, MinRequests: 3, Threshold: 0.5, Cooldown: time.Minute}),
+	)
+
+	sub := p.Events().Subscribe(10, event.NodeEjected)
+	defer sub.Close()
+
+	bad := newDialProxy("bad", 0, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	// 节点连接目标失败，不计入节点
+	target := newDialProxy("target", 0, errors.New("general SOCKS server failure"))
+	good := newDialProxy("good", 0, nil)
+
+	for i := 0; i < 3; i++ {
+		conn, cc, err := p.dialFailover(context.Background(), &constant.Metadata{}, []constant.ProxyAdapter{bad, target, good})
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		_ = conn.Close()
+		p.circuitDone(cc, outcomeSuccess, "")
+	}
+
+	select {
+	case e := <-sub.Events():
+		info := e.Data.(*event.Circuit)
+		if info.UniqueId != "bad" || info.State != "open" || info.Dial != 3 || info.ErrorRate != 1 {
+			t.Errorf("got %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no eject event")
+	}
+
+	_, cc, err := p.dialFailover(context.Background(), &constant.Metadata{}, []constant.ProxyAdapter{bad, good})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if cc.Name() != "good" || bad.dialed.Load() != 3 {
+		t.Errorf("got %s, bad dialed %d", cc.Name(), bad.dialed.Load())
+	}
+
+	if s := p.Circuit("good"); s.State != "closed" || s.Success != 3 {
+		t.Errorf("good stats %+v", s)
+	}
+	if s := p.Circuit("target"); s.State != "closed" || s.Total() != 0 {
+		t.Errorf("target stats %+v", s)
+	}
+}
+
+// TestExecutor_BreakerProbe 半开状态的试探收到第一个字节就恢复，不等连接结束
+func TestExecutor_BreakerProbe(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	socks := testutil.NewSocks5Server(t)
+	defer socks.Close()
+
+	p := NewExecutor(
+		WithAdapterRule(rule.NewMemoryAdapterRule()),
+		WithBreaker(config.Breaker{Window: time.Minute, MinRequests: 1, Threshold: 0.5, Cooldown: time.Minute}),
+	)
+
+	node := socks.Node(t, "node", "")
+	p.AddNode(node)
+	node.Store(Alive, true)
+	p.proxySort()
+
+	metadata := testutil.TcpMetadata(echo.Addr().String())
+	p.learn(metadata, adapter.Proxy)
+
+	now := time.Now()
+	p.breakers.now = func() time.Time { return now }
+	if c := p.breakers.done(node.UniqueId(), outcomeDial, ""); c == nil || c.to != circuitOpen {
+		t.Fatalf("got change %+v", c)
+	}
+	later := now.Add(time.Minute)
+	p.breakers.now = func() time.Time { return later }
+
+	local, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.serveConn(context.Background(), clashctx.NewConnContext(local, metadata), outbound.NewDirect(), outbound.NewReject())
+	}()
+
+	if err := ping(t, client); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 连接还没有结束
+	if s := p.Circuit(node.UniqueId()); s.State != "closed" {
+		t.Errorf("probe not recorded, stats %+v", s)
+	}
+
+	client.Close()
+	<-done
+
+	// 连接结束时不再重复记录
+	if s := p.Circuit(node.UniqueId()); s.Success != 1 {
+		t.Errorf("stats got %+v", s)
+	}
+}
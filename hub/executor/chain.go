@@ -0,0 +1,140 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+)
+
+// chainDialer 节点的跳板，每次连接时按名称查找，跳板节点可以晚于使用它的节点加入
+type chainDialer struct {
+	p    *Executor
+	name string
+}
+
+func (d *chainDialer) DialContext(ctx context.Context, metadata *constant.Metadata, opts ...dialer.Option) (constant.Conn, error) {
+	hop, err := d.p.resolveDialer(d.name, adapter.DialHops(ctx))
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return hop.DialContext(ctx, metadata, opts...)
+}
+
+// setDialer 节点配置了跳板时通过跳板连接
+func (p *Executor) setDialer(n adapter.AdapterProxy) {
+	name := n.DialerProxy()
+	if name == "" {
+		return
+	}
+
+	n.SetDialer(&chainDialer{p: p, name: name})
+}
+
+// resolveDialer 按 UniqueId、节点名、分组名的顺序查找跳板，hops 为已经经过的节点
+// 跳板是分组时从分组中可用的节点里选一个，跳过已经经过的节点
+func (p *Executor) resolveDialer(name string, hops []string) (adapter.AdapterProxy, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if node := p.findNode(name); node != nil {
+		return node, nil
+	}
+
+	for _, g := range p.groups {
+		if g.Name != name {
+			continue
+		}
+
+		candidates := p.aliveProxy.Filter(func(proxy adapter.AdapterProxy) bool {
+			if !proxy.LoadBool(Alive) || !g.match(proxy) || !p.breakers.ready(proxy.UniqueId()) {
+				return false
+			}
+			for _, hop := range hops {
+				if proxy.UniqueId() == hop {
+					return false
+				}
+			}
+			return true
+		})
+
+		s := g.Selector
+		if s == nil {
+			s = p.selector
+		}
+
+		hop := s.Select(candidates, nil)
+		if hop == nil {
+			return nil, fmt.Errorf("%w: no usable node in group %s", adapter.ErrDialerNotFound, name)
+		}
+		return hop, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", adapter.ErrDialerNotFound, name)
+}
+
+// DialerChain 按配置依次列出节点经过的跳板名称，跳板是分组时到分组为止
+func (p *Executor) DialerChain(uniqueId string) ([]string, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	node := p.findNode(uniqueId)
+	if node == nil {
+		return nil, fmt.Errorf("%w: %s", adapter.ErrDialerNotFound, uniqueId)
+	}
+
+	var chain []string
+	visited := map[string]bool{node.UniqueId(): true}
+	for name := node.DialerProxy(); name != ""; {
+		chain = append(chain, name)
+
+		next := p.findNode(name)
+		if next == nil {
+			if p.hasGroup(name) {
+				return chain, nil
+			}
+			return chain, fmt.Errorf("%w: %s", adapter.ErrDialerNotFound, name)
+		}
+
+		if visited[next.UniqueId()] {
+			return chain, fmt.Errorf("%w: %v", adapter.ErrDialerLoop, chain)
+		}
+		visited[next.UniqueId()] = true
+
+		name = next.DialerProxy()
+	}
+
+	return chain, nil
+}
+
+// findNode 按 UniqueId 或节点名查找，调用方需持有 p.lock
+func (p *Executor) findNode(name string) adapter.AdapterProxy {
+	if idx := p.allProxy.FindFirstUsing(func(value adapter.AdapterProxy) bool {
+		return value.UniqueId() == name
+	}); idx >= 0 {
+		return p.allProxy[idx]
+	}
+
+	if idx := p.allProxy.FindFirstUsing(func(value adapter.AdapterProxy) bool {
+		return value.Name() == name
+	}); idx >= 0 {
+		return p.allProxy[idx]
+	}
+
+	return nil
+}
+
+// hasGroup 调用方需持有 p.lock
+func (p *Executor) hasGroup(name string) bool {
+	for _, g := range p.groups {
+		if g.Name == name {
+			return true
+		}
+	}
+	return false
+}
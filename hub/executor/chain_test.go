@@ -0,0 +1,171 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/hub/store"
+	"github.com/darabuchi/nico/internal/testutil"
+)
+
+// pingVia 通过节点连接 echo 服务端
+func pingVia(t *testing.T, node adapter.AdapterProxy, echo net.Listener) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	conn, err := node.DialContext(ctx, testutil.TcpMetadata(echo.Addr().String()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return ping(t, conn)
+}
+
+func TestExecutor_DialerProxy(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	jumpStub := testutil.NewSocks5Server(t)
+	defer jumpStub.Close()
+	exitStub := testutil.NewSocks5Server(t)
+	defer exitStub.Close()
+
+	ex := NewExecutor()
+
+	jump := jumpStub.Node(t, "jump", "")
+	exit := exitStub.Node(t, "exit", "jump")
+
+	// 跳板可以晚于使用它的节点加入
+	ex.AddNode(exit)
+	if _, err := ex.DialerChain(exit.UniqueId()); !errors.Is(err, adapter.ErrDialerNotFound) {
+		t.Errorf("got err %v, want %v", err, adapter.ErrDialerNotFound)
+	}
+	if err := pingVia(t, exit, echo); !errors.Is(err, adapter.ErrDialerNotFound) {
+		t.Errorf("got err %v, want %v", err, adapter.ErrDialerNotFound)
+	}
+
+	ex.AddNode(jump)
+	chain, err := ex.DialerChain(exit.UniqueId())
+	if err != nil || len(chain) != 1 || chain[0] != "jump" {
+		t.Errorf("chain got %v, err:%v", chain, err)
+	}
+
+	if err = pingVia(t, exit, echo); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(jumpStub.Targets()) != 1 || len(exitStub.Targets()) != 1 {
+		t.Errorf("jump %d exit %d", len(jumpStub.Targets()), len(exitStub.Targets()))
+	}
+}
+
+func TestExecutor_DialerProxyGroup(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	jumpStub := testutil.NewSocks5Server(t)
+	defer jumpStub.Close()
+	selfStub := testutil.NewSocks5Server(t)
+	defer selfStub.Close()
+
+	ex := NewExecutor()
+	ex.SetGroups(&Group{Name: "jumps", Filter: regexp.MustCompile("^jump")})
+
+	jump := jumpStub.Node(t, "jump", "")
+	// 自己也在分组中，选择跳板时跳过自己
+	self := selfStub.Node(t, "jump-self", "jumps")
+
+	ex.AddNode(jump)
+	ex.AddNode(self)
+	jump.Store(Alive, true)
+	self.Store(Alive, true)
+	ex.proxySort()
+
+	chain, err := ex.DialerChain(self.UniqueId())
+	if err != nil || len(chain) != 1 || chain[0] != "jumps" {
+		t.Errorf("chain got %v, err:%v", chain, err)
+	}
+
+	if err = pingVia(t, self, echo); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(jumpStub.Targets()) != 1 || len(selfStub.Targets()) != 1 {
+		t.Errorf("jump %d self %d", len(jumpStub.Targets()), len(selfStub.Targets()))
+	}
+
+	// 分组中没有其他可用节点
+	jump.Store(Alive, false)
+	ex.proxySort()
+	if err = pingVia(t, self, echo); !errors.Is(err, adapter.ErrDialerNotFound) {
+		t.Errorf("got err %v, want %v", err, adapter.ErrDialerNotFound)
+	}
+}
+
+func TestExecutor_DialerProxyLoop(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	aStub := testutil.NewSocks5Server(t)
+	defer aStub.Close()
+	bStub := testutil.NewSocks5Server(t)
+	defer bStub.Close()
+
+	ex := NewExecutor()
+
+	a := aStub.Node(t, "a", "b")
+	b := bStub.Node(t, "b", "a")
+	ex.AddNode(a)
+	ex.AddNode(b)
+
+	if _, err := ex.DialerChain(a.UniqueId()); !errors.Is(err, adapter.ErrDialerLoop) {
+		t.Errorf("got err %v, want %v", err, adapter.ErrDialerLoop)
+	}
+	if err := pingVia(t, a, echo); !errors.Is(err, adapter.ErrDialerLoop) {
+		t.Errorf("got err %v, want %v", err, adapter.ErrDialerLoop)
+	}
+	if len(aStub.Targets())+len(bStub.Targets()) != 0 {
+		t.Errorf("a %d b %d", len(aStub.Targets()), len(bStub.Targets()))
+	}
+}
+
+// TestExecutor_DialerProxyRestore 重启后恢复的节点仍然通过跳板连接
+func TestExecutor_DialerProxyRestore(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	jumpStub := testutil.NewSocks5Server(t)
+	defer jumpStub.Close()
+	exitStub := testutil.NewSocks5Server(t)
+	defer exitStub.Close()
+
+	s := store.NewFileStore(filepath.Join(t.TempDir(), "state.yaml"))
+
+	ex := NewExecutor(WithStore(s))
+	ex.AddNode(jumpStub.Node(t, "jump", ""))
+	exit := exitStub.Node(t, "exit", "jump")
+	ex.AddNode(exit)
+	if err := ex.SaveState(); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	restarted := NewExecutor(WithStore(s))
+	restored := restarted.Nodes().Filter(func(node adapter.AdapterProxy) bool {
+		return node.UniqueId() == exit.UniqueId()
+	})
+	if len(restored) != 1 {
+		t.Fatalf("exit not restored, got %d nodes", len(restarted.Nodes()))
+	}
+
+	if err := pingVia(t, restored[0], echo); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(jumpStub.Targets()) != 1 || len(exitStub.Targets()) != 1 {
+		t.Errorf("jump %d exit %d", len(jumpStub.Targets()), len(exitStub.Targets()))
+	}
+}
@@ -2,11 +2,10 @@ package executor
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -17,34 +16,145 @@ import (
 	"github.com/Dreamacro/clash/listener/mixed"
 	"github.com/darabuchi/log"
 	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub/event"
 	"github.com/darabuchi/nico/hub/rule"
+	"github.com/darabuchi/nico/hub/selector"
+	"github.com/darabuchi/nico/hub/store"
 	"github.com/darabuchi/utils"
 )
 
 const (
-	Alive    = "alive"
-	Delay    = "delay"
-	Speed    = "speed"
-	SpeedStr = "speed_str"
+	Alive    = adapter.CacheAlive
+	Delay    = adapter.CacheDelay
+	Speed    = adapter.CacheSpeed
+	SpeedStr = adapter.CacheSpeedStr
+	Suspect  = adapter.CacheSuspect
 )
 
-type ExecutorCallback struct {
-	OnNodeAdd    func(node adapter.AdapterProxy)
-	OnNodeDel    func(node adapter.AdapterProxy)
-	OnDelayCheck func(node adapter.AdapterProxy, delay time.Duration)
-}
-
 type Executor struct {
-	callback             *ExecutorCallback
+	bus *event.Bus
+
 	lock                 sync.RWMutex
 	allProxy, aliveProxy adapter.ProxyList
 
-	event chan executorEvent
+	eventLock   sync.Mutex
+	events      []executorEvent
+	eventNotify chan struct{}
 
 	connChan chan constant.ConnContext
 	service  *mixed.Listener
+	inbounds map[string]*mixed.Listener
 
 	rule *rule.AdapterRule
+
+	// tlsConfig 节点探测请求的证书校验配置
+	tlsConfig *tls.Config
+
+	// 节点缓存的订阅，节点删除时取消
+	unsubscribe map[string]func()
+
+	geo *adapter.GeoEnricher
+
+	// 正在补全地区信息的节点
+	geoLock   sync.Mutex
+	enriching map[string]bool
+
+	store store.Store
+
+	selector selector.Selector
+	groups   []*Group
+
+	// 节点排序时延迟和速度的权重
+	weight adapter.ScoreWeight
+
+	healthCheckUrl      string
+	healthCheckInterval time.Duration
+
+	limiter *connLimiter
+
+	failover config.Failover
+	breakers *breakers
+
+	cancel context.CancelFunc
+
+	// loops handleConn 和 handleNode，relays 进行中的连接
+	loops, relays sync.WaitGroup
+
+	connLock    sync.Mutex
+	conns       map[net.Conn]struct{}
+	forceClosed bool
+}
+
+type Option func(p *Executor)
+
+// WithStore 节点状态持久化，为空时读取配置 state_file
+func WithStore(s store.Store) Option {
+	return func(p *Executor) {
+		p.store = s
+	}
+}
+
+// WithSelector 节点选择策略，为空时读取配置 selector
+func WithSelector(s selector.Selector) Option {
+	return func(p *Executor) {
+		p.selector = s
+	}
+}
+
+// WithBus 使用外部的事件总线，多个 Executor 可以共用，默认每个 Executor 单独一个
+func WithBus(bus *event.Bus) Option {
+	return func(p *Executor) {
+		p.bus = bus
+	}
+}
+
+// WithLimit 连接数和空闲时间的限制，默认读取配置 limit
+func WithLimit(l config.Limit) Option {
+	return func(p *Executor) {
+		p.limiter = newConnLimiter(l)
+	}
+}
+
+// WithFailover 建立连接失败时的重试策略，默认读取配置 failover
+func WithFailover(f config.Failover) Option {
+	return func(p *Executor) {
+		p.failover = f
+	}
+}
+
+// WithBreaker 根据实际连接的结果熔断节点，默认读取配置 breaker
+func WithBreaker(b config.Breaker) Option {
+	return func(p *Executor) {
+		p.breakers = newBreakers(b)
+	}
+}
+
+// WithAdapterRule 使用的规则，包括学习到的规则，默认使用进程共享的规则
+// 同一进程中的多个 Executor 需要各自的规则时使用 rule.NewMemoryAdapterRule
+func WithAdapterRule(ar *rule.AdapterRule) Option {
+	return func(p *Executor) {
+		p.rule = ar
+	}
+}
+
+// WithTls 节点探测请求的证书校验配置，默认读取配置 tls
+func WithTls(opt adapter.TlsOption) Option {
+	return func(p *Executor) {
+		c, err := opt.TlsConfig()
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return
+		}
+		p.tlsConfig = c
+	}
+}
+
+// WithScoreWeight 节点排序时延迟和速度的权重，默认读取配置 rank
+func WithScoreWeight(w adapter.ScoreWeight) Option {
+	return func(p *Executor) {
+		p.weight = w
+	}
 }
 
 type eventType int
@@ -52,6 +162,15 @@ type eventType int
 const (
 	eventCheckDelay eventType = iota
 	eventCleanDead
+	eventHealthCheck
+)
+
+// connQueueSize 已接受但还未处理的连接数，超出时入站会等待
+const connQueueSize = 64
+
+const (
+	DefaultHealthCheckUrl      = "https://www.google.com"
+	DefaultHealthCheckInterval = time.Minute * 5
 )
 
 type executorEvent struct {
@@ -60,128 +179,398 @@ type executorEvent struct {
 	node adapter.AdapterProxy
 }
 
-func NewExecutor() *Executor {
+func NewExecutor(opts ...Option) *Executor {
+	cfg := config.Current()
+
 	p := &Executor{
-		callback: &ExecutorCallback{},
-		connChan: make(chan constant.ConnContext),
-		event:    make(chan executorEvent, 5),
-		rule:     rule.GetAdapterRule(),
+		connChan: make(chan constant.ConnContext, connQueueSize),
+
+		eventNotify: make(chan struct{}, 1),
+
+		unsubscribe: map[string]func(){},
+		enriching:   map[string]bool{},
+		conns:       map[net.Conn]struct{}{},
+		inbounds:    map[string]*mixed.Listener{},
+
+		healthCheckUrl:      cfg.HealthCheck.Url,
+		healthCheckInterval: cfg.HealthCheck.Interval,
+
+		weight: cfg.Rank,
+
+		limiter: newConnLimiter(cfg.Limit),
+
+		failover: cfg.Failover,
+		breakers: newBreakers(cfg.Breaker),
 	}
 
-	p.handleConn()
-	p.handleNode()
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.store == nil && cfg.StateFile != "" {
+		p.store = store.NewFileStore(cfg.StateFile)
+	}
+
+	if p.selector == nil {
+		p.selector = loadSelector(cfg.Selector)
+	}
+
+	if p.bus == nil {
+		p.bus = event.NewBus()
+	}
+
+	if p.rule == nil {
+		p.rule = rule.GetAdapterRule()
+	}
+
+	if p.tlsConfig == nil {
+		c, err := cfg.Tls.TlsConfig()
+		if err != nil {
+			log.Errorf("err:%v", err)
+		} else {
+			p.tlsConfig = c
+		}
+	}
+
+	if p.healthCheckUrl == "" {
+		p.healthCheckUrl = DefaultHealthCheckUrl
+	}
+	if p.healthCheckInterval <= 0 {
+		p.healthCheckInterval = DefaultHealthCheckInterval
+	}
+
+	geo, err := NewGeoEnricher(cfg.Geo)
+	if err == nil {
+		p.geo = geo
+	}
+	p.restoreState()
 
 	return p
 }
 
-// 事件处理
+// SetScoreWeight 修改节点排序时延迟和速度的权重，立即重新排序
+func (p *Executor) SetScoreWeight(w adapter.ScoreWeight) {
+	p.lock.Lock()
+	p.weight = w
+	p.lock.Unlock()
 
-func (p *Executor) OnNodeAdd(logic func(node adapter.AdapterProxy)) {
-	p.callback.OnNodeAdd = logic
+	p.proxySort()
 }
 
-func (p *Executor) onNodeAdd(node adapter.AdapterProxy) {
-	defer utils.CachePanic()
-	if p.callback.OnNodeAdd != nil {
-		p.callback.OnNodeAdd(node)
+// SetTlsConfig 修改探测请求的证书校验配置，已有的节点同时生效
+func (p *Executor) SetTlsConfig(c *tls.Config) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.tlsConfig = c
+	p.allProxy.Each(func(n adapter.AdapterProxy) {
+		n.SetTlsConfig(c)
+	})
+}
+
+// restoreState 恢复上次保存的节点及其状态
+func (p *Executor) restoreState() {
+	if p.store == nil {
+		return
+	}
+
+	states, err := p.store.Load()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return
 	}
+
+	p.lock.Lock()
+	for _, state := range states {
+		n, err := store.Restore(state)
+		if err != nil {
+			log.Errorf("restore %s fail:%v", state.UniqueId, err)
+			continue
+		}
+
+		if p.allProxy.Any(func(value adapter.AdapterProxy) bool {
+			return value.UniqueId() == n.UniqueId()
+		}) {
+			continue
+		}
+
+		p.allProxy = append(p.allProxy, n)
+		p.watchNode(n)
+		p.setDialer(n)
+		n.SetTlsConfig(p.tlsConfig)
+	}
+	p.lock.Unlock()
+
+	log.Infof("restore %d nodes", len(states))
+
+	p.proxySort()
 }
 
-func (p *Executor) OnNodeDel(logic func(node adapter.AdapterProxy)) {
-	p.callback.OnNodeDel = logic
+// SaveState 保存所有节点的状态
+func (p *Executor) SaveState() error {
+	if p.store == nil {
+		return nil
+	}
+
+	var states []*store.NodeState
+	p.cloneProxyList().Each(func(proxy adapter.AdapterProxy) {
+		states = append(states, store.Dump(proxy))
+	})
+
+	err := p.store.Save(states)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	return nil
 }
 
-func (p *Executor) onNodeDel(node adapter.AdapterProxy) {
-	defer utils.CachePanic()
-	if p.callback.OnNodeDel != nil {
-		p.callback.OnNodeDel(node)
+// NewGeoEnricher 配置了 geo.mmdb 时，节点测速成功后自动补全地区信息，未配置时返回 nil
+func NewGeoEnricher(c config.Geo) (*adapter.GeoEnricher, error) {
+	if c.Mmdb == "" {
+		return nil, nil
+	}
+
+	resolver, err := adapter.NewMmdbResolver(c.Mmdb)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	geo := adapter.NewGeoEnricher(resolver)
+	if c.EchoUrl != "" {
+		geo.EchoUrl = c.EchoUrl
 	}
+
+	return geo, nil
 }
 
-func (p *Executor) OnDelayCheck(logic func(node adapter.AdapterProxy, delay time.Duration)) {
-	p.callback.OnDelayCheck = logic
+func (p *Executor) SetGeoEnricher(geo *adapter.GeoEnricher) {
+	p.geoLock.Lock()
+	defer p.geoLock.Unlock()
+
+	p.geo = geo
 }
 
-func (p *Executor) onDelayCheck(node adapter.AdapterProxy, delay time.Duration) {
-	defer utils.CachePanic()
-	if p.callback.OnDelayCheck != nil {
-		p.callback.OnDelayCheck(node, delay)
+// enrichGeo 补全地区信息要经过节点请求出口 ip，放到单独的 goroutine 中，不阻塞依次进行的测速
+func (p *Executor) enrichGeo(proxy adapter.AdapterProxy) {
+	if proxy.GetExtraInfo().CountryCode != "" {
+		return
 	}
+
+	p.geoLock.Lock()
+	geo := p.geo
+	if geo == nil || p.enriching[proxy.UniqueId()] {
+		p.geoLock.Unlock()
+		return
+	}
+	p.enriching[proxy.UniqueId()] = true
+	p.geoLock.Unlock()
+
+	go func() {
+		defer func() {
+			p.geoLock.Lock()
+			delete(p.enriching, proxy.UniqueId())
+			p.geoLock.Unlock()
+		}()
+
+		err := geo.Enrich(proxy)
+		if err != nil {
+			log.Errorf("err:%v", err)
+		}
+	}()
+}
+
+// loadSelector 配置有误时退回 first
+func loadSelector(c selector.Config) selector.Selector {
+	s, err := selector.New(c)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return selector.NewFirst()
+	}
+
+	return s
+}
+
+func (p *Executor) SetSelector(s selector.Selector) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.selector = s
+}
+
+// 事件处理
+
+// Events 节点、规则和连接的事件
+func (p *Executor) Events() *event.Bus {
+	return p.bus
+}
+
+func (p *Executor) publish(t event.Type, data any) {
+	p.bus.Publish(event.Event{
+		Type: t,
+		Data: data,
+	})
+}
+
+func (p *Executor) publishNode(t event.Type, node adapter.AdapterProxy) {
+	p.publish(t, event.NewNode(node))
+}
+
+// OnNodeAdd 可以多次注册，返回取消注册的函数，回调处理慢时事件排队等待，不会丢弃
+func (p *Executor) OnNodeAdd(logic func(node adapter.AdapterProxy)) func() {
+	return p.bus.On(func(e event.Event) {
+		logic(e.Data.(*event.Node).Proxy)
+	}, event.NodeAdded)
+}
+
+func (p *Executor) OnNodeDel(logic func(node adapter.AdapterProxy)) func() {
+	return p.bus.On(func(e event.Event) {
+		logic(e.Data.(*event.Node).Proxy)
+	}, event.NodeRemoved)
+}
+
+// OnNodeChange 节点 alive 或 delay 变化时回调
+func (p *Executor) OnNodeChange(logic func(node adapter.AdapterProxy, e adapter.CacheEvent)) func() {
+	return p.bus.On(func(e event.Event) {
+		c := e.Data.(*event.NodeChange)
+		logic(c.Proxy, adapter.CacheEvent{
+			Key:     c.Key,
+			Old:     c.Old,
+			New:     c.New,
+			Deleted: c.Deleted,
+		})
+	}, event.NodeAliveChanged, event.NodeDelayChanged)
+}
+
+// OnDelayCheck delay 为 -1 表示检测失败
+func (p *Executor) OnDelayCheck(logic func(node adapter.AdapterProxy, delay time.Duration)) func() {
+	return p.bus.On(func(e event.Event) {
+		n := e.Data.(*event.Node)
+		delay := time.Duration(-1)
+		if n.Delay >= 0 {
+			delay = time.Duration(n.Delay) * time.Millisecond
+		}
+		logic(n.Proxy, delay)
+	}, event.NodeDelayChecked)
 }
 
 func (p *Executor) SetAdapterRule(ar *rule.AdapterRule) {
 	p.rule = ar
 }
 
+func (p *Executor) AdapterRule() *rule.AdapterRule {
+	return p.rule
+}
+
 // 节点处理
-func (p *Executor) handleNode() {
-	go func(sign chan os.Signal) {
-		delayCheck := time.NewTicker(time.Minute * 5)
-		defer delayCheck.Stop()
+func (p *Executor) handleNode(ctx context.Context) {
+	defer p.loops.Done()
 
-		speedCheck := time.NewTicker(time.Hour)
-		defer speedCheck.Stop()
+	_, interval := p.HealthCheck()
+	delayCheck := time.NewTicker(interval)
+	defer delayCheck.Stop()
 
-		for {
-			select {
-			case <-delayCheck.C:
-				proxies := p.cloneProxyList()
-				log.Infof("check delay for %d proxies", len(proxies))
+	speedCheck := time.NewTicker(time.Hour)
+	defer speedCheck.Stop()
 
-				proxies.Each(p.checkDelay)
+	for {
+		select {
+		case <-delayCheck.C:
+			proxies := p.cloneProxyList()
+			log.Infof("check delay for %d proxies", len(proxies))
 
-				p.proxySort()
-				delayCheck.Reset(time.Minute * 5)
-			case <-speedCheck.C:
-				proxies := p.cloneProxyList()
-				log.Infof("check spped for %d proxies", len(proxies))
+			if !p.sweep(ctx, proxies, p.checkDelay) {
+				continue
+			}
+
+			p.proxySort()
+			_ = p.SaveState()
 
-				proxies.Each(p.checkSpeed)
+			_, interval = p.HealthCheck()
+			delayCheck.Reset(interval)
+		case <-speedCheck.C:
+			proxies := p.cloneProxyList()
+			log.Infof("check spped for %d proxies", len(proxies))
 
-				p.proxySort()
-				speedCheck.Reset(time.Minute * 5)
-			case e := <-p.event:
+			if !p.sweep(ctx, proxies, p.checkSpeed) {
+				continue
+			}
+
+			p.proxySort()
+			_ = p.SaveState()
+			speedCheck.Reset(time.Minute * 5)
+		case <-p.eventNotify:
+			for _, e := range p.popEvents() {
 				switch e.eventType {
 				case eventCheckDelay:
 					n := e.node
 					log.Infof("load new node %s[%s]", n.Name(), n.UniqueId())
 
-					p.checkDelay(n)
+					p.checkDelay(ctx, n)
 
 					p.proxySort()
 				case eventCleanDead:
 					p.cleanDeadNode()
+				case eventHealthCheck:
+					_, interval = p.HealthCheck()
+					delayCheck.Reset(interval)
 				}
-
-			case <-sign:
-				return
 			}
+
+		case <-ctx.Done():
+			_ = p.SaveState()
+			return
 		}
-	}(utils.GetExitSign())
+	}
 }
 
-func (p *Executor) checkDelay(proxy adapter.AdapterProxy) {
+// sweep 依次检测节点，ctx 取消时中止并返回 false
+func (p *Executor) sweep(ctx context.Context, proxies adapter.ProxyList, check func(ctx context.Context, proxy adapter.AdapterProxy)) bool {
+	for _, proxy := range proxies {
+		if ctx.Err() != nil {
+			return false
+		}
+		check(ctx, proxy)
+	}
+	return ctx.Err() == nil
+}
+
+func (p *Executor) checkDelay(ctx context.Context, proxy adapter.AdapterProxy) {
 	log.Infof("check delay for %s", proxy.Name())
-	delay, err := proxy.URLTest(context.TODO(), "https://www.google.com")
+
+	testUrl, _ := p.HealthCheck()
+	delay, err := proxy.URLTest(ctx, testUrl)
+	// 停止时中断的检测不代表节点不可用
+	if ctx.Err() != nil {
+		return
+	}
+	defer proxy.Del(Suspect)
+
 	if err != nil {
 		proxy.Store(Alive, false)
+		proxy.RecordDelay(adapter.DelayRecord{Time: time.Now()})
 		log.Debugf("err:%v", err)
-		p.onDelayCheck(proxy, -1)
+		p.publishNode(event.NodeDelayChecked, proxy)
 	} else {
 		proxy.Store(Alive, true)
 		proxy.Store(Delay, delay)
+		proxy.RecordDelay(adapter.DelayRecord{Time: time.Now(), Delay: delay})
 		log.Infof("%s delay:%dms", proxy.Name(), delay)
-		p.onDelayCheck(proxy, time.Duration(delay)*time.Millisecond)
+		p.publishNode(event.NodeDelayChecked, proxy)
+
+		p.enrichGeo(proxy)
 	}
 }
 
-func (p *Executor) checkSpeed(proxy adapter.AdapterProxy) {
+func (p *Executor) checkSpeed(ctx context.Context, proxy adapter.AdapterProxy) {
 	log.Infof("check speed for %s", proxy.Name())
 
 	const bodySize = 1024 * 1024
 	body := make([]byte, bodySize) // 1024*128
 
-	err := proxy.DoRequest(http.MethodGet, "http://cachefly.cachefly.net/50mb.test", nil, time.Minute, map[string]string{}, func(resp *http.Response, start time.Time) error {
+	err := proxy.DoRequestContext(ctx, http.MethodGet, "http://cachefly.cachefly.net/50mb.test", nil, time.Minute, map[string]string{}, func(resp *http.Response, start time.Time) error {
 		_, err := resp.Body.Read(body)
 		if err != nil {
 			log.Errorf("err:%v", err)
@@ -205,6 +594,7 @@ func (p *Executor) checkSpeed(proxy adapter.AdapterProxy) {
 
 		if speed > 0 {
 			proxy.Store(Speed, speed/1024)
+			adapter.AppendHistory(proxy, adapter.CacheSpeedHistory, adapter.SpeedRecord{Time: time.Now(), Speed: speed / 1024})
 		}
 
 		var speedStr string
@@ -225,24 +615,24 @@ func (p *Executor) checkSpeed(proxy adapter.AdapterProxy) {
 
 		return nil
 	})
+	if ctx.Err() != nil {
+		return
+	}
 	if err != nil {
 		log.Errorf("err:%v", err)
 		proxy.Store(Speed, -1)
 		proxy.Store(SpeedStr, "0bps")
+		adapter.AppendHistory(proxy, adapter.CacheSpeedHistory, adapter.SpeedRecord{Time: time.Now(), Speed: -1})
 	}
+
+	p.publishNode(event.NodeSpeedMeasured, proxy)
 }
 
 func (p *Executor) proxySort() {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	p.allProxy = p.allProxy.
-		SortUsing(func(a, b adapter.AdapterProxy) bool {
-			return a.LoadUint16(Delay) > b.LoadUint16(Delay)
-		}).
-		SortStableUsing(func(a, b adapter.AdapterProxy) bool {
-			return a.LoadFloat64(Speed) > b.LoadFloat64(Speed)
-		})
+	p.allProxy = rankProxyList(p.allProxy, p.weight)
 
 	p.aliveProxy = p.allProxy.Filter(func(proxy adapter.AdapterProxy) bool {
 		return proxy.LoadBool(Alive)
@@ -291,30 +681,144 @@ func (p *Executor) AddNode(n adapter.AdapterProxy) {
 }
 
 func (p *Executor) addNode(n adapter.AdapterProxy) {
-	n.Store(Delay, 0)
-	n.Store(Speed, 0)
-	n.Store(SpeedStr, "wait")
-
 	p.lock.Lock()
 
-	existed := p.aliveProxy.Any(func(value adapter.AdapterProxy) bool {
+	// 已有的节点保留原来的状态，不重复测速
+	existed := p.allProxy.Any(func(value adapter.AdapterProxy) bool {
 		return value.UniqueId() == n.UniqueId()
 	})
 
 	if !existed {
+		n.Store(Delay, 0)
+		n.Store(Speed, 0)
+		n.Store(SpeedStr, "wait")
+		if _, err := n.Load(adapter.CacheAddedAt); err != nil {
+			n.Store(adapter.CacheAddedAt, time.Now().Unix())
+		}
+
 		p.allProxy = append(p.allProxy, n)
+		p.watchNode(n)
+		p.setDialer(n)
+		n.SetTlsConfig(p.tlsConfig)
 	}
 
 	p.lock.Unlock()
 
-	p.onNodeAdd(n)
+	if existed {
+		return
+	}
+
+	// 跳板可能还没有加入，只提示不拒绝
+	if _, err := p.DialerChain(n.UniqueId()); err != nil {
+		log.Warnf("node %s dialer proxy: %v", n.Name(), err)
+	}
 
-	if !existed {
-		p.event <- executorEvent{
-			eventType: eventCheckDelay,
-			node:      n,
+	p.publishNode(event.NodeAdded, n)
+
+	p.emit(executorEvent{
+		eventType: eventCheckDelay,
+		node:      n,
+	})
+}
+
+// RemoveNode 删除节点，正在使用该节点的连接不受影响
+func (p *Executor) RemoveNode(uniqueId string) bool {
+	var removed adapter.AdapterProxy
+	p.lock.Lock()
+	p.allProxy = p.allProxy.Filter(func(proxy adapter.AdapterProxy) bool {
+		if proxy.UniqueId() != uniqueId {
+			return true
 		}
+		removed = proxy
+		return false
+	})
+	p.aliveProxy = p.aliveProxy.Filter(func(proxy adapter.AdapterProxy) bool {
+		return proxy.UniqueId() != uniqueId
+	})
+	if cancel, ok := p.unsubscribe[uniqueId]; ok {
+		cancel()
+		delete(p.unsubscribe, uniqueId)
+	}
+	p.breakers.remove(uniqueId)
+	p.lock.Unlock()
+
+	if removed == nil {
+		return false
+	}
+
+	p.publishNode(event.NodeRemoved, removed)
+
+	return true
+}
+
+// Nodes 所有节点，按排序后的顺序
+func (p *Executor) Nodes() adapter.ProxyList {
+	return p.cloneProxyList()
+}
+
+// SetHealthCheck 设置延迟检测的地址和间隔，为空时使用默认值
+func (p *Executor) SetHealthCheck(testUrl string, interval time.Duration) {
+	if testUrl == "" {
+		testUrl = DefaultHealthCheckUrl
 	}
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+
+	p.lock.Lock()
+	changed := p.healthCheckInterval != interval
+	p.healthCheckUrl = testUrl
+	p.healthCheckInterval = interval
+	p.lock.Unlock()
+
+	if changed {
+		p.emit(executorEvent{
+			eventType: eventHealthCheck,
+		})
+	}
+}
+
+// SetLimit 修改连接数和空闲时间的限制，已有的连接不受影响
+func (p *Executor) SetLimit(l config.Limit) {
+	p.limiter.setLimit(l)
+}
+
+func (p *Executor) ConnStats() ConnStats {
+	return p.limiter.stats()
+}
+
+func (p *Executor) HealthCheck() (string, time.Duration) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.healthCheckUrl, p.healthCheckInterval
+}
+
+// watchNode 调用方需持有 p.lock
+func (p *Executor) watchNode(n adapter.AdapterProxy) {
+	p.unsubscribe[n.UniqueId()] = n.Subscribe(func(e adapter.CacheEvent) {
+		var t event.Type
+		switch e.Key {
+		case Alive:
+			t = event.NodeAliveChanged
+		case Delay:
+			t = event.NodeDelayChanged
+		default:
+			return
+		}
+
+		if e.Old == e.New {
+			return
+		}
+
+		p.publish(t, &event.NodeChange{
+			Node:    event.NewNode(n),
+			Key:     e.Key,
+			Old:     e.Old,
+			New:     e.New,
+			Deleted: e.Deleted,
+		})
+	})
 }
 
 func (p *Executor) cleanDeadNode() {
@@ -324,146 +828,260 @@ func (p *Executor) cleanDeadNode() {
 		alive := proxy.LoadBool(Alive)
 		if !alive {
 			closeList = append(closeList, proxy)
+			if cancel, ok := p.unsubscribe[proxy.UniqueId()]; ok {
+				cancel()
+				delete(p.unsubscribe, proxy.UniqueId())
+			}
+			p.breakers.remove(proxy.UniqueId())
 		}
 		return alive
 	})
 	p.lock.Unlock()
 
 	closeList.Each(func(proxy adapter.AdapterProxy) {
-		p.onNodeDel(proxy)
+		p.publishNode(event.NodeRemoved, proxy)
 	})
 }
 
 func (p *Executor) CleanDeadNodes() {
-	p.event <- executorEvent{
+	p.emit(executorEvent{
 		eventType: eventCleanDead,
-	}
+	})
 }
 
 func (p *Executor) ChooseProxy() adapter.AdapterProxy {
+	return p.ChooseProxyFor(nil)
+}
+
+// ChooseProxyFor 按选择策略为连接选一个可用节点，exclude 中的节点不参与选择
+func (p *Executor) ChooseProxyFor(metadata *constant.Metadata, exclude ...string) adapter.AdapterProxy {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
-	if len(p.aliveProxy) > 0 {
-		for _, proxy := range p.aliveProxy {
-			if proxy.LoadBool(Alive) {
-				return proxy
+	return p.choose(p.usableProxy(exclude...), metadata)
+}
+
+// usableProxy 存活且没有熔断的节点，按排序，调用方需持有 p.lock
+func (p *Executor) usableProxy(exclude ...string) adapter.ProxyList {
+	return p.aliveProxy.Filter(func(proxy adapter.AdapterProxy) bool {
+		if !proxy.LoadBool(Alive) {
+			return false
+		}
+		if !p.breakers.ready(proxy.UniqueId()) {
+			return false
+		}
+		for _, id := range exclude {
+			if proxy.UniqueId() == id {
+				return false
 			}
 		}
+		return true
+	})
+}
+
+// choose 调用方需持有 p.lock
+func (p *Executor) choose(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy {
+	if node, ok := p.selectFromGroups(nodes, metadata); ok {
+		return node
 	}
 
-	return nil
+	return p.selector.Select(nodes, metadata)
 }
 
-// 监听端口
-func (p *Executor) handleConn() {
-	relay := func(l, r net.Conn) {
-		go func() {
-			_, _ = io.Copy(l, r)
-		}()
-		_, _ = io.Copy(r, l)
+// acquire 通知选择策略有连接使用了该节点
+func (p *Executor) acquire(cc constant.ProxyAdapter) func() {
+	node, ok := cc.(adapter.AdapterProxy)
+	if !ok {
+		return func() {}
 	}
 
-	go func(sign chan os.Signal) {
-		defer func() {
-			if p.service != nil {
-				p.service.Close()
+	// 分组各自的策略都需要知道节点的连接数
+	var releases []func()
+	p.lock.RLock()
+	selectors := []selector.Selector{p.selector}
+	for _, g := range p.groups {
+		selectors = append(selectors, g.Selector)
+	}
+	for _, s := range selectors {
+		if tracker, ok := s.(selector.Tracker); ok {
+			releases = append(releases, tracker.Acquire(node))
+		}
+	}
+	p.lock.RUnlock()
+
+	return func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+}
+
+// 监听端口
+func (p *Executor) handleConn(ctx context.Context) {
+	defer p.loops.Done()
+
+	defer func() {
+		p.lock.Lock()
+		if p.service != nil {
+			_ = p.service.Close()
+			p.service = nil
+		}
+		p.closeInbounds()
+		p.lock.Unlock()
+		log.Warn("stop service")
+	}()
+
+	direct := outbound.NewDirect()
+	reject := outbound.NewReject()
+
+	for {
+		select {
+		case c := <-p.connChan:
+			release, err := p.limiter.acquire(c.Metadata().SrcIP)
+			if err != nil {
+				log.Warnf("reject %s: %v", c.Metadata().SourceAddress(), err)
+				_ = c.Conn().Close()
+				continue
 			}
-			log.Warn("stop service")
-		}()
 
-		direct := outbound.NewDirect()
-		reject := outbound.NewReject()
-
-		for {
-			select {
-			case c := <-p.connChan:
-				go func(conn constant.ConnContext) {
-					log.SetTrace(conn.ID().String())
-					defer log.DelTrace()
-
-					defer utils.CachePanic()
-
-					metadata := conn.Metadata()
-
-					// key := "adapter.dmain." + metadata.String()
-
-					var cc constant.ProxyAdapter
-
-					switch p.rule.Match(metadata) {
-					case adapter.Proxy:
-						cc = p.ChooseProxy()
-						if cc == nil {
-							log.Warn("not found usable proxy")
-							return
-						}
-
-					case adapter.Reject:
-						cc = reject
-					default:
-						cc = direct
-					}
-
-					log.Infof("try to connect %v ues proxy %v-%v", metadata.RemoteAddress(),
-						adapter.CoverAdapterType(cc.Type()), cc.Name())
-
-					remote, err := cc.DialContext(context.TODO(), metadata)
-					if err != nil {
-						log.Errorf("err:%v", err)
-
-						if adapter.CoverAdapterType(cc.Type()) == adapter.Reject {
-							return
-						}
-
-						cc = p.ChooseProxy()
-						if cc == nil {
-							log.Warn("not found usable proxy")
-							return
-						}
-
-						log.Infof("try to connect %v ues proxy %v-%v", metadata.RemoteAddress(), adapter.CoverAdapterType(cc.Type()), cc.Name())
-
-						remote, err = cc.DialContext(context.TODO(), metadata)
-						if err != nil {
-							log.Errorf("err:%v", err)
-							return
-						}
-
-						if metadata.DstIP != nil {
-							r, err := rule.NewSrcIp(metadata.DstIP.String(), adapter.CoverAdapterType(cc.Type()))
-							if err != nil {
-								log.Errorf("err:%v", err)
-							} else {
-								p.rule.AddRule(r)
-							}
-						}
-
-						if metadata.Host != metadata.DstIP.String() {
-							r, err := rule.NewDomain(metadata.Host, adapter.CoverAdapterType(cc.Type()))
-							if err != nil {
-								log.Errorf("err:%v", err)
-							} else {
-								p.rule.AddRule(r)
-							}
-						}
-					}
-
-					log.Infof("%s use %v-%s", metadata.RemoteAddress(), cc.Type(), cc.Name())
-
-					// packet, err := cc.ListenPacketContext(ctx, metadata)
-					// if err != nil {
-					//	log.Errorf("err:%v", err)
-					//	return
-					// }
-
-					relay(remote, conn.Conn())
-				}(c)
-
-			case <-sign:
+			p.relays.Add(1)
+			go func() {
+				defer p.relays.Done()
+				defer release()
+				p.serveConn(ctx, c, direct, reject)
+			}()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Executor) serveConn(ctx context.Context, conn constant.ConnContext, direct, reject constant.ProxyAdapter) {
+	log.SetTrace(conn.ID().String())
+	defer log.DelTrace()
+
+	defer utils.CachePanic()
+	defer conn.Conn().Close()
+
+	metadata := conn.Metadata()
+
+	// key := "adapter.dmain." + metadata.String()
+
+	var cc constant.ProxyAdapter
+	var remote net.Conn
+	var err error
+
+	attempts := p.Failover().Attempts
+
+	switch p.rule.Match(metadata) {
+	case adapter.Proxy:
+		remote, cc, err = p.dialFailover(ctx, metadata, p.candidates(metadata, attempts))
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return
+		}
+
+	case adapter.Reject:
+		remote, err = reject.DialContext(ctx, metadata)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return
+		}
+		cc = reject
+
+	default:
+		remote, _, err = p.dialFailover(ctx, metadata, []constant.ProxyAdapter{direct})
+		if err != nil {
+			log.Errorf("err:%v", err)
+
+			// 直连失败，改走代理
+			remote, cc, err = p.dialFailover(ctx, metadata, p.candidates(metadata, attempts))
+			if err != nil {
+				log.Errorf("err:%v", err)
 				return
 			}
+
+			p.learn(metadata, adapter.CoverAdapterType(cc.Type()))
+		} else {
+			cc = direct
+		}
+	}
+
+	log.Infof("%s use %v-%s", metadata.RemoteAddress(), cc.Type(), cc.Name())
+
+	// packet, err := cc.ListenPacketContext(ctx, metadata)
+	// if err != nil {
+	//	log.Errorf("err:%v", err)
+	//	return
+	// }
+
+	release := p.acquire(cc)
+	defer release()
+
+	untrack := p.trackConn(remote, conn.Conn())
+	defer untrack()
+
+	info := &event.Conn{
+		Id:      conn.ID().String(),
+		Network: metadata.NetWork.String(),
+		Source:  metadata.SourceAddress(),
+		Target:  metadata.RemoteAddress(),
+		Adapter: adapter.CoverAdapterType(cc.Type()).String(),
+		Proxy:   cc.Name(),
+	}
+	p.publish(event.ConnOpened, info)
+
+	// 收到第一个字节时就记录成功，半开状态的试探不需要等到连接结束
+	first := newFirstReadConn(remote, func() {
+		p.circuitDone(cc, outcomeSuccess, metadata.RemoteAddress())
+	})
+
+	start := time.Now()
+	up, down := relay(first, conn.Conn(), p.limiter.idleTimeout())
+	if !first.read.Load() {
+		p.circuitDone(cc, relayOutcome(up, down), metadata.RemoteAddress())
+	}
+
+	closed := *info
+	closed.Duration = time.Since(start)
+	closed.Upload, closed.Download = up, down
+	p.publish(event.ConnClosed, &closed)
+}
+
+// learn 直连失败改走代理成功后，记住目标的出口
+func (p *Executor) learn(metadata *constant.Metadata, at adapter.AdapterType) {
+	var rules []adapter.Rule
+	if metadata.DstIP.IsValid() {
+		r, err := rule.NewDstIp(metadata.DstIP.String(), at)
+		if err != nil {
+			log.Errorf("err:%v", err)
+		} else {
+			rules = append(rules, r)
+		}
+	}
+
+	if metadata.Host != "" && metadata.Host != metadata.DstIP.String() {
+		r, err := rule.NewDomain(metadata.Host, at)
+		if err != nil {
+			log.Errorf("err:%v", err)
+		} else {
+			rules = append(rules, r)
 		}
-	}(utils.GetExitSign())
+	}
+
+	if len(rules) == 0 {
+		return
+	}
+
+	p.rule.Learn(rules...)
+	p.rule.Sync()
+
+	for _, r := range rules {
+		info := r.Export()
+		info.Learned = true
+		p.publish(event.RuleAdded, info)
+	}
 }
 
 func (p *Executor) Listen(port string) error {
@@ -496,10 +1114,22 @@ func (p *Executor) Listen(port string) error {
 	return nil
 }
 
+// Addr 实际监听的地址，未监听时为空
+func (p *Executor) Addr() string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.service == nil {
+		return ""
+	}
+
+	return p.service.Address()
+}
+
 func (p *Executor) match(metadata *constant.Metadata) {
 	srcPort, err := strconv.Atoi(metadata.SrcPort)
 	if err == nil {
-		path, err := P.FindProcessName(metadata.NetWork.String(), metadata.SrcIP, srcPort)
+		_, path, err := P.FindProcessName(metadata.NetWork.String(), metadata.SrcIP, srcPort)
 		if err != nil {
 			log.Debugf("[Process] find process %s: %v", metadata.String(), err)
 		} else {
@@ -1,25 +1,475 @@
 package executor
 
 import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/Dreamacro/clash/constant"
 	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/hub/event"
+	"github.com/darabuchi/nico/hub/rule"
+	"github.com/darabuchi/nico/internal/testutil"
 )
 
+// dialSocks5 通过 socks5 代理连接 target
+func dialSocks5(t *testing.T, proxy, target string) net.Conn {
+	conn, err := socks5Connect(proxy, target)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	return conn
+}
+
+func socks5Connect(proxy, target string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxy, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	host, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+	ip := net.ParseIP(host).To4()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	_, err = conn.Write([]byte{5, 1, 0})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	buf := make([]byte, 10)
+	if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	req := append([]byte{5, 1, 0, 1}, ip...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if buf[1] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 connect fail, rep %d", buf[1])
+	}
+
+	return conn, nil
+}
+
+func startExecutor(t *testing.T) *Executor {
+	ex := NewExecutor()
+
+	err := ex.Start(context.Background())
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	err = ex.Listen("0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	return ex
+}
+
+func ping(t *testing.T, conn net.Conn) error {
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	_, err := conn.Write([]byte("ping"))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		return err
+	}
+	if string(buf) != "ping" {
+		t.Errorf("got %q, want ping", buf)
+	}
+	return nil
+}
+
 func TestExecutor(t *testing.T) {
 	log.SetLevel(log.InfoLevel)
+
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	// 同一进程中的多个 executor 互不影响
+	a := startExecutor(t)
+	b := startExecutor(t)
+
+	if err := a.Start(context.Background()); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("start twice got %v, want %v", err, ErrAlreadyStarted)
+	}
+
+	_, aPort, _ := net.SplitHostPort(a.Addr())
+	_, bPort, _ := net.SplitHostPort(b.Addr())
+	if aPort == bPort {
+		t.Fatalf("executors listen on same port %s", aPort)
+	}
+
+	connA := dialSocks5(t, net.JoinHostPort("127.0.0.1", aPort), echo.Addr().String())
+	defer connA.Close()
+	connB := dialSocks5(t, net.JoinHostPort("127.0.0.1", bPort), echo.Addr().String())
+
+	if err := ping(t, connA); err != nil {
+		t.Errorf("err:%v", err)
+	}
+	if err := ping(t, connB); err != nil {
+		t.Errorf("err:%v", err)
+	}
+
+	// 连接结束后可以正常关闭
+	connB.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Errorf("err:%v", err)
+	}
+	b.Wait()
+
+	if _, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", bPort), time.Second); err == nil {
+		t.Errorf("listener not closed after shutdown")
+	}
+
+	// b 关闭后 a 不受影响
+	if err := ping(t, connA); err != nil {
+		t.Errorf("err:%v", err)
+	}
+
+	// 还有连接时等到超时后强制关闭
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := a.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("shutdown got %v, want %v", err, context.DeadlineExceeded)
+	}
+	a.Wait()
+
+	if err := ping(t, connA); err == nil {
+		t.Errorf("conn not closed after shutdown timeout")
+	}
+}
+
+// TestExecutor_ShutdownDuringCheck 关闭时中止进行中的健康检查，之后可以再次启动
+func TestExecutor_ShutdownDuringCheck(t *testing.T) {
+	// 只接受连接、从不响应的 http 服务端
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer silent.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	socks := testutil.NewSocks5Server(t)
+	defer socks.Close()
+
+	ex := NewExecutor()
+	ex.SetHealthCheck("http://"+silent.Addr().String()+"/", time.Hour)
+	ex.AddNode(socks.Node(t, "silent", ""))
+
+	if err = ex.Start(context.Background()); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(time.Second * 3):
+		t.Fatalf("health check not started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+
+	start := time.Now()
+	if err = ex.Shutdown(ctx); err != nil {
+		t.Errorf("err:%v", err)
+	}
+	if d := time.Since(start); d > time.Millisecond*300 {
+		t.Errorf("shutdown took %v", d)
+	}
+
+	if err = ex.Start(context.Background()); err != nil {
+		t.Errorf("restart got %v", err)
+	}
+	if err = ex.Shutdown(context.Background()); err != nil {
+		t.Errorf("err:%v", err)
+	}
+}
+
+func TestExecutor_AddNodeBeforeStart(t *testing.T) {
 	ex := NewExecutor()
-	err := ex.Listen("7891")
+
+	if err := ex.Shutdown(context.Background()); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("shutdown got %v, want %v", err, ErrNotStarted)
+	}
+
+	// 启动前添加的节点不会阻塞
+	for i := 0; i < 10; i++ {
+		n, err := adapter.ParseClash(map[string]any{"name": "t", "type": "trojan", "server": "127.0.0.1", "port": 1 + i, "password": "pass"})
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		ex.AddNode(n)
+	}
+
+	if got := len(ex.cloneProxyList()); got != 10 {
+		t.Errorf("got %d nodes, want 10", got)
+	}
+}
+
+func TestExecutor_Events(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	ex := startExecutor(t)
+	defer ex.Shutdown(context.Background())
+
+	sub := ex.Events().Subscribe(0, event.NodeAdded, event.NodeRemoved, event.ConnOpened, event.ConnClosed)
+	defer sub.Close()
+
+	// 多次注册的回调都会被调用
+	added := make(chan string, 2)
+	ex.OnNodeAdd(func(node adapter.AdapterProxy) { added <- "a:" + node.Name() })
+	ex.OnNodeAdd(func(node adapter.AdapterProxy) { added <- "b:" + node.Name() })
+
+	n, err := adapter.ParseClash(map[string]any{"name": "t", "type": "trojan", "server": "127.0.0.1", "port": 1, "password": "pass"})
 	if err != nil {
-		log.Errorf("err:%v", err)
-		return
+		t.Fatalf("err:%v", err)
+	}
+	ex.AddNode(n)
+	ex.RemoveNode(n.UniqueId())
+
+	_, port, _ := net.SplitHostPort(ex.Addr())
+	conn := dialSocks5(t, net.JoinHostPort("127.0.0.1", port), echo.Addr().String())
+	if err = ping(t, conn); err != nil {
+		t.Errorf("err:%v", err)
+	}
+	conn.Close()
+
+	for _, want := range []event.Type{event.NodeAdded, event.NodeRemoved, event.ConnOpened, event.ConnClosed} {
+		select {
+		case e := <-sub.Events():
+			if e.Type != want {
+				t.Errorf("got %s, want %s", e.Type, want)
+			}
+			if c, ok := e.Data.(*event.Conn); ok && c.Target != echo.Addr().String() {
+				t.Errorf("conn target got %s", c.Target)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting %s", want)
+		}
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-added:
+			got[s] = true
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+	if !got["a:t"] || !got["b:t"] {
+		t.Errorf("callbacks got %v", got)
+	}
+}
+
+type geoResolver map[string]*adapter.GeoInfo
+
+func (p geoResolver) Lookup(ip net.IP) (*adapter.GeoInfo, error) {
+	if info, ok := p[ip.String()]; ok {
+		return info, nil
+	}
+	return nil, adapter.ErrGeoNotFound
+}
+
+// TestExecutor_EnrichGeo 补全地区信息时请求出口 ip 较慢，不能阻塞测速
+func TestExecutor_EnrichGeo(t *testing.T) {
+	release := make(chan struct{})
+	echoIp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = w.Write([]byte("203.0.113.7"))
+	}))
+	defer echoIp.Close()
+	defer close(release)
+
+	check := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer check.Close()
+
+	ex := NewExecutor()
+	ex.healthCheckUrl = check.URL
+
+	geo := adapter.NewGeoEnricher(geoResolver{
+		"203.0.113.7":  {Country: "Japan", CountryCode: "JP"},
+		"198.51.100.1": {Country: "United States", CountryCode: "US"},
+	})
+	geo.EchoUrl = echoIp.URL
+	geo.LookupHost = func(host string) ([]string, error) {
+		return []string{"198.51.100.1"}, nil
 	}
+	ex.SetGeoEnricher(geo)
 
-	err = ex.AddNodeByV2rayLink("trojan://nykzeZ-virza6-kagmyf@teoeu.com:443#ShareCentre")
+	n, err := adapter.NewProxyAdapter(adapter.NewProxyDirect(), map[string]any{
+		"type":   "direct",
+		"server": "direct.example.com",
+	})
 	if err != nil {
-		log.Errorf("err:%v", err)
-		return
+		t.Fatalf("err:%v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ex.checkDelay(context.Background(), n)
+		// 补全进行中时再次测速不会重复请求
+		ex.checkDelay(context.Background(), n)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("check delay blocked by geo enrich")
+	}
+
+	if !n.LoadBool(Alive) || n.GetExtraInfo().CountryCode != "" {
+		t.Errorf("got alive %v, extra %+v", n.LoadBool(Alive), n.GetExtraInfo())
+	}
+
+	release <- struct{}{}
+
+	deadline := time.Now().Add(time.Second * 3)
+	for n.GetExtraInfo().CountryCode == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	info := n.GetExtraInfo()
+	if info.CountryCode != "JP" || info.ExitCountryCode != "JP" || info.ServerCountryCode != "US" {
+		t.Errorf("got %+v", info)
+	}
+}
+
+// TestExecutor_Isolated 同一进程中的两个 Executor 使用各自的证书配置和规则
+func TestExecutor_Isolated(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	caPem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	trusted := NewExecutor(WithTls(adapter.TlsOption{CaPem: caPem}), WithAdapterRule(rule.NewMemoryAdapterRule()))
+	other := NewExecutor(WithAdapterRule(rule.NewMemoryAdapterRule()))
+
+	node := func(ex *Executor) adapter.AdapterProxy {
+		n, err := adapter.NewProxyAdapter(adapter.NewProxyDirect(), map[string]any{
+			"type":   "direct",
+			"server": "direct.example.com",
+		})
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		ex.AddNode(n)
+		return n
+	}
+
+	if _, err := node(trusted).Get(srv.URL, time.Second*3, nil); err != nil {
+		t.Errorf("err:%v", err)
+	}
+	if _, err := node(other).Get(srv.URL, time.Second*3, nil); err == nil {
+		t.Errorf("ca of another executor should not be trusted")
+	}
+
+	metadata := &constant.Metadata{Host: "isolated.example.com", AddrType: constant.AtypDomainName}
+	trusted.learn(metadata, adapter.Proxy)
+
+	if at := trusted.AdapterRule().Match(metadata); at != adapter.Proxy {
+		t.Errorf("got %s, want %s", at, adapter.Proxy)
+	}
+	for _, ar := range []*rule.AdapterRule{other.AdapterRule(), rule.GetAdapterRule()} {
+		if at := ar.Match(metadata); at != adapter.Direct {
+			t.Errorf("learned rule leaked, got %s", at)
+		}
+	}
+}
+
+// TestExecutor_Hooks 回调处理慢时事件排队，不会丢弃
+func TestExecutor_Hooks(t *testing.T) {
+	ex := NewExecutor()
+
+	const count = event.DefaultBufferSize * 3
+
+	release := make(chan struct{})
+	added := make(chan string, count)
+	cancel := ex.OnNodeAdd(func(node adapter.AdapterProxy) {
+		<-release
+		added <- node.Name()
+	})
+	defer cancel()
+
+	var nodes []adapter.AdapterProxy
+	for i := 0; i < count; i++ {
+		n, err := adapter.ParseClash(map[string]any{"name": strconv.Itoa(i), "type": "trojan", "server": "127.0.0.1", "port": i + 1, "password": "pass"})
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		ex.AddNode(n)
+		nodes = append(nodes, n)
 	}
+	close(release)
 
-	select {}
+	for i := 0; i < count; i++ {
+		select {
+		case name := <-added:
+			if name != strconv.Itoa(i) {
+				t.Fatalf("got %s, want %d", name, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %d callbacks, want %d", i, count)
+		}
+	}
+
+	changes := make(chan adapter.CacheEvent, 10)
+	cancelChange := ex.OnNodeChange(func(node adapter.AdapterProxy, e adapter.CacheEvent) {
+		changes <- e
+	})
+	defer cancelChange()
+
+	nodes[0].Store(Delay, uint16(120))
+
+	select {
+	case e := <-changes:
+		if e.Key != Delay || e.New != uint16(120) {
+			t.Errorf("got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no delay change")
+	}
 }
@@ -0,0 +1,208 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+)
+
+var ErrNoProxy = errors.New("not found usable proxy")
+
+// SetFailover 修改建立连接失败时的重试策略
+func (p *Executor) SetFailover(f config.Failover) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.failover = f
+}
+
+func (p *Executor) Failover() config.Failover {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.failover
+}
+
+// candidates 按选择策略选出首选节点，其余按分组顺序和节点排序补足，最多 n 个
+// 选择策略只调用一次，轮询、最低延迟等策略的状态不会被备用节点改变
+func (p *Executor) candidates(metadata *constant.Metadata, n int, exclude ...string) []constant.ProxyAdapter {
+	if n <= 0 {
+		n = 1
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	nodes := p.usableProxy(exclude...)
+
+	primary := p.choose(nodes, metadata)
+	if primary == nil {
+		return nil
+	}
+
+	// 与 ChooseProxyFor 一致，先用前面分组中的节点，最后是全部节点
+	var ordered adapter.ProxyList
+	for _, g := range p.groups {
+		ordered = append(ordered, nodes.Filter(g.match)...)
+	}
+	ordered = append(ordered, nodes...)
+
+	list := []constant.ProxyAdapter{primary}
+	seen := map[string]bool{primary.UniqueId(): true}
+	for _, node := range ordered {
+		if len(list) >= n {
+			break
+		}
+		if seen[node.UniqueId()] {
+			continue
+		}
+		seen[node.UniqueId()] = true
+		list = append(list, node)
+	}
+
+	return list
+}
+
+type dialResult struct {
+	conn net.Conn
+	cc   constant.ProxyAdapter
+	err  error
+}
+
+// DialErrors 所有尝试都失败时每个节点的错误
+type DialErrors []error
+
+func (e DialErrors) Error() string {
+	var b []string
+	for _, err := range e {
+		b = append(b, err.Error())
+	}
+	return strings.Join(b, "; ")
+}
+
+// dialFailover 依次尝试 candidates，返回最先建立的连接
+// 上一个尝试失败或超过 stagger 还没有结果时开始下一个，之后先连上的胜出，其余的取消
+func (p *Executor) dialFailover(ctx context.Context, metadata *constant.Metadata, candidates []constant.ProxyAdapter) (net.Conn, constant.ProxyAdapter, error) {
+	if len(candidates) == 0 {
+		return nil, nil, ErrNoProxy
+	}
+
+	f := p.Failover()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(candidates))
+
+	var next, running int
+	start := func() {
+		cc := candidates[next]
+		next++
+		running++
+
+		// 选出后到连接前节点可能被熔断
+		if node, ok := cc.(adapter.AdapterProxy); ok && !p.breakers.acquire(node.UniqueId()) {
+			results <- dialResult{cc: cc, err: ErrCircuitOpen}
+			return
+		}
+
+		log.Infof("try to connect %v ues proxy %v-%v", metadata.RemoteAddress(),
+			adapter.CoverAdapterType(cc.Type()), cc.Name())
+
+		go func() {
+			dialCtx := ctx
+			if f.Timeout > 0 {
+				var dialCancel context.CancelFunc
+				dialCtx, dialCancel = context.WithTimeout(ctx, f.Timeout)
+				defer dialCancel()
+			}
+
+			conn, err := cc.DialContext(dialCtx, metadata)
+			results <- dialResult{conn: conn, cc: cc, err: err}
+		}()
+	}
+
+	var stagger <-chan time.Time
+	startNext := func() {
+		stagger = nil
+		if next >= len(candidates) {
+			return
+		}
+		start()
+		if f.Stagger > 0 && next < len(candidates) {
+			stagger = time.After(f.Stagger)
+		}
+	}
+
+	startNext()
+
+	var errs DialErrors
+	for running > 0 {
+		select {
+		case r := <-results:
+			running--
+
+			if r.err == nil {
+				// 其余的尝试取消，已经连上的关闭
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						r := <-results
+						if r.conn != nil {
+							_ = r.conn.Close()
+						}
+						if r.err != ErrCircuitOpen {
+							p.circuitDone(r.cc, outcomeNone, metadata.RemoteAddress())
+						}
+					}
+				}(running)
+
+				return r.conn, r.cc, nil
+			}
+
+			log.Errorf("dial %s via %s err:%v", metadata.RemoteAddress(), r.cc.Name(), r.err)
+			errs = append(errs, fmt.Errorf("%s: %w", r.cc.Name(), r.err))
+
+			if r.err != ErrCircuitOpen {
+				if ctx.Err() != nil {
+					p.circuitDone(r.cc, outcomeNone, metadata.RemoteAddress())
+				} else {
+					// 熔断只计入节点自身的错误，检测一次的代价很小，不区分
+					p.circuitDone(r.cc, dialOutcome(r.err), metadata.RemoteAddress())
+					if node, ok := r.cc.(adapter.AdapterProxy); ok {
+						p.markSuspect(node)
+					}
+				}
+			}
+
+			startNext()
+
+		case <-stagger:
+			startNext()
+		}
+	}
+
+	return nil, nil, errs
+}
+
+// markSuspect 节点建立连接失败，不等下一次定时检测，尽快单独检测一次
+func (p *Executor) markSuspect(node adapter.AdapterProxy) {
+	if !node.CompareAndSwap(Suspect, nil, true) {
+		// 已经在等待检测
+		return
+	}
+
+	log.Warnf("node %s[%s] is suspect", node.Name(), node.UniqueId())
+
+	p.emit(executorEvent{
+		eventType: eventCheckDelay,
+		node:      node,
+	})
+}
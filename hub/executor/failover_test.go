@@ -0,0 +1,333 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/component/dialer"
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub/selector"
+	"go.uber.org/atomic"
+)
+
+// dialProxy 只实现建立连接用到的方法，delay 后返回 err，delay<0 时一直等到超时
+type dialProxy struct {
+	adapter.AdapterProxy
+
+	id    string
+	delay time.Duration
+	err   error
+	// stubborn 取消后仍然建立连接
+	stubborn bool
+
+	lock    sync.Mutex
+	suspect bool
+	dialed  *atomic.Int32
+	closed  *atomic.Int32
+}
+
+func newDialProxy(id string, delay time.Duration, err error) *dialProxy {
+	return &dialProxy{
+		id:     id,
+		delay:  delay,
+		err:    err,
+		dialed: atomic.NewInt32(0),
+		closed: atomic.NewInt32(0),
+	}
+}
+
+func (p *dialProxy) Name() string {
+	return p.id
+}
+
+func (p *dialProxy) UniqueId() string {
+	return p.id
+}
+
+func (p *dialProxy) Type() constant.AdapterType {
+	return constant.Socks5
+}
+
+func (p *dialProxy) CompareAndSwap(key string, old, new any) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if key != Suspect || (old == nil) == p.suspect {
+		return false
+	}
+	p.suspect = new == true
+	return true
+}
+
+func (p *dialProxy) LoadBool(key string) bool {
+	return false
+}
+
+func (p *dialProxy) LoadUint16(key string) uint16 {
+	return 0
+}
+
+func (p *dialProxy) LoadFloat64(key string) float64 {
+	return 0
+}
+
+func (p *dialProxy) isSuspect() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.suspect
+}
+
+func (p *dialProxy) DialContext(ctx context.Context, metadata *constant.Metadata, opts ...dialer.Option) (constant.Conn, error) {
+	p.dialed.Inc()
+
+	if p.stubborn {
+		time.Sleep(p.delay)
+	} else if p.delay >= 0 {
+		select {
+		case <-time.After(p.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	a, b := net.Pipe()
+	go func() {
+		_, _ = b.Read(make([]byte, 1))
+		p.closed.Inc()
+	}()
+	return &pipeConn{Conn: a}, nil
+}
+
+type pipeConn struct {
+	net.Conn
+}
+
+func (c *pipeConn) Chains() constant.Chain {
+	return nil
+}
+
+func (c *pipeConn) AppendToChains(constant.ProxyAdapter) {}
+
+func (c *pipeConn) RemoteDestination() string {
+	return ""
+}
+
+func TestExecutor_DialFailover(t *testing.T) {
+	errRefused := errors.New("refused")
+
+	tests := []struct {
+		name     string
+		failover config.Failover
+		nodes    []*dialProxy
+		want     string
+		wantErr  int
+		suspect  []string
+		min, max time.Duration
+	}{
+		{
+			name:     "first failed",
+			failover: config.Failover{Timeout: time.Second},
+			nodes: []*dialProxy{
+				newDialProxy("a", 0, errRefused),
+				newDialProxy("b", 0, nil),
+			},
+			want:    "b",
+			suspect: []string{"a"},
+			max:     time.Millisecond * 200,
+		},
+		{
+			name:     "timeout",
+			failover: config.Failover{Timeout: time.Millisecond * 100},
+			nodes: []*dialProxy{
+				newDialProxy("a", -1, nil),
+				newDialProxy("b", 0, nil),
+			},
+			want:    "b",
+			suspect: []string{"a"},
+			min:     time.Millisecond * 100,
+			max:     time.Millisecond * 300,
+		},
+		{
+			// 第一个节点很慢，不等它超时就开始第二个
+			name:     "stagger",
+			failover: config.Failover{Timeout: time.Second, Stagger: time.Millisecond * 50},
+			nodes: []*dialProxy{
+				newDialProxy("a", -1, nil),
+				newDialProxy("b", 0, nil),
+			},
+			want: "b",
+			min:  time.Millisecond * 50,
+			max:  time.Millisecond * 300,
+		},
+		{
+			name:     "stagger first wins",
+			failover: config.Failover{Timeout: time.Second, Stagger: time.Millisecond * 50},
+			nodes: []*dialProxy{
+				newDialProxy("a", time.Millisecond*100, nil),
+				newDialProxy("b", time.Second/2, nil),
+				newDialProxy("c", time.Second/2, nil),
+			},
+			want: "a",
+			max:  time.Millisecond * 300,
+		},
+		{
+			name:     "all failed",
+			failover: config.Failover{Timeout: time.Millisecond * 100, Stagger: time.Millisecond * 20},
+			nodes: []*dialProxy{
+				newDialProxy("a", 0, errRefused),
+				newDialProxy("b", -1, nil),
+				newDialProxy("c", time.Millisecond*10, errRefused),
+			},
+			wantErr: 3,
+			suspect: []string{"a", "b", "c"},
+			min:     time.Millisecond * 100,
+			max:     time.Millisecond * 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewExecutor(WithFailover(tt.failover))
+
+			var candidates []constant.ProxyAdapter
+			for _, node := range tt.nodes {
+				candidates = append(candidates, node)
+			}
+
+			start := time.Now()
+			conn, cc, err := p.dialFailover(context.Background(), &constant.Metadata{}, candidates)
+			elapsed := time.Since(start)
+
+			if tt.wantErr > 0 {
+				var errs DialErrors
+				if !errors.As(err, &errs) || len(errs) != tt.wantErr {
+					t.Errorf("got err %v, want %d errors", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("err:%v", err)
+			} else {
+				if cc.Name() != tt.want {
+					t.Errorf("got %s, want %s", cc.Name(), tt.want)
+				}
+				_ = conn.Close()
+			}
+
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("took %v, want [%v, %v]", elapsed, tt.min, tt.max)
+			}
+
+			var suspect []string
+			for _, node := range tt.nodes {
+				if node.isSuspect() {
+					suspect = append(suspect, node.id)
+				}
+			}
+			if len(suspect) != len(tt.suspect) {
+				t.Errorf("suspect got %v, want %v", suspect, tt.suspect)
+			}
+
+			// 每个可疑节点都会尽快检测一次
+			var checks int
+			for _, e := range p.popEvents() {
+				if e.eventType == eventCheckDelay {
+					checks++
+				}
+			}
+			if checks != len(tt.suspect) {
+				t.Errorf("got %d check events, want %d", checks, len(tt.suspect))
+			}
+		})
+	}
+}
+
+// 同时进行的尝试中，晚到的连接会被关闭
+func TestExecutor_DialFailoverCloseLate(t *testing.T) {
+	p := NewExecutor(WithFailover(config.Failover{Timeout: time.Second, Stagger: time.Millisecond * 20}))
+
+	a := newDialProxy("a", time.Millisecond*100, nil)
+	a.stubborn = true
+	b := newDialProxy("b", time.Millisecond*10, nil)
+
+	conn, cc, err := p.dialFailover(context.Background(), &constant.Metadata{}, []constant.ProxyAdapter{a, b})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer conn.Close()
+
+	if cc.Name() != "b" {
+		t.Errorf("got %s, want b", cc.Name())
+	}
+
+	// a 取消后还是连上了，连接被关闭，不会泄漏
+	time.Sleep(time.Millisecond * 200)
+	if a.dialed.Load() != 1 || a.closed.Load() != 1 {
+		t.Errorf("a dialed %d closed %d", a.dialed.Load(), a.closed.Load())
+	}
+	if a.isSuspect() {
+		t.Errorf("canceled attempt should not mark suspect")
+	}
+}
+
+func TestExecutor_MarkSuspect(t *testing.T) {
+	p := NewExecutor()
+	node := newDialProxy("a", 0, nil)
+
+	p.markSuspect(node)
+	p.markSuspect(node)
+
+	// 等待检测时不重复触发
+	if events := p.popEvents(); len(events) != 1 || events[0].eventType != eventCheckDelay {
+		t.Errorf("got %d events", len(events))
+	}
+	if !node.isSuspect() {
+		t.Errorf("node should be suspect")
+	}
+}
+
+// TestExecutor_Candidates 选择策略只用于首选节点，备用节点不改变轮询的状态
+func TestExecutor_Candidates(t *testing.T) {
+	p := NewExecutor(WithSelector(selector.NewRoundRobin(func(adapter.AdapterProxy) int { return 1 })))
+	p.SetGroups(&Group{Name: "ab", Filter: regexp.MustCompile("^[ab]$")})
+
+	for i, name := range []string{"a", "b", "c"} {
+		n, err := adapter.ParseClash(map[string]any{"name": name, "type": "trojan", "server": "127.0.0.1", "port": i + 1, "password": "pass"})
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		p.AddNode(n)
+		n.Store(Alive, true)
+		n.Store(Delay, uint16(100*(i+1)))
+	}
+	p.proxySort()
+
+	tests := []string{"a,b,c", "b,a,c", "a,b,c", "b,a,c"}
+	for _, want := range tests {
+		var got []string
+		for _, node := range p.candidates(nil, 3) {
+			got = append(got, node.Name())
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("got %v, want %s", got, want)
+		}
+	}
+
+	if got := p.candidates(nil, 3, p.ChooseProxy().UniqueId()); len(got) != 2 {
+		t.Errorf("got %d candidates, want 2", len(got))
+	}
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"regexp"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/hub/selector"
+)
+
+// Group 一组按名称筛选出的节点及其选择策略
+type Group struct {
+	Name string
+
+	// Filter 匹配节点名称，为空时包含所有节点
+	Filter *regexp.Regexp
+
+	Selector selector.Selector
+}
+
+func (g *Group) match(node adapter.AdapterProxy) bool {
+	return g.Filter == nil || g.Filter.MatchString(node.Name())
+}
+
+// SetGroups 设置节点分组，选择节点时按顺序使用第一个有可用节点的分组，都没有时回退到全部节点
+func (p *Executor) SetGroups(groups ...*Group) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.groups = groups
+}
+
+// selectFromGroups 调用方需持有 p.lock
+func (p *Executor) selectFromGroups(nodes adapter.ProxyList, metadata *constant.Metadata) (adapter.AdapterProxy, bool) {
+	for _, g := range p.groups {
+		candidates := nodes.Filter(g.match)
+		if len(candidates) == 0 {
+			continue
+		}
+
+		s := g.Selector
+		if s == nil {
+			s = p.selector
+		}
+
+		if node := s.Select(candidates, metadata); node != nil {
+			return node, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"sort"
+
+	"github.com/Dreamacro/clash/listener/mixed"
+	"github.com/darabuchi/log"
+)
+
+// AddInbound 在 addr 上增加一个 mixed 监听，已存在时不做任何事
+func (p *Executor) AddInbound(addr string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.inbounds[addr]; ok {
+		return nil
+	}
+
+	l, err := mixed.New(addr, p.connChan)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	p.inbounds[addr] = l
+
+	return nil
+}
+
+// RemoveInbound 关闭 addr 上的监听，已建立的连接不受影响
+func (p *Executor) RemoveInbound(addr string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	l, ok := p.inbounds[addr]
+	if !ok {
+		return nil
+	}
+
+	delete(p.inbounds, addr)
+
+	err := l.Close()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Inbounds 通过 AddInbound 增加的监听，key 为配置的地址，value 为实际监听的地址
+func (p *Executor) Inbounds() map[string]string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	m := make(map[string]string, len(p.inbounds))
+	for addr, l := range p.inbounds {
+		m[addr] = l.Address()
+	}
+
+	return m
+}
+
+// closeInbounds 调用方需持有 p.lock
+func (p *Executor) closeInbounds() {
+	addrs := make([]string, 0, len(p.inbounds))
+	for addr := range p.inbounds {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		err := p.inbounds[addr].Close()
+		if err != nil {
+			log.Errorf("err:%v", err)
+		}
+		delete(p.inbounds, addr)
+	}
+}
@@ -0,0 +1,156 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/config"
+)
+
+var (
+	ErrAlreadyStarted = errors.New("executor already started")
+	ErrNotStarted     = errors.New("executor not started")
+)
+
+// Start 启动节点检测和连接处理，ctx 取消后停止接收新连接，已有的连接不受影响
+// Shutdown 之后可以再次 Start，监听需要重新调用 Listen
+func (p *Executor) Start(ctx context.Context) error {
+	p.lock.Lock()
+	if p.cancel != nil {
+		p.lock.Unlock()
+		return ErrAlreadyStarted
+	}
+
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.lock.Unlock()
+
+	p.connLock.Lock()
+	p.forceClosed = false
+	p.connLock.Unlock()
+
+	config.Start(ctx)
+
+	p.loops.Add(2)
+	go p.handleConn(ctx)
+	go p.handleNode(ctx)
+
+	// 启动前添加的节点
+	p.notifyEvent()
+
+	return nil
+}
+
+// Shutdown 关闭监听，中止进行中的节点检测，等待进行中的连接结束后保存节点状态
+// ctx 到期时强制关闭剩余的连接并返回 ctx.Err()
+func (p *Executor) Shutdown(ctx context.Context) error {
+	p.lock.RLock()
+	cancel := p.cancel
+	p.lock.RUnlock()
+
+	if cancel == nil {
+		return ErrNotStarted
+	}
+
+	cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		p.loops.Wait()
+		p.relays.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		n := p.closeConns()
+		log.Warnf("shutdown timeout, force close %d conns", n)
+		<-drained
+	}
+
+	if e := p.SaveState(); e != nil && err == nil {
+		err = e
+	}
+
+	p.rule.Sync()
+	config.Flush()
+
+	p.lock.Lock()
+	p.cancel = nil
+	p.lock.Unlock()
+
+	return err
+}
+
+// Wait 阻塞直到 Start 的 ctx 被取消且所有连接都已结束，未启动时立即返回
+func (p *Executor) Wait() {
+	p.loops.Wait()
+	p.relays.Wait()
+}
+
+// trackConn 记录进行中的连接，用于关闭时等待或强制关闭
+func (p *Executor) trackConn(conns ...net.Conn) func() {
+	p.connLock.Lock()
+	for _, conn := range conns {
+		// 已经强制关闭过，后来的连接也直接关掉
+		if p.forceClosed {
+			_ = conn.Close()
+		}
+		p.conns[conn] = struct{}{}
+	}
+	p.connLock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.connLock.Lock()
+			defer p.connLock.Unlock()
+
+			for _, conn := range conns {
+				delete(p.conns, conn)
+			}
+		})
+	}
+}
+
+func (p *Executor) closeConns() int {
+	p.connLock.Lock()
+	defer p.connLock.Unlock()
+
+	p.forceClosed = true
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+
+	return len(p.conns)
+}
+
+// emit 事件放入队列，不会因为 handleNode 未启动或繁忙而阻塞
+func (p *Executor) emit(e executorEvent) {
+	p.eventLock.Lock()
+	p.events = append(p.events, e)
+	p.eventLock.Unlock()
+
+	p.notifyEvent()
+}
+
+func (p *Executor) notifyEvent() {
+	select {
+	case p.eventNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Executor) popEvents() []executorEvent {
+	p.eventLock.Lock()
+	defer p.eventLock.Unlock()
+
+	events := p.events
+	p.events = nil
+	return events
+}
@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/darabuchi/nico/config"
+)
+
+var (
+	ErrTooManyConns      = errors.New("too many connections")
+	ErrTooManyConnsPerIP = errors.New("too many connections from same ip")
+)
+
+// connLimiter 限制同时处理的连接数，超出时新连接直接关闭，而不是排队等待，
+// 避免来不及处理的连接堆积
+type connLimiter struct {
+	lock  sync.Mutex
+	limit config.Limit
+
+	total int
+	bySrc map[netip.Addr]int
+
+	rejected uint64
+}
+
+func newConnLimiter(limit config.Limit) *connLimiter {
+	return &connLimiter{
+		limit: limit,
+		bySrc: map[netip.Addr]int{},
+	}
+}
+
+func (p *connLimiter) setLimit(limit config.Limit) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.limit = limit
+}
+
+func (p *connLimiter) idleTimeout() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.limit.IdleTimeout
+}
+
+// acquire 成功时返回释放的函数，可以重复调用
+func (p *connLimiter) acquire(src netip.Addr) (func(), error) {
+	src = src.Unmap()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.limit.MaxConns > 0 && p.total >= p.limit.MaxConns {
+		p.rejected++
+		return nil, ErrTooManyConns
+	}
+	if p.limit.MaxConnsPerIP > 0 && p.bySrc[src] >= p.limit.MaxConnsPerIP {
+		p.rejected++
+		return nil, ErrTooManyConnsPerIP
+	}
+
+	p.total++
+	p.bySrc[src]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+
+			p.total--
+			if p.bySrc[src]--; p.bySrc[src] <= 0 {
+				delete(p.bySrc, src)
+			}
+		})
+	}, nil
+}
+
+// ConnStats 连接数统计
+type ConnStats struct {
+	Active   int    `json:"active"`
+	Rejected uint64 `json:"rejected"`
+}
+
+func (p *connLimiter) stats() ConnStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return ConnStats{
+		Active:   p.total,
+		Rejected: p.rejected,
+	}
+}
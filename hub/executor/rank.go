@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"sort"
+
+	"github.com/darabuchi/nico/adapter"
+)
+
+type rankKey struct {
+	node adapter.AdapterProxy
+
+	alive bool
+	score float64
+	delay uint16
+	speed float64
+	id    string
+}
+
+// rankProxyList 节点排序：存活的在前，然后按评分从高到低，
+// 评分相同时按最近延迟从低到高（未测出的排最后）、速度从高到低，最后按 UniqueId 保证顺序确定
+func rankProxyList(list adapter.ProxyList, weight adapter.ScoreWeight) adapter.ProxyList {
+	// 先取快照，避免排序过程中节点状态变化导致比较结果前后不一致
+	keys := make([]rankKey, 0, len(list))
+	for _, node := range list {
+		speed := node.LoadFloat64(Speed)
+		keys = append(keys, rankKey{
+			node:  node,
+			alive: node.LoadBool(Alive),
+			score: adapter.WeightedScore(node.DelayStats(), speed, weight),
+			delay: node.LoadUint16(Delay),
+			speed: speed,
+			id:    node.UniqueId(),
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return rankLess(keys[i], keys[j])
+	})
+
+	ranked := make(adapter.ProxyList, 0, len(keys))
+	for _, key := range keys {
+		ranked = append(ranked, key.node)
+	}
+
+	return ranked
+}
+
+func rankLess(a, b rankKey) bool {
+	if a.alive != b.alive {
+		return a.alive
+	}
+
+	if a.score != b.score {
+		return a.score > b.score
+	}
+
+	if a.delay != b.delay {
+		if a.delay == 0 || b.delay == 0 {
+			return b.delay == 0
+		}
+		return a.delay < b.delay
+	}
+
+	if a.speed != b.speed {
+		return a.speed > b.speed
+	}
+
+	return a.id < b.id
+}
@@ -0,0 +1,169 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/hub/event"
+)
+
+// fakeProxy 只实现排序用到的方法
+type fakeProxy struct {
+	adapter.AdapterProxy
+
+	id     string
+	alive  bool
+	delay  uint16
+	speed  float64
+	delays []uint16
+}
+
+func (p *fakeProxy) UniqueId() string {
+	return p.id
+}
+
+func (p *fakeProxy) LoadBool(key string) bool {
+	return key == Alive && p.alive
+}
+
+func (p *fakeProxy) LoadUint16(key string) uint16 {
+	if key == Delay {
+		return p.delay
+	}
+	return 0
+}
+
+func (p *fakeProxy) LoadFloat64(key string) float64 {
+	if key == Speed {
+		return p.speed
+	}
+	return 0
+}
+
+func (p *fakeProxy) DelayStats() adapter.DelayStats {
+	var records []adapter.DelayRecord
+	for _, delay := range p.delays {
+		records = append(records, adapter.DelayRecord{Delay: delay})
+	}
+	return adapter.NewDelayStats(records)
+}
+
+func ids(list adapter.ProxyList) string {
+	var b []string
+	for _, node := range list {
+		b = append(b, node.UniqueId())
+	}
+	return strings.Join(b, ",")
+}
+
+func TestRankProxyList(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight adapter.ScoreWeight
+		nodes  []*fakeProxy
+		want   string
+	}{
+		{
+			name:   "alive first",
+			weight: adapter.DefaultScoreWeight,
+			nodes: []*fakeProxy{
+				{id: "dead", delay: 0, delays: []uint16{50, 50, 50}},
+				{id: "alive", alive: true, delay: 300, delays: []uint16{300}},
+			},
+			want: "alive,dead",
+		},
+		{
+			name:   "score",
+			weight: adapter.DefaultScoreWeight,
+			nodes: []*fakeProxy{
+				{id: "slow", alive: true, delay: 400, delays: []uint16{400, 400, 400}},
+				{id: "fast", alive: true, delay: 80, delays: []uint16{80, 80, 80}},
+				{id: "flaky", alive: true, delay: 60, delays: []uint16{60, 0, 60, 0}},
+			},
+			want: "fast,slow,flaky",
+		},
+		{
+			name:   "speed weight",
+			weight: adapter.ScoreWeight{Speed: 1},
+			nodes: []*fakeProxy{
+				{id: "fast", alive: true, delay: 80, speed: 100, delays: []uint16{80, 80, 80}},
+				{id: "wide", alive: true, delay: 400, speed: 10240, delays: []uint16{400, 400, 400}},
+			},
+			want: "wide,fast",
+		},
+		{
+			name:   "unmeasured last",
+			weight: adapter.DefaultScoreWeight,
+			nodes: []*fakeProxy{
+				{id: "a", alive: true},
+				{id: "b", alive: true, delay: 200},
+				{id: "c", alive: true, delay: 100},
+			},
+			want: "c,b,a",
+		},
+		{
+			name:   "tie break by unique id",
+			weight: adapter.DefaultScoreWeight,
+			nodes: []*fakeProxy{
+				{id: "c"},
+				{id: "a"},
+				{id: "b"},
+			},
+			want: "a,b,c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list adapter.ProxyList
+			for _, node := range tt.nodes {
+				list = append(list, node)
+			}
+
+			if got := ids(rankProxyList(list, tt.weight)); got != tt.want {
+				t.Errorf("rankProxyList() = %v, want %v", got, tt.want)
+			}
+
+			// 输入顺序不影响结果
+			for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+				list[i], list[j] = list[j], list[i]
+			}
+			if got := ids(rankProxyList(list, tt.weight)); got != tt.want {
+				t.Errorf("reversed rankProxyList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutor_addNode(t *testing.T) {
+	p := &Executor{
+		bus:         event.NewBus(),
+		eventNotify: make(chan struct{}, 1),
+		unsubscribe: map[string]func(){},
+	}
+
+	n, err := adapter.ParseClash(map[string]any{"name": "t", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass"})
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	p.addNode(n)
+	if events := p.popEvents(); len(events) != 1 {
+		t.Errorf("new node should trigger delay check, got %d events", len(events))
+	}
+
+	// 未存活的节点重复添加时不应重置状态，也不应再次触发测速
+	n.Store(Delay, uint16(120))
+	p.addNode(n.Clone())
+
+	if len(p.allProxy) != 1 {
+		t.Errorf("duplicate node added, got %d nodes", len(p.allProxy))
+	}
+	if len(p.popEvents()) != 0 {
+		t.Errorf("duplicate node triggered delay check")
+	}
+	if n.LoadUint16(Delay) != 120 {
+		t.Errorf("existing node state reset, delay %d", n.LoadUint16(Delay))
+	}
+}
@@ -0,0 +1,175 @@
+package executor
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	N "github.com/Dreamacro/clash/common/net"
+	"go.uber.org/atomic"
+)
+
+const relayBufferSize = 32 * 1024
+
+var relayBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, relayBufferSize)
+		return &buf
+	},
+}
+
+// relay 在 remote 和 local 之间双向转发，返回上传（local -> remote）和下载的字节数
+// 一个方向读到 EOF 时只关闭对端的写，另一个方向继续转发；出错时关闭两端
+// idle>0 时两个方向都超过 idle 没有数据就关闭
+func relay(remote, local net.Conn, idle time.Duration) (up, down int64) {
+	last := atomic.NewInt64(time.Now().UnixNano())
+
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			_ = remote.Close()
+			_ = local.Close()
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var err error
+		up, err = copyConn(remote, local, idle, last)
+		if err != nil {
+			closeBoth()
+		} else {
+			closeWrite(remote)
+		}
+	}()
+
+	down, err := copyConn(local, remote, idle, last)
+	if err != nil {
+		closeBoth()
+	} else {
+		closeWrite(local)
+	}
+
+	<-done
+	closeBoth()
+
+	return up, down
+}
+
+// copyConn 从 src 复制到 dst，src 读到 EOF 时返回 nil
+func copyConn(dst, src net.Conn, idle time.Duration, last *atomic.Int64) (int64, error) {
+	bp := relayBufferPool.Get().(*[]byte)
+	defer relayBufferPool.Put(bp)
+	buf := *bp
+
+	var n int64
+	for {
+		if idle > 0 {
+			_ = src.SetReadDeadline(time.Now().Add(idle))
+		}
+
+		nr, err := src.Read(buf)
+		if nr > 0 {
+			last.Store(time.Now().UnixNano())
+
+			if idle > 0 {
+				_ = dst.SetWriteDeadline(time.Now().Add(idle))
+			}
+			nw, werr := dst.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, nil
+			}
+
+			// 这个方向空闲，但另一个方向还有数据
+			var ne net.Error
+			if idle > 0 && errors.As(err, &ne) && ne.Timeout() &&
+				time.Since(time.Unix(0, last.Load())) < idle {
+				continue
+			}
+
+			return n, err
+		}
+	}
+}
+
+// firstReadConn 第一次读到数据时调用 fn
+type firstReadConn struct {
+	net.Conn
+
+	read *atomic.Bool
+	fn   func()
+}
+
+func newFirstReadConn(conn net.Conn, fn func()) *firstReadConn {
+	return &firstReadConn{
+		Conn: conn,
+		read: atomic.NewBool(false),
+		fn:   fn,
+	}
+}
+
+func (c *firstReadConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.read.CAS(false, true) {
+		c.fn()
+	}
+	return n, err
+}
+
+// Unwrap 返回原来的连接，不改变读写内容
+func (c *firstReadConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// closeWrite 关闭连接的写，不支持半关闭时关闭整个连接
+func closeWrite(conn net.Conn) {
+	for c := conn; c != nil; c = unwrapConn(c) {
+		if cw, ok := c.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+			return
+		}
+	}
+
+	_ = conn.Close()
+}
+
+const outboundConnType = "github.com/Dreamacro/clash/adapter/outbound.conn"
+
+// unwrapConn 取出不改变读写内容的包装中的连接：入站的 BufferedConn 只缓冲读，
+// 出站的 outbound.conn 和经过跳板的连接只记录链路信息
+func unwrapConn(c net.Conn) net.Conn {
+	if bc, ok := c.(*N.BufferedConn); ok {
+		return bc.Conn
+	}
+	if uc, ok := c.(interface{ Unwrap() net.Conn }); ok {
+		return uc.Unwrap()
+	}
+
+	v := reflect.ValueOf(c)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := v.Elem().Type()
+	if t.PkgPath()+"."+t.Name() != outboundConnType {
+		return nil
+	}
+
+	inner, ok := v.Elem().FieldByName("Conn").Interface().(net.Conn)
+	if !ok {
+		return nil
+	}
+	return inner
+}
@@ -0,0 +1,293 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter/outbound"
+	N "github.com/Dreamacro/clash/common/net"
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/internal/testutil"
+)
+
+// tcpPair 一对互相连接的 tcp 连接
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+
+	a, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	b := <-accepted
+	if b == nil {
+		t.Fatalf("accept failed")
+	}
+
+	return a, b
+}
+
+func TestRelay_HalfClose(t *testing.T) {
+	client, local := tcpPair(t)
+	defer client.Close()
+	remote, server := tcpPair(t)
+	defer server.Close()
+
+	type result struct{ up, down int64 }
+	done := make(chan result, 1)
+	go func() {
+		// 入站连接经过 BufferedConn 包装，也应该能半关闭
+		up, down := relay(remote, N.NewBufferedConn(local), 0)
+		done <- result{up, down}
+	}()
+
+	_, err := client.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	_ = client.(*net.TCPConn).CloseWrite()
+
+	// 服务端读到 EOF 后还能回写
+	_ = server.SetDeadline(time.Now().Add(time.Second))
+	req, err := io.ReadAll(server)
+	if err != nil || string(req) != "hello" {
+		t.Fatalf("server got %q, err:%v", req, err)
+	}
+	_, err = server.Write([]byte("world!"))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	server.Close()
+
+	_ = client.SetDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(client)
+	if err != nil || string(resp) != "world!" {
+		t.Fatalf("client got %q, err:%v", resp, err)
+	}
+
+	select {
+	case r := <-done:
+		if r.up != 5 || r.down != 6 {
+			t.Errorf("up %d down %d", r.up, r.down)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("relay not finished")
+	}
+}
+
+func TestRelay_Idle(t *testing.T) {
+	client, local := tcpPair(t)
+	defer client.Close()
+	remote, server := tcpPair(t)
+	defer server.Close()
+
+	const idle = time.Millisecond * 100
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay(remote, local, idle)
+	}()
+
+	// 只有一个方向有数据时不算空闲
+	go func() {
+		for i := 0; i < 6; i++ {
+			_, _ = server.Write([]byte("x"))
+			time.Sleep(idle / 2)
+		}
+	}()
+
+	r := bufio.NewReader(client)
+	for i := 0; i < 6; i++ {
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := r.ReadByte(); err != nil {
+			t.Fatalf("read %d err:%v", i, err)
+		}
+	}
+
+	// 停止发送后超过 idle 关闭
+	select {
+	case <-done:
+	case <-time.After(idle * 5):
+		t.Fatalf("idle relay not closed")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := r.ReadByte(); err == nil {
+		t.Errorf("client conn should be closed")
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	p := newConnLimiter(config.Limit{MaxConns: 3, MaxConnsPerIP: 2})
+
+	a := netip.MustParseAddr("10.0.0.1")
+	b := netip.MustParseAddr("::ffff:10.0.0.2")
+
+	releaseA1, err := p.acquire(a)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if _, err = p.acquire(a); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if _, err = p.acquire(a); err != ErrTooManyConnsPerIP {
+		t.Errorf("got %v, want %v", err, ErrTooManyConnsPerIP)
+	}
+	if _, err = p.acquire(b); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if _, err = p.acquire(netip.MustParseAddr("10.0.0.3")); err != ErrTooManyConns {
+		t.Errorf("got %v, want %v", err, ErrTooManyConns)
+	}
+
+	releaseA1()
+	releaseA1()
+	if s := p.stats(); s.Active != 2 || s.Rejected != 2 {
+		t.Errorf("stats got %+v", s)
+	}
+	if _, err = p.acquire(a); err != nil {
+		t.Errorf("err:%v", err)
+	}
+}
+
+// TestExecutor_Load 大量连接涌入时，同时处理的连接数和 goroutine 数有上限，空闲的连接会被回收
+func TestExecutor_Load(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	const (
+		maxConns = 20
+		clients  = 300
+		idle     = time.Millisecond * 300
+	)
+
+	ex := NewExecutor(WithLimit(config.Limit{MaxConns: maxConns, IdleTimeout: idle}))
+	err := ex.Start(context.Background())
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer ex.Shutdown(context.Background())
+
+	err = ex.Listen("0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	_, port, _ := net.SplitHostPort(ex.Addr())
+	proxy := net.JoinHostPort("127.0.0.1", port)
+
+	base := runtime.NumGoroutine()
+
+	var lock sync.Mutex
+	var conns []net.Conn
+	var served int
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := socks5Connect(proxy, echo.Addr().String())
+			if err != nil {
+				return
+			}
+
+			_ = conn.SetDeadline(time.Now().Add(idle / 2))
+			_, err = conn.Write([]byte("ping"))
+			if err == nil {
+				_, err = io.ReadFull(conn, make([]byte, 4))
+			}
+			_ = conn.SetDeadline(time.Time{})
+
+			lock.Lock()
+			defer lock.Unlock()
+			conns = append(conns, conn)
+			if err == nil {
+				served++
+			}
+		}()
+	}
+	wg.Wait()
+
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	stats := ex.ConnStats()
+	goroutines := runtime.NumGoroutine() - base
+	t.Logf("served %d, active %d, rejected %d, goroutines +%d", served, stats.Active, stats.Rejected, goroutines)
+
+	if served == 0 || served > maxConns {
+		t.Errorf("served %d conns, limit %d", served, maxConns)
+	}
+	if stats.Active > maxConns {
+		t.Errorf("active %d > %d", stats.Active, maxConns)
+	}
+	if stats.Rejected == 0 {
+		t.Errorf("no conn rejected")
+	}
+	// 每个连接在 executor 和 echo 服务端各有几个 goroutine，被拒绝的连接不占用 goroutine
+	if goroutines > maxConns*5 {
+		t.Errorf("goroutines grew by %d with %d clients", goroutines, clients)
+	}
+
+	// 客户端不关闭连接，超过 idle 后也会被回收
+	deadline := time.Now().Add(idle * 10)
+	for ex.ConnStats().Active > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle conns not closed, active %d", ex.ConnStats().Active)
+		}
+		time.Sleep(idle / 10)
+	}
+}
+
+func TestUnwrapConn(t *testing.T) {
+	echo := testutil.EchoListener(t)
+	defer echo.Close()
+
+	addr := netip.MustParseAddrPort(echo.Addr().String())
+	conn, err := outbound.NewDirect().DialContext(context.Background(), &constant.Metadata{
+		NetWork:  constant.TCP,
+		DstIP:    addr.Addr(),
+		DstPort:  strconv.Itoa(int(addr.Port())),
+		AddrType: constant.AtypIPv4,
+	})
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer conn.Close()
+
+	// 直连的出站连接可以半关闭
+	if _, ok := unwrapConn(conn).(*net.TCPConn); !ok {
+		t.Errorf("unwrap got %T", unwrapConn(conn))
+	}
+
+	if unwrapConn(unwrapConn(conn)) != nil {
+		t.Errorf("tcp conn should not be unwrapped")
+	}
+}
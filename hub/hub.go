@@ -0,0 +1,592 @@
+package hub
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/dns"
+	"github.com/darabuchi/log"
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub/event"
+	"github.com/darabuchi/nico/hub/executor"
+	"github.com/darabuchi/nico/hub/rule"
+	"github.com/darabuchi/nico/hub/selector"
+)
+
+// SourceConfig 直接写在配置 nodes 中的节点的来源，订阅节点的来源为订阅地址
+const SourceConfig = "config"
+
+var defaultHub = New(nil)
+
+// SetExecutor 设置 RefreshConfig 和 WatchConfig 作用的 Executor
+func SetExecutor(ex *executor.Executor) {
+	defaultHub.lock.Lock()
+	defer defaultHub.lock.Unlock()
+
+	defaultHub.ex = ex
+	if ex != nil {
+		defaultHub.rule = ex.AdapterRule()
+	}
+}
+
+// RefreshConfig 读取配置文件并应用到 SetExecutor 设置的 Executor
+func RefreshConfig(filePath string) error {
+	useConfigPath(filePath)
+
+	_, err := defaultHub.Refresh(filePath)
+	return err
+}
+
+// useConfigPath 让 config 包读写同一个配置文件，切换前先写回未保存的修改
+func useConfigPath(filePath string) {
+	if filepath.Clean(config.ConfigPath()) == filepath.Clean(filePath) {
+		return
+	}
+
+	config.Flush()
+	config.SetConfigPath(filePath)
+}
+
+// Hub 把声明式配置应用到运行中的 Executor，只改动有变化的部分
+type Hub struct {
+	lock sync.Mutex
+	ex   *executor.Executor
+
+	rule *rule.AdapterRule
+
+	// current 上次成功应用的配置
+	current *Config
+
+	// subscriptions 订阅地址上次拉取到的节点 UniqueId，拉取失败时沿用
+	subscriptions map[string][]string
+	fetchedAt     map[string]time.Time
+
+	client *http.Client
+}
+
+// New 配置中的规则写入 ex 使用的规则，ex 为空时写入进程共享的规则
+func New(ex *executor.Executor) *Hub {
+	ar := rule.GetAdapterRule()
+	if ex != nil {
+		ar = ex.AdapterRule()
+	}
+
+	return &Hub{
+		ex:            ex,
+		rule:          ar,
+		subscriptions: map[string][]string{},
+		fetchedAt:     map[string]time.Time{},
+		client: &http.Client{
+			Timeout: time.Second * 30,
+		},
+	}
+}
+
+// SetAdapterRule 配置中的规则写入 ar，需与 Executor 使用的一致
+func (h *Hub) SetAdapterRule(ar *rule.AdapterRule) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.rule = ar
+}
+
+// Diff 一次应用中实际发生的变化
+type Diff struct {
+	AddInbounds []string `json:"add_inbounds,omitempty"`
+	DelInbounds []string `json:"del_inbounds,omitempty"`
+
+	// AddNodes DelNodes 为节点的 UniqueId
+	AddNodes []string `json:"add_nodes,omitempty"`
+	DelNodes []string `json:"del_nodes,omitempty"`
+
+	AddRules []adapter.RuleInfo `json:"add_rules,omitempty"`
+	DelRules []adapter.RuleInfo `json:"del_rules,omitempty"`
+
+	Groups      bool `json:"groups,omitempty"`
+	DNS         bool `json:"dns,omitempty"`
+	HealthCheck bool `json:"health_check,omitempty"`
+	Selector    bool `json:"selector,omitempty"`
+	Rank        bool `json:"rank,omitempty"`
+	Tls         bool `json:"tls,omitempty"`
+	Geo         bool `json:"geo,omitempty"`
+}
+
+func (d *Diff) Empty() bool {
+	return len(d.AddInbounds) == 0 && len(d.DelInbounds) == 0 &&
+		len(d.AddNodes) == 0 && len(d.DelNodes) == 0 &&
+		len(d.AddRules) == 0 && len(d.DelRules) == 0 &&
+		!d.Groups && !d.DNS && !d.HealthCheck &&
+		!d.Selector && !d.Rank && !d.Tls && !d.Geo
+}
+
+func (d *Diff) String() string {
+	return fmt.Sprintf("inbounds +%d -%d, nodes +%d -%d, rules +%d -%d, groups:%v, dns:%v, health_check:%v, selector:%v, rank:%v, tls:%v, geo:%v",
+		len(d.AddInbounds), len(d.DelInbounds),
+		len(d.AddNodes), len(d.DelNodes),
+		len(d.AddRules), len(d.DelRules),
+		d.Groups, d.DNS, d.HealthCheck,
+		d.Selector, d.Rank, d.Tls, d.Geo)
+}
+
+// Refresh 读取、校验并应用配置文件，校验失败时不做任何改动
+func (h *Hub) Refresh(filePath string) (*Diff, error) {
+	c, err := LoadConfig(filePath)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return h.Apply(c)
+}
+
+// Apply 让 Executor 与 c 保持一致，未变化的监听、节点和连接不受影响
+func (h *Hub) Apply(c *Config) (*Diff, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.ex == nil {
+		return nil, fmt.Errorf("executor not set")
+	}
+
+	err := validate(c)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	old := h.current
+	if old == nil {
+		old = &Config{}
+	}
+
+	// 状态文件在 Executor 创建时打开，运行中无法切换
+	if h.current != nil && old.StateFile != c.StateFile {
+		return nil, fmt.Errorf("state_file changed from %q to %q, restart required", old.StateFile, c.StateFile)
+	}
+
+	// 可能失败的部分先准备好，失败时不做任何改动
+	groupsChanged := h.current == nil || !reflect.DeepEqual(old.Groups, c.Groups)
+	var groups []*executor.Group
+	if groupsChanged {
+		groups, err = buildGroups(c.Groups)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+	}
+
+	dnsChanged := h.current == nil || !reflect.DeepEqual(old.DNS, c.DNS)
+	var dnsResolver *dns.Resolver
+	if dnsChanged {
+		dnsResolver, err = newResolver(c.DNS)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+	}
+
+	selectorChanged := h.current == nil || old.Selector != c.Selector
+	var sel selector.Selector
+	if selectorChanged {
+		sel, err = selector.New(c.Selector)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+	}
+
+	tlsChanged := h.current == nil || !reflect.DeepEqual(old.Tls, c.Tls)
+	var tlsConfig *tls.Config
+	if tlsChanged {
+		tlsConfig, err = c.Tls.TlsConfig()
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+	}
+
+	geoChanged := h.current == nil || old.Geo != c.Geo
+	var geo *adapter.GeoEnricher
+	if geoChanged {
+		geo, err = executor.NewGeoEnricher(c.Geo)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			return nil, err
+		}
+	}
+
+	diff := &Diff{}
+
+	err = h.applyInbounds(old, c, diff)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	h.applyNodes(old, c, diff, false)
+	h.applyRules(old, c, diff)
+
+	if h.current == nil || old.Learn != c.Learn {
+		h.rule.SetLearnLimit(c.Learn.TTL, c.Learn.Max)
+	}
+
+	if groupsChanged {
+		h.ex.SetGroups(groups...)
+		diff.Groups = true
+	}
+
+	if dnsChanged {
+		applyDNS(c.DNS, dnsResolver)
+		diff.DNS = true
+	}
+
+	if selectorChanged {
+		h.ex.SetSelector(sel)
+		diff.Selector = true
+	}
+
+	if h.current == nil || old.Rank != c.Rank {
+		h.ex.SetScoreWeight(c.Rank)
+		diff.Rank = true
+	}
+
+	if tlsChanged {
+		h.ex.SetTlsConfig(tlsConfig)
+		diff.Tls = true
+	}
+
+	if geoChanged {
+		h.ex.SetGeoEnricher(geo)
+		diff.Geo = true
+	}
+
+	if h.current == nil || old.Limit != c.Limit {
+		h.ex.SetLimit(c.Limit)
+	}
+
+	if h.current == nil || old.Failover != c.Failover {
+		h.ex.SetFailover(c.Failover)
+	}
+
+	if h.current == nil || old.Breaker != c.Breaker {
+		h.ex.SetBreaker(c.Breaker)
+	}
+
+	if h.current == nil || old.HealthCheck != c.HealthCheck {
+		h.ex.SetHealthCheck(c.HealthCheck.Url, c.HealthCheck.Interval)
+		diff.HealthCheck = true
+	}
+
+	h.current = c
+
+	log.Infof("apply config: %s", diff)
+
+	h.ex.Events().Publish(event.Event{
+		Type: event.ConfigReloaded,
+		Data: diff,
+	})
+
+	return diff, nil
+}
+
+// applyInbounds 新的监听全部启动成功后才关闭旧的，启动失败时关闭本次已启动的
+func (h *Hub) applyInbounds(old, c *Config, diff *Diff) error {
+	want := map[string]bool{}
+	for _, in := range c.Inbounds {
+		want[in.Listen] = true
+	}
+
+	running := h.ex.Inbounds()
+
+	for _, in := range c.Inbounds {
+		if _, ok := running[in.Listen]; ok {
+			continue
+		}
+
+		err := h.ex.AddInbound(in.Listen)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			for _, listen := range diff.AddInbounds {
+				if e := h.ex.RemoveInbound(listen); e != nil {
+					log.Errorf("err:%v", e)
+				}
+			}
+			diff.AddInbounds = nil
+			return err
+		}
+		diff.AddInbounds = append(diff.AddInbounds, in.Listen)
+	}
+
+	// 只关闭由配置管理的监听
+	for _, in := range old.Inbounds {
+		if want[in.Listen] {
+			continue
+		}
+
+		err := h.ex.RemoveInbound(in.Listen)
+		if err != nil {
+			log.Errorf("err:%v", err)
+		}
+		diff.DelInbounds = append(diff.DelInbounds, in.Listen)
+	}
+
+	return nil
+}
+
+// applyNodes force 为 false 时只拉取新增的或到期的订阅
+func (h *Hub) applyNodes(old, c *Config, diff *Diff, force bool) {
+	// 来源 -> 期望的节点
+	want := map[string]adapter.ProxyList{}
+
+	for _, n := range c.Nodes {
+		node, err := parseNode(n)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			continue
+		}
+		want[SourceConfig] = append(want[SourceConfig], node)
+	}
+
+	for _, sub := range c.Subscriptions {
+		if !force && !h.subscriptionDue(sub) {
+			continue
+		}
+
+		list, err := h.fetchSubscription(sub.Url)
+		if err != nil {
+			// 拉取失败时保留原有的节点
+			log.Errorf("fetch subscription %s fail:%v", sub.Url, err)
+			continue
+		}
+		want[sub.Url] = list
+	}
+
+	// 配置中去掉的订阅，其节点需要全部删除
+	removed := map[string]bool{}
+	for _, sub := range old.Subscriptions {
+		if !hasSubscription(c, sub.Url) {
+			want[sub.Url] = adapter.ProxyList{}
+			removed[sub.Url] = true
+		}
+	}
+	if len(old.Nodes) > 0 && len(c.Nodes) == 0 {
+		want[SourceConfig] = adapter.ProxyList{}
+	}
+
+	running := map[string]adapter.AdapterProxy{}
+	h.ex.Nodes().Each(func(node adapter.AdapterProxy) {
+		running[node.UniqueId()] = node
+	})
+
+	sources := make([]string, 0, len(want))
+	for source := range want {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	// 仍被其他来源引用的节点不删除，owner 为节点现在归属的来源
+	owner := map[string]string{}
+	for _, source := range sources {
+		if source != SourceConfig {
+			h.subscriptions[source] = nil
+		}
+		for _, node := range want[source] {
+			if _, ok := owner[node.UniqueId()]; !ok {
+				owner[node.UniqueId()] = source
+			}
+			if source != SourceConfig {
+				h.subscriptions[source] = append(h.subscriptions[source], node.UniqueId())
+			}
+		}
+	}
+	for source, ids := range h.subscriptions {
+		if _, ok := want[source]; ok {
+			continue
+		}
+		for _, id := range ids {
+			if _, ok := owner[id]; !ok {
+				owner[id] = source
+			}
+		}
+	}
+
+	for _, source := range sources {
+		for _, node := range want[source] {
+			if _, ok := running[node.UniqueId()]; ok {
+				continue
+			}
+
+			node.Store(adapter.CacheSource, source)
+			h.ex.AddNode(node)
+			running[node.UniqueId()] = node
+			diff.AddNodes = append(diff.AddNodes, node.UniqueId())
+		}
+
+		for id, node := range running {
+			if node.LoadString(adapter.CacheSource) != source {
+				continue
+			}
+
+			if o, ok := owner[id]; ok {
+				if o != source {
+					node.Store(adapter.CacheSource, o)
+				}
+				continue
+			}
+
+			if h.ex.RemoveNode(id) {
+				delete(running, id)
+				diff.DelNodes = append(diff.DelNodes, id)
+			}
+		}
+
+		if removed[source] {
+			delete(h.subscriptions, source)
+			delete(h.fetchedAt, source)
+		}
+	}
+
+	sort.Strings(diff.DelNodes)
+}
+
+func hasSubscription(c *Config, u string) bool {
+	for _, sub := range c.Subscriptions {
+		if sub.Url == u {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionDue 新增的订阅或超过更新间隔的订阅需要重新拉取
+func (h *Hub) subscriptionDue(sub Subscription) bool {
+	at, ok := h.fetchedAt[sub.Url]
+	if !ok {
+		return true
+	}
+	return sub.Interval > 0 && time.Since(at) >= sub.Interval
+}
+
+func (h *Hub) fetchSubscription(u string) (adapter.ProxyList, error) {
+	resp, err := h.client.Get(u)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	list, err := adapter.ParseSubscription(buf)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	h.fetchedAt[u] = time.Now()
+
+	return list, nil
+}
+
+// UpdateSubscriptions 拉取到期的订阅，force 时拉取全部订阅
+func (h *Hub) UpdateSubscriptions(force bool) *Diff {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	diff := &Diff{}
+	if h.ex == nil || h.current == nil {
+		return diff
+	}
+
+	h.applyNodes(h.current, h.current, diff, force)
+
+	return diff
+}
+
+func (h *Hub) applyRules(old, c *Config, diff *Diff) {
+	want := map[adapter.RuleInfo]bool{}
+	for _, info := range c.Rules {
+		want[info] = true
+	}
+
+	have := map[adapter.RuleInfo]bool{}
+	for _, info := range old.Rules {
+		have[info] = true
+	}
+
+	for _, info := range old.Rules {
+		if want[info] {
+			continue
+		}
+
+		r, err := rule.NewRule(info)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			continue
+		}
+		h.rule.DelRule(r)
+		diff.DelRules = append(diff.DelRules, info)
+	}
+
+	for _, info := range c.Rules {
+		if have[info] {
+			continue
+		}
+
+		r, err := rule.NewRule(info)
+		if err != nil {
+			log.Errorf("err:%v", err)
+			continue
+		}
+		h.rule.PutRule(r)
+		diff.AddRules = append(diff.AddRules, info)
+
+		h.ex.Events().Publish(event.Event{
+			Type: event.RuleAdded,
+			Data: r.Export(),
+		})
+	}
+}
+
+func buildGroups(list []Group) ([]*executor.Group, error) {
+	var groups []*executor.Group
+	for _, g := range list {
+		s, err := selector.New(g.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		group := &executor.Group{
+			Name:     g.Name,
+			Selector: s,
+		}
+
+		if g.Filter != "" {
+			group.Filter, err = regexp.Compile(g.Filter)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
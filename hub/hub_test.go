@@ -0,0 +1,416 @@
+package hub
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darabuchi/nico/adapter"
+	"github.com/darabuchi/nico/config"
+	"github.com/darabuchi/nico/hub/executor"
+	"github.com/darabuchi/nico/hub/rule"
+	"go.uber.org/atomic"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "ok",
+			yaml: `
+inbounds:
+  - listen: 127.0.0.1:7890
+nodes:
+  - trojan://pass@a.example.com:443#a
+  - {name: b, type: trojan, server: b.example.com, port: 443, password: pass}
+subscriptions:
+  - url: https://sub.example.com/link
+    interval: 1h
+groups:
+  - name: jp
+    filter: JP
+    strategy: lowest_latency
+    tolerance: 50ms
+rules:
+  - {rule: Domain, payload: example.com, adapter: Proxy}
+dns:
+  nameservers: [223.5.5.5, "https://dns.alidns.com/dns-query"]
+health_check:
+  url: https://www.gstatic.com/generate_204
+  interval: 1m
+`,
+		},
+		{
+			name:    "duplicate inbound",
+			yaml:    "inbounds: [{listen: ':7890'}, {listen: ':7890'}]",
+			wantErr: "inbounds[1].listen",
+		},
+		{
+			name:    "bad node",
+			yaml:    "nodes: ['trojan://pass@a.example.com:99999']",
+			wantErr: "nodes[0]",
+		},
+		{
+			name:    "bad subscription",
+			yaml:    "subscriptions: [{url: ftp://sub.example.com}]",
+			wantErr: "subscriptions[0].url",
+		},
+		{
+			name:    "bad group strategy",
+			yaml:    "groups: [{name: a, strategy: fastest}]",
+			wantErr: "groups[0].strategy",
+		},
+		{
+			name:    "bad rule adapter",
+			yaml:    "rules: [{rule: Domain, payload: example.com, adapter: proxy}]",
+			wantErr: "rules[0].adapter",
+		},
+		{
+			name:    "dns without bootstrap",
+			yaml:    "dns: {nameservers: ['https://dns.alidns.com/dns-query']}",
+			wantErr: "dns.nameservers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("err:%v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("ParseConfig() error = %v, want prefix %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func newTestHub(t *testing.T) (*Hub, *executor.Executor) {
+	ex := executor.NewExecutor()
+	err := ex.Start(context.Background())
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	t.Cleanup(func() {
+		_ = ex.Shutdown(context.Background())
+	})
+
+	ar := rule.NewAdapterRule()
+	ex.SetAdapterRule(ar)
+
+	h := New(ex)
+	h.SetAdapterRule(ar)
+
+	return h, ex
+}
+
+func nodeNames(ex *executor.Executor) map[string]adapter.AdapterProxy {
+	m := map[string]adapter.AdapterProxy{}
+	ex.Nodes().Each(func(node adapter.AdapterProxy) {
+		m[node.Name()] = node
+	})
+	return m
+}
+
+func TestHub_Apply(t *testing.T) {
+	sub := atomic.NewString("trojan://pass@s1.example.com:443#s1\ntrojan://pass@s2.example.com:443#s2")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(sub.Load()))))
+	}))
+	defer srv.Close()
+
+	h, ex := newTestHub(t)
+
+	c, err := ParseConfig([]byte(`
+inbounds:
+  - listen: 127.0.0.1:0
+nodes:
+  - trojan://pass@a.example.com:443#a
+  - trojan://pass@b.example.com:443#b
+subscriptions:
+  - url: ` + srv.URL + `
+rules:
+  - {rule: Domain, payload: a.com, adapter: Proxy}
+  - {rule: Domain, payload: b.com, adapter: Reject}
+health_check:
+  interval: 10m
+`))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	diff, err := h.Apply(c)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(diff.AddInbounds) != 1 || len(diff.AddNodes) != 4 || len(diff.AddRules) != 2 || !diff.HealthCheck {
+		t.Errorf("first apply diff: %s", diff)
+	}
+
+	nodes := nodeNames(ex)
+	if nodes["a"].LoadString(adapter.CacheSource) != SourceConfig || nodes["s1"].LoadString(adapter.CacheSource) != srv.URL {
+		t.Errorf("node source not set")
+	}
+	if _, interval := ex.HealthCheck(); interval != time.Minute*10 {
+		t.Errorf("health check interval %v", interval)
+	}
+	listen := ex.Inbounds()["127.0.0.1:0"]
+
+	// 同样的配置再次应用不应有任何变化
+	diff, err = h.Apply(c)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !diff.Empty() {
+		t.Errorf("reapply should be empty, got %s", diff)
+	}
+
+	c2, err := ParseConfig([]byte(`
+inbounds:
+  - listen: 127.0.0.1:0
+nodes:
+  - trojan://pass@a.example.com:443#a
+  - trojan://pass@c.example.com:443#c
+rules:
+  - {rule: Domain, payload: a.com, adapter: Proxy}
+`))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	diff, err = h.Apply(c2)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if len(diff.AddInbounds) != 0 || len(diff.DelInbounds) != 0 {
+		t.Errorf("unchanged inbound restarted: %s", diff)
+	}
+	if len(diff.AddNodes) != 1 || len(diff.DelNodes) != 3 || len(diff.DelRules) != 1 {
+		t.Errorf("second apply diff: %s", diff)
+	}
+	if ex.Inbounds()["127.0.0.1:0"] != listen {
+		t.Errorf("inbound address changed")
+	}
+
+	got := nodeNames(ex)
+	if len(got) != 2 || got["a"] != nodes["a"] || got["c"] == nil {
+		t.Errorf("nodes after apply: %v", got)
+	}
+
+	if r := h.rule.Export(); len(r) != 1 || r[0].Payload != "a.com" {
+		t.Errorf("rules after apply: %v", r)
+	}
+}
+
+func TestHub_ApplyInboundFail(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	defer busy.Close()
+
+	h, ex := newTestHub(t)
+
+	c, err := ParseConfig([]byte(`
+inbounds:
+  - listen: 127.0.0.1:0
+  - listen: ` + busy.Addr().String() + `
+nodes:
+  - trojan://pass@a.example.com:443#a
+`))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	_, err = h.Apply(c)
+	if err == nil {
+		t.Fatalf("expect listen error")
+	}
+
+	// 已启动的监听需要关闭，节点也不应改动
+	if in := ex.Inbounds(); len(in) != 0 {
+		t.Errorf("inbounds leaked: %v", in)
+	}
+	if n := ex.Nodes(); len(n) != 0 {
+		t.Errorf("nodes applied: %d", len(n))
+	}
+}
+
+func TestHub_ApplySettings(t *testing.T) {
+	h, _ := newTestHub(t)
+
+	c, err := ParseConfig([]byte("selector: {strategy: first}"))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	_, err = h.Apply(c)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	c2, err := ParseConfig([]byte(`
+selector: {strategy: lowest_latency, tolerance: 50ms}
+rank: {latency: 1, speed: 2}
+tls: {skip_verify: true}
+`))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	diff, err := h.Apply(c2)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !diff.Selector || !diff.Rank || !diff.Tls || diff.Geo {
+		t.Errorf("settings diff: %s", diff)
+	}
+
+	// 状态文件不能在运行中切换
+	c3, err := ParseConfig([]byte("state_file: " + filepath.Join(t.TempDir(), "nico.state.yaml")))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	_, err = h.Apply(c3)
+	if err == nil || !strings.Contains(err.Error(), "state_file") {
+		t.Errorf("expect state_file error, got %v", err)
+	}
+
+	diff, err = h.Apply(c2)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if !diff.Empty() {
+		t.Errorf("rejected config applied: %s", diff)
+	}
+}
+
+func TestRefreshConfig_ConfigPath(t *testing.T) {
+	_, ex := newTestHub(t)
+	SetExecutor(ex)
+	defer SetExecutor(nil)
+
+	path := filepath.Join(t.TempDir(), "nico.yaml")
+	err := os.WriteFile(path, []byte("nodes: ['trojan://pass@a.example.com:443#a']"), 0644)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	old := config.ConfigPath()
+	defer config.SetConfigPath(old)
+
+	err = RefreshConfig(path)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if config.ConfigPath() != path {
+		t.Errorf("config path %s, want %s", config.ConfigPath(), path)
+	}
+	if n := config.Current().Nodes; len(n) != 1 {
+		t.Errorf("config not loaded from %s: %v", path, n)
+	}
+}
+
+func TestHub_UpdateSubscriptions(t *testing.T) {
+	sub := atomic.NewString("trojan://pass@s1.example.com:443#s1\ntrojan://pass@s2.example.com:443#s2")
+	fail := atomic.NewBool(false)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(sub.Load()))
+	}))
+	defer srv.Close()
+
+	h, ex := newTestHub(t)
+
+	c, err := ParseConfig([]byte("subscriptions: [{url: " + srv.URL + "}]"))
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	_, err = h.Apply(c)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// 拉取失败时保留原有节点
+	fail.Store(true)
+	h.UpdateSubscriptions(true)
+	if n := len(ex.Nodes()); n != 2 {
+		t.Errorf("got %d nodes after failed update, want 2", n)
+	}
+
+	fail.Store(false)
+	sub.Store("trojan://pass@s2.example.com:443#s2\ntrojan://pass@s3.example.com:443#s3")
+	diff := h.UpdateSubscriptions(true)
+	if len(diff.AddNodes) != 1 || len(diff.DelNodes) != 1 {
+		t.Errorf("update diff: %s", diff)
+	}
+
+	got := nodeNames(ex)
+	if got["s1"] != nil || got["s2"] == nil || got["s3"] == nil {
+		t.Errorf("nodes after update: %v", got)
+	}
+}
+
+func TestHub_Watch(t *testing.T) {
+	h, ex := newTestHub(t)
+
+	path := filepath.Join(t.TempDir(), "nico.yaml")
+	err := os.WriteFile(path, []byte("nodes: ['trojan://pass@a.example.com:443#a']"), 0644)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = h.Watch(ctx, path)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	if n := len(ex.Nodes()); n != 1 {
+		t.Fatalf("got %d nodes, want 1", n)
+	}
+
+	// 非法的配置不生效
+	err = os.WriteFile(path, []byte("nodes: ['trojan://pass@a.example.com:99999']"), 0644)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if n := len(ex.Nodes()); n != 1 {
+		t.Errorf("invalid config applied, got %d nodes", n)
+	}
+
+	// 先写临时文件再重命名
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, []byte("nodes: ['trojan://pass@a.example.com:443#a', 'trojan://pass@b.example.com:443#b']"), 0644)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	deadline := time.Now().Add(time.Second * 3)
+	for len(ex.Nodes()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if n := len(ex.Nodes()); n != 2 {
+		t.Errorf("config not reloaded, got %d nodes", n)
+	}
+}
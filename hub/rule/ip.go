@@ -2,7 +2,7 @@ package rule
 
 import (
 	"fmt"
-	"net"
+	"net/netip"
 
 	"github.com/Dreamacro/clash/constant"
 	"github.com/darabuchi/nico/adapter"
@@ -10,11 +10,11 @@ import (
 
 type SrcIp struct {
 	at adapter.AdapterType
-	ip net.IP
+	ip netip.Addr
 }
 
 func (p *SrcIp) Match(metadata *constant.Metadata) bool {
-	return p.ip.Equal(metadata.SrcIP)
+	return p.ip == metadata.SrcIP.Unmap()
 }
 
 func (p *SrcIp) AdapterType() adapter.AdapterType {
@@ -34,10 +34,11 @@ func (p *SrcIp) Key() string {
 }
 
 func NewSrcIp(ip string, at adapter.AdapterType) (adapter.Rule, error) {
-	i := net.ParseIP(ip)
-	if i == nil {
+	i, err := netip.ParseAddr(ip)
+	if err != nil {
 		return nil, fmt.Errorf("%s is not ip", ip)
 	}
+	i = i.Unmap()
 
 	p := &SrcIp{
 		ip: i,
@@ -49,11 +50,11 @@ func NewSrcIp(ip string, at adapter.AdapterType) (adapter.Rule, error) {
 
 type DstIp struct {
 	at adapter.AdapterType
-	ip net.IP
+	ip netip.Addr
 }
 
 func (p *DstIp) Match(metadata *constant.Metadata) bool {
-	return p.ip.Equal(metadata.DstIP)
+	return p.ip == metadata.DstIP.Unmap()
 }
 
 func (p *DstIp) AdapterType() adapter.AdapterType {
@@ -61,7 +62,7 @@ func (p *DstIp) AdapterType() adapter.AdapterType {
 }
 
 func (p *DstIp) Type() adapter.RuleType {
-	return adapter.ScrIp
+	return adapter.DstIp
 }
 
 func (p *DstIp) Export() adapter.RuleInfo {
@@ -73,10 +74,11 @@ func (p *DstIp) Key() string {
 }
 
 func NewDstIp(ip string, at adapter.AdapterType) (adapter.Rule, error) {
-	i := net.ParseIP(ip)
-	if i == nil {
+	i, err := netip.ParseAddr(ip)
+	if err != nil {
 		return nil, fmt.Errorf("%s is not ip", ip)
 	}
+	i = i.Unmap()
 
 	p := &DstIp{
 		ip: i,
@@ -0,0 +1,177 @@
+package rule
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/adapter"
+	"go.uber.org/atomic"
+)
+
+// LearnedRule 直连失败改走代理后自动学习到的规则
+type LearnedRule struct {
+	adapter.RuleInfo `yaml:",inline"`
+
+	Hits      uint64    `json:"hits" yaml:"hits"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	LastHitAt time.Time `json:"last_hit_at" yaml:"last_hit_at"`
+}
+
+type learnedRule struct {
+	rule adapter.Rule
+
+	createdAt time.Time
+	hits      *atomic.Uint64
+	lastHitAt *atomic.Int64
+}
+
+func (p *learnedRule) info() LearnedRule {
+	info := p.rule.Export()
+	info.Learned = true
+	return LearnedRule{
+		RuleInfo:  info,
+		Hits:      p.hits.Load(),
+		CreatedAt: p.createdAt,
+		LastHitAt: time.Unix(0, p.lastHitAt.Load()),
+	}
+}
+
+// learnedRules 有数量上限和过期时间的规则集合，由 AdapterRule 的锁保护，
+// 命中计数是原子的，匹配时只需要读锁
+type learnedRules struct {
+	ttl time.Duration
+	max int
+
+	rules map[string]*learnedRule
+
+	now func() time.Time
+}
+
+func newLearnedRules(ttl time.Duration, max int) *learnedRules {
+	return &learnedRules{
+		ttl:   ttl,
+		max:   max,
+		rules: map[string]*learnedRule{},
+		now:   time.Now,
+	}
+}
+
+func (p *learnedRules) expired(r *learnedRule, now time.Time) bool {
+	return p.ttl > 0 && now.Sub(time.Unix(0, r.lastHitAt.Load())) > p.ttl
+}
+
+func (p *learnedRules) match(metadata *constant.Metadata) (adapter.Rule, bool) {
+	now := p.now()
+	for _, r := range p.rules {
+		if p.expired(r, now) || !r.rule.Match(metadata) {
+			continue
+		}
+
+		r.hits.Inc()
+		r.lastHitAt.Store(now.UnixNano())
+		return r.rule, true
+	}
+	return nil, false
+}
+
+// learn 记录规则，已存在时刷新最后命中时间，返回是否是新规则
+func (p *learnedRules) learn(rule adapter.Rule) bool {
+	now := p.now()
+
+	key := ruleKey(rule)
+	if old, ok := p.rules[key]; ok && !p.expired(old, now) {
+		old.rule = rule
+		old.lastHitAt.Store(now.UnixNano())
+		return false
+	}
+
+	p.put(&learnedRule{
+		rule:      rule,
+		createdAt: now,
+		hits:      atomic.NewUint64(0),
+		lastHitAt: atomic.NewInt64(now.UnixNano()),
+	})
+
+	return true
+}
+
+func (p *learnedRules) put(r *learnedRule) {
+	p.rules[ruleKey(r.rule)] = r
+	p.evict()
+}
+
+// evict 移除过期的规则，超出上限时移除最久没有命中的
+func (p *learnedRules) evict() int {
+	now := p.now()
+
+	var n int
+	for key, r := range p.rules {
+		if p.expired(r, now) {
+			delete(p.rules, key)
+			n++
+		}
+	}
+
+	for p.max > 0 && len(p.rules) > p.max {
+		var oldestKey string
+		var oldest int64
+		for key, r := range p.rules {
+			if hit := r.lastHitAt.Load(); oldestKey == "" || hit < oldest || (hit == oldest && key < oldestKey) {
+				oldestKey, oldest = key, hit
+			}
+		}
+		delete(p.rules, oldestKey)
+		n++
+	}
+
+	return n
+}
+
+func (p *learnedRules) list() []LearnedRule {
+	now := p.now()
+
+	l := make([]LearnedRule, 0, len(p.rules))
+	for _, r := range p.rules {
+		if p.expired(r, now) {
+			continue
+		}
+		l = append(l, r.info())
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Rule != l[j].Rule {
+			return l[i].Rule < l[j].Rule
+		}
+		return l[i].Payload < l[j].Payload
+	})
+
+	return l
+}
+
+// restore 恢复保存的规则，保留命中次数和时间
+func (p *learnedRules) restore(infos ...LearnedRule) {
+	for _, info := range infos {
+		r, err := NewRule(info.RuleInfo)
+		if err != nil {
+			continue
+		}
+
+		createdAt, lastHitAt := info.CreatedAt, info.LastHitAt
+		if createdAt.IsZero() {
+			createdAt = p.now()
+		}
+		if lastHitAt.IsZero() {
+			lastHitAt = createdAt
+		}
+
+		p.rules[ruleKey(r)] = &learnedRule{
+			rule:      r,
+			createdAt: createdAt,
+			hits:      atomic.NewUint64(info.Hits),
+			lastHitAt: atomic.NewInt64(lastHitAt.UnixNano()),
+		}
+	}
+
+	p.evict()
+}
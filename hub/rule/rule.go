@@ -1,14 +1,19 @@
 package rule
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Dreamacro/clash/constant"
 	"github.com/darabuchi/log"
 	"github.com/darabuchi/nico/adapter"
 	"github.com/darabuchi/nico/config"
-	"github.com/spf13/viper"
-	"gopkg.in/yaml.v3"
+)
+
+const (
+	stateKey        = "rule"
+	learnedStateKey = "learned"
 )
 
 var ar = NewAdapterRule()
@@ -21,49 +26,118 @@ func AddRules(rules ...adapter.Rule) *AdapterRule {
 	return ar.AddRule(rules...)
 }
 
+func Learn(rules ...adapter.Rule) *AdapterRule {
+	return ar.Learn(rules...)
+}
+
+func Learned() []LearnedRule {
+	return ar.Learned()
+}
+
+func Promote(infos ...adapter.RuleInfo) int {
+	return ar.Promote(infos...)
+}
+
+func Purge(infos ...adapter.RuleInfo) int {
+	return ar.Purge(infos...)
+}
+
 func Sync() {
 	ar.Sync()
 }
 
+func Export() []adapter.RuleInfo {
+	return ar.Export()
+}
+
 func GetAdapterRule() *AdapterRule {
 	return ar
 }
 
+// ruleKey 同一内容的不同类型规则互不覆盖
+func ruleKey(rule adapter.Rule) string {
+	return rule.Type().String() + "," + rule.Key()
+}
+
+// infoKey 经过 NewRule 规范化后的 key，与 ruleKey 一致（如 2001:DB8::1 与 2001:db8::1）
+func infoKey(info adapter.RuleInfo) (string, bool) {
+	rule, err := NewRule(info)
+	if err != nil {
+		log.Warnf("invalid rule %s,%s:%v", info.Rule, info.Payload, err)
+		return "", false
+	}
+
+	return ruleKey(rule), true
+}
+
 type AdapterRule struct {
 	lock    sync.RWMutex
 	ruleMap map[string]adapter.Rule
 
-	c *viper.Viper
+	// saved 需要保存到状态文件的规则，配置文件中声明的规则（PutRule）由配置维护，不保存
+	saved map[string]bool
+
+	learned *learnedRules
+
+	// memory 不读写状态文件，同一进程中的多个 Executor 各自使用时不会互相覆盖
+	memory bool
+}
+
+// NewMemoryAdapterRule 只保存在内存中的规则，不读取也不保存状态文件
+func NewMemoryAdapterRule() *AdapterRule {
+	cfg := config.Current()
+
+	return &AdapterRule{
+		ruleMap: map[string]adapter.Rule{},
+		saved:   map[string]bool{},
+		learned: newLearnedRules(cfg.Learn.TTL, cfg.Learn.Max),
+		memory:  true,
+	}
 }
 
 func NewAdapterRule() *AdapterRule {
+	cfg := config.Current()
+
 	p := &AdapterRule{
 		ruleMap: map[string]adapter.Rule{},
+		saved:   map[string]bool{},
+		learned: newLearnedRules(cfg.Learn.TTL, cfg.Learn.Max),
+	}
+
+	var infos []adapter.RuleInfo
+	err := config.GetState(stateKey, &infos)
+	if err != nil {
+		log.Errorf("err:%v", err)
 	}
 
-	value := config.Get("rule")
-	if value != nil {
-		b, err := yaml.Marshal(value)
+	for _, info := range infos {
+		r, err := NewRule(info)
 		if err != nil {
 			log.Errorf("err:%v", err)
 		} else {
-			var l []adapter.RuleInfo
-			err = yaml.Unmarshal(b, &l)
-			if err != nil {
-				log.Errorf("err:%v", err)
-			} else {
-				for _, info := range l {
-					r, err := NewRule(info)
-					if err != nil {
-						log.Errorf("err:%v", err)
-					} else {
-						p.addRule(r)
-					}
-				}
-			}
+			p.addRule(r)
 		}
 	}
 
+	var learned []LearnedRule
+	err = config.GetState(learnedStateKey, &learned)
+	if err != nil {
+		log.Errorf("err:%v", err)
+	}
+
+	// 旧版本保存在 nico.yaml 中的规则都是自动学习的，且目标 ip 被错误地记成了 ScrIp，
+	// 迁移为学习到的 DstIp 规则，Sync 时从 nico.yaml 中移除
+	for _, info := range cfg.Rule {
+		if info.Rule == adapter.ScrIp.String() {
+			info.Rule = adapter.DstIp.String()
+		}
+		learned = append(learned, LearnedRule{
+			RuleInfo: info,
+		})
+	}
+
+	p.learned.restore(learned...)
+
 	return p
 }
 
@@ -77,15 +151,148 @@ func (p *AdapterRule) addRule(rules ...adapter.Rule) {
 	defer p.lock.Unlock()
 
 	for _, rule := range rules {
-		if _, ok := p.ruleMap[rule.Key()]; !ok {
+		if _, ok := p.ruleMap[ruleKey(rule)]; !ok {
 			ex := rule.Export()
 			log.Infof("add rule %s,%s,%s", ex.Rule, ex.Payload, ex.Adapter)
 
-			p.ruleMap[rule.Key()] = rule
+			p.ruleMap[ruleKey(rule)] = rule
+			p.saved[ruleKey(rule)] = true
+			delete(p.learned.rules, ruleKey(rule))
+		}
+	}
+}
+
+// PutRule 添加规则，覆盖 key 相同的已有规则和学习到的规则
+func (p *AdapterRule) PutRule(rules ...adapter.Rule) *AdapterRule {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, rule := range rules {
+		ex := rule.Export()
+		log.Infof("put rule %s,%s,%s", ex.Rule, ex.Payload, ex.Adapter)
+
+		p.ruleMap[ruleKey(rule)] = rule
+		delete(p.saved, ruleKey(rule))
+		delete(p.learned.rules, ruleKey(rule))
+	}
+
+	return p
+}
+
+// DelRule 删除规则，只有类型、内容和出口都一致时才删除
+func (p *AdapterRule) DelRule(rules ...adapter.Rule) *AdapterRule {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, rule := range rules {
+		old, ok := p.ruleMap[ruleKey(rule)]
+		if !ok || old.Export() != rule.Export() {
+			continue
 		}
+
+		ex := rule.Export()
+		log.Infof("del rule %s,%s,%s", ex.Rule, ex.Payload, ex.Adapter)
+
+		delete(p.ruleMap, ruleKey(rule))
+		delete(p.saved, ruleKey(rule))
 	}
+
+	return p
+}
+
+// SetLearnLimit 修改学习到的规则的过期时间和数量上限
+func (p *AdapterRule) SetLearnLimit(ttl time.Duration, max int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.learned.ttl, p.learned.max = ttl, max
+	p.learned.evict()
 }
 
+// Learn 记录自动学习到的规则，已有相同的常驻规则时忽略
+func (p *AdapterRule) Learn(rules ...adapter.Rule) *AdapterRule {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, rule := range rules {
+		if _, ok := p.ruleMap[ruleKey(rule)]; ok {
+			continue
+		}
+
+		if p.learned.learn(rule) {
+			ex := rule.Export()
+			log.Infof("learn rule %s,%s,%s", ex.Rule, ex.Payload, ex.Adapter)
+		}
+	}
+
+	return p
+}
+
+// Learned 未过期的学习到的规则
+func (p *AdapterRule) Learned() []LearnedRule {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.learned.list()
+}
+
+// Promote 把学习到的规则转为常驻规则，返回转换的数量
+func (p *AdapterRule) Promote(infos ...adapter.RuleInfo) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var n int
+	for _, info := range infos {
+		key, ok := infoKey(info)
+		if !ok {
+			continue
+		}
+
+		r, ok := p.learned.rules[key]
+		if !ok {
+			continue
+		}
+
+		ex := r.rule.Export()
+		log.Infof("promote rule %s,%s,%s", ex.Rule, ex.Payload, ex.Adapter)
+
+		delete(p.learned.rules, key)
+		p.ruleMap[key] = r.rule
+		p.saved[key] = true
+		n++
+	}
+
+	return n
+}
+
+// Purge 删除学习到的规则，不传参数时删除全部，返回删除的数量
+func (p *AdapterRule) Purge(infos ...adapter.RuleInfo) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if len(infos) == 0 {
+		n := len(p.learned.rules)
+		p.learned.rules = map[string]*learnedRule{}
+		return n
+	}
+
+	var n int
+	for _, info := range infos {
+		key, ok := infoKey(info)
+		if !ok {
+			continue
+		}
+
+		if _, ok := p.learned.rules[key]; ok {
+			delete(p.learned.rules, key)
+			n++
+		}
+	}
+
+	return n
+}
+
+// Match 常驻规则优先，其次是学习到的规则
 func (p *AdapterRule) Match(metadata *constant.Metadata) adapter.AdapterType {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
@@ -96,17 +303,63 @@ func (p *AdapterRule) Match(metadata *constant.Metadata) adapter.AdapterType {
 		}
 	}
 
+	if rule, ok := p.learned.match(metadata); ok {
+		return rule.AdapterType()
+	}
+
 	return adapter.Direct
 }
 
-func (p *AdapterRule) Sync() {
+// Export 所有生效的规则，学习到的规则带有 Learned 标记
+func (p *AdapterRule) Export() []adapter.RuleInfo {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
-	var l []adapter.RuleInfo
 
+	l := make([]adapter.RuleInfo, 0, len(p.ruleMap)+len(p.learned.rules))
 	for _, rule := range p.ruleMap {
 		l = append(l, rule.Export())
 	}
+	for _, r := range p.learned.list() {
+		l = append(l, r.RuleInfo)
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Rule != l[j].Rule {
+			return l[i].Rule < l[j].Rule
+		}
+		if l[i].Payload != l[j].Payload {
+			return l[i].Payload < l[j].Payload
+		}
+		return !l[i].Learned && l[j].Learned
+	})
+
+	return l
+}
+
+// Sync 把常驻规则和学习到的规则保存到状态文件，并移除 nico.yaml 中旧版本保存的规则
+func (p *AdapterRule) Sync() {
+	if p.memory {
+		return
+	}
+
+	p.lock.Lock()
+	p.learned.evict()
+
+	saved := make([]adapter.RuleInfo, 0, len(p.saved))
+	for key := range p.saved {
+		saved = append(saved, p.ruleMap[key].Export())
+	}
+	learned := p.learned.list()
+	p.lock.Unlock()
+
+	sort.Slice(saved, func(i, j int) bool {
+		if saved[i].Rule != saved[j].Rule {
+			return saved[i].Rule < saved[j].Rule
+		}
+		return saved[i].Payload < saved[j].Payload
+	})
 
-	config.Set("rule", l)
+	config.SetState(stateKey, saved)
+	config.SetState(learnedStateKey, learned)
+	config.Unset(stateKey)
 }
@@ -0,0 +1,132 @@
+package rule
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/adapter"
+)
+
+func mustRule(t *testing.T, info adapter.RuleInfo) adapter.Rule {
+	r, err := NewRule(info)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	return r
+}
+
+func TestDstIp(t *testing.T) {
+	r := mustRule(t, adapter.RuleInfo{Rule: "DstIp", Payload: "1.1.1.1", Adapter: "Proxy"})
+	if r.Type() != adapter.DstIp || r.Export().Rule != "DstIp" {
+		t.Errorf("type got %v", r.Export())
+	}
+
+	if !r.Match(&constant.Metadata{DstIP: netip.MustParseAddr("1.1.1.1")}) {
+		t.Errorf("should match dst ip")
+	}
+	if r.Match(&constant.Metadata{SrcIP: netip.MustParseAddr("1.1.1.1")}) {
+		t.Errorf("should not match src ip")
+	}
+
+	// 同一个 ip 的 SrcIp 和 DstIp 规则互不覆盖
+	p := &AdapterRule{ruleMap: map[string]adapter.Rule{}, saved: map[string]bool{}, learned: newLearnedRules(0, 0)}
+	p.AddRule(r, mustRule(t, adapter.RuleInfo{Rule: "ScrIp", Payload: "1.1.1.1", Adapter: "Reject"}))
+	if len(p.Export()) != 2 {
+		t.Errorf("export got %v", p.Export())
+	}
+}
+
+func TestAdapterRule_Learn(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	p := &AdapterRule{ruleMap: map[string]adapter.Rule{}, saved: map[string]bool{}, learned: newLearnedRules(time.Hour, 2)}
+	p.learned.now = func() time.Time { return now }
+
+	a := adapter.RuleInfo{Rule: "Domain", Payload: "a.com", Adapter: "Proxy"}
+	b := adapter.RuleInfo{Rule: "Domain", Payload: "b.com", Adapter: "Proxy"}
+	c := adapter.RuleInfo{Rule: "DstIp", Payload: "1.1.1.1", Adapter: "Proxy"}
+
+	p.Learn(mustRule(t, a))
+	now = now.Add(time.Minute)
+	p.Learn(mustRule(t, b))
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		if got := p.Match(&constant.Metadata{Host: "a.com"}); got != adapter.Proxy {
+			t.Errorf("match got %v", got)
+		}
+	}
+
+	l := p.Learned()
+	if len(l) != 2 || l[0].Payload != "a.com" || l[0].Hits != 3 || !l[0].Learned {
+		t.Errorf("learned got %+v", l)
+	}
+
+	// 超出上限时移除最久没有命中的 b.com
+	now = now.Add(time.Minute)
+	p.Learn(mustRule(t, c))
+	if l = p.Learned(); len(l) != 2 || l[0].Payload != "a.com" || l[1].Payload != "1.1.1.1" {
+		t.Errorf("evict got %+v", l)
+	}
+
+	// 常驻规则覆盖学习到的规则，且不会重复学习
+	p.PutRule(mustRule(t, adapter.RuleInfo{Rule: "Domain", Payload: "a.com", Adapter: "Direct"}))
+	p.Learn(mustRule(t, a))
+	if got := p.Match(&constant.Metadata{Host: "a.com"}); got != adapter.Direct {
+		t.Errorf("match got %v", got)
+	}
+
+	ex := p.Export()
+	if len(ex) != 2 || ex[0].Payload != "a.com" || ex[0].Learned || !ex[1].Learned {
+		t.Errorf("export got %+v", ex)
+	}
+
+	// 过期
+	now = now.Add(time.Hour * 2)
+	if got := p.Match(&constant.Metadata{DstIP: netip.MustParseAddr("1.1.1.1")}); got != adapter.Direct {
+		t.Errorf("expired rule should not match, got %v", got)
+	}
+	if l = p.Learned(); len(l) != 0 {
+		t.Errorf("learned got %+v", l)
+	}
+}
+
+func TestAdapterRule_Promote(t *testing.T) {
+	p := &AdapterRule{ruleMap: map[string]adapter.Rule{}, saved: map[string]bool{}, learned: newLearnedRules(0, 0)}
+
+	a := adapter.RuleInfo{Rule: "Domain", Payload: "a.com", Adapter: "Proxy"}
+	b := adapter.RuleInfo{Rule: "Domain", Payload: "b.com", Adapter: "Proxy"}
+	c := adapter.RuleInfo{Rule: "Domain", Payload: "c.com", Adapter: "Proxy"}
+	p.Learn(mustRule(t, a), mustRule(t, b), mustRule(t, c))
+
+	if n := p.Promote(a, adapter.RuleInfo{Rule: "Domain", Payload: "x.com"}); n != 1 {
+		t.Errorf("promote got %d", n)
+	}
+	if !p.saved["Domain,a.com"] || len(p.Learned()) != 2 {
+		t.Errorf("promote failed: saved %v, learned %+v", p.saved, p.Learned())
+	}
+
+	if n := p.Purge(b); n != 1 {
+		t.Errorf("purge got %d", n)
+	}
+	if n := p.Purge(); n != 1 {
+		t.Errorf("purge all got %d", n)
+	}
+
+	ex := p.Export()
+	if len(ex) != 1 || ex[0] != a {
+		t.Errorf("export got %+v", ex)
+	}
+
+	// 与学习时的规则按同样方式规范化
+	v6 := adapter.RuleInfo{Rule: "DstIp", Payload: "2001:db8::1", Adapter: "Proxy"}
+	p.Learn(mustRule(t, v6))
+	if n := p.Promote(adapter.RuleInfo{Rule: "DstIp", Payload: "2001:DB8::1"}); n != 1 {
+		t.Errorf("promote ipv6 got %d", n)
+	}
+	if n := p.Purge(adapter.RuleInfo{Rule: "DstIp", Payload: "not ip"}); n != 0 {
+		t.Errorf("purge invalid got %d", n)
+	}
+}
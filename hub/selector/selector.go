@@ -0,0 +1,83 @@
+package selector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/adapter"
+)
+
+const (
+	First          = "first"
+	LowestLatency  = "lowest_latency"
+	RoundRobin     = "round_robin"
+	LeastConn      = "least_conn"
+	ConsistentHash = "consistent_hash"
+	RandomTopN     = "random_top_n"
+)
+
+// Selector 从可用节点中为一次连接选出一个节点，nodes 为空时返回 nil
+// nodes 按优劣排好序，metadata 可能为空
+type Selector interface {
+	Select(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy
+}
+
+// Tracker 需要感知连接生命周期的策略实现，连接结束后调用 release
+type Tracker interface {
+	Acquire(node adapter.AdapterProxy) (release func())
+}
+
+// Config 对应 nico.yaml 中的 selector
+type Config struct {
+	// Strategy 为空时使用 first，与之前的行为一致
+	Strategy string `yaml:"strategy"`
+
+	// Tolerance lowest_latency 下，当前节点与最优节点的延迟差在此范围内时不切换
+	Tolerance time.Duration `yaml:"tolerance"`
+
+	// TopN random_top_n 下参与随机的节点数
+	TopN int `yaml:"top_n"`
+}
+
+func New(c Config) (Selector, error) {
+	switch c.Strategy {
+	case "", First:
+		return NewFirst(), nil
+	case LowestLatency:
+		return NewLowestLatency(c.Tolerance), nil
+	case RoundRobin:
+		return NewRoundRobin(nil), nil
+	case LeastConn:
+		return NewLeastConn(), nil
+	case ConsistentHash:
+		return NewConsistentHash(), nil
+	case RandomTopN:
+		return NewRandomTopN(c.TopN), nil
+	default:
+		return nil, fmt.Errorf("unknown selector strategy %q", c.Strategy)
+	}
+}
+
+type first struct{}
+
+// NewFirst 总是选第一个节点
+func NewFirst() Selector {
+	return first{}
+}
+
+func (first) Select(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy {
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// delayOf 未测出延迟的节点视为最慢
+func delayOf(node adapter.AdapterProxy) time.Duration {
+	delay := node.LoadUint16(adapter.CacheDelay)
+	if delay == 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(delay) * time.Millisecond
+}
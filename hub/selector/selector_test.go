@@ -0,0 +1,202 @@
+package selector
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/adapter"
+	"gopkg.in/yaml.v3"
+)
+
+func newNodes(t *testing.T, delays ...uint16) adapter.ProxyList {
+	var list adapter.ProxyList
+	for i, delay := range delays {
+		node, err := adapter.ParseClash(map[string]any{"name": string(rune('a' + i)), "type": "trojan", "server": string(rune('a'+i)) + ".example.com", "port": 443, "password": "pass"})
+		if err != nil {
+			t.Fatalf("err:%v", err)
+		}
+		node.Store(adapter.CacheAlive, true)
+		node.Store(adapter.CacheDelay, delay)
+		list = append(list, node)
+	}
+	return list
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		want    Selector
+		wantErr bool
+	}{
+		{yaml: "{}", want: first{}},
+		{yaml: "strategy: lowest_latency\ntolerance: 50ms", want: &lowestLatency{tolerance: 50 * time.Millisecond}},
+		{yaml: "strategy: random_top_n\ntop_n: 5", want: &randomTopN{n: 5}},
+		{yaml: "strategy: fastest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.yaml, func(t *testing.T) {
+			var c Config
+			err := yaml.Unmarshal([]byte(tt.yaml), &c)
+			if err != nil {
+				t.Errorf("err:%v", err)
+				return
+			}
+
+			got, err := New(c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			switch want := tt.want.(type) {
+			case *lowestLatency:
+				if got.(*lowestLatency).tolerance != want.tolerance {
+					t.Errorf("tolerance got %v, want %v", got.(*lowestLatency).tolerance, want.tolerance)
+				}
+			case *randomTopN:
+				if got.(*randomTopN).n != want.n {
+					t.Errorf("top_n got %v, want %v", got.(*randomTopN).n, want.n)
+				}
+			default:
+				if got != tt.want {
+					t.Errorf("New() got %T, want %T", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectEmpty(t *testing.T) {
+	for _, s := range []Selector{NewFirst(), NewLowestLatency(0), NewRoundRobin(nil), NewLeastConn(), NewConsistentHash(), NewRandomTopN(0)} {
+		if got := s.Select(nil, nil); got != nil {
+			t.Errorf("%T select from empty list got %v", s, got.Name())
+		}
+	}
+}
+
+func TestLowestLatency(t *testing.T) {
+	nodes := newNodes(t, 0, 200, 120)
+	s := NewLowestLatency(50 * time.Millisecond)
+
+	if got := s.Select(nodes, nil); got.Name() != "c" {
+		t.Errorf("got %s, want c", got.Name())
+	}
+
+	// 差距在容忍范围内，不切换
+	nodes[1].Store(adapter.CacheDelay, 100)
+	if got := s.Select(nodes, nil); got.Name() != "c" {
+		t.Errorf("got %s, want c", got.Name())
+	}
+
+	nodes[1].Store(adapter.CacheDelay, 60)
+	if got := s.Select(nodes, nil); got.Name() != "b" {
+		t.Errorf("got %s, want b", got.Name())
+	}
+
+	// 当前节点下线后换到剩下最快的
+	if got := s.Select(adapter.ProxyList{nodes[0], nodes[2]}, nil); got.Name() != "c" {
+		t.Errorf("got %s, want c", got.Name())
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	nodes := newNodes(t, 100, 100, 100)
+	weights := map[string]int{"a": 5, "b": 1, "c": 1}
+	s := NewRoundRobin(func(node adapter.AdapterProxy) int {
+		return weights[node.Name()]
+	})
+
+	count := map[string]int{}
+	var seq string
+	for i := 0; i < 7; i++ {
+		name := s.Select(nodes, nil).Name()
+		count[name]++
+		seq += name
+	}
+
+	for name, w := range weights {
+		if count[name] != w {
+			t.Errorf("%s selected %d times, want %d", name, count[name], w)
+		}
+	}
+
+	// 平滑轮询不会连续把权重小的节点排在一起
+	if seq != "aabacaa" {
+		t.Errorf("sequence got %s, want aabacaa", seq)
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	nodes := newNodes(t, 100, 100)
+	s := NewLeastConn()
+	tracker := s.(Tracker)
+
+	a := s.Select(nodes, nil)
+	if a.Name() != "a" {
+		t.Errorf("got %s, want a", a.Name())
+	}
+	release := tracker.Acquire(a)
+
+	if got := s.Select(nodes, nil); got.Name() != "b" {
+		t.Errorf("got %s, want b", got.Name())
+	}
+
+	release()
+	release()
+	if got := s.Select(nodes, nil); got.Name() != "a" {
+		t.Errorf("got %s, want a", got.Name())
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	nodes := newNodes(t, 100, 100, 100, 100)
+	s := NewConsistentHash()
+
+	hosts := []string{"google.com", "github.com", "example.com", "golang.org", "youtube.com", "twitter.com"}
+	picked := map[string]string{}
+	for _, host := range hosts {
+		m := &constant.Metadata{Host: host}
+		picked[host] = s.Select(nodes, m).Name()
+		for i := 0; i < 3; i++ {
+			if got := s.Select(nodes, m).Name(); got != picked[host] {
+				t.Errorf("%s not sticky, got %s then %s", host, picked[host], got)
+			}
+		}
+	}
+
+	// 移除一个节点只影响原本落在它上面的目标
+	removed := nodes[0]
+	for _, host := range hosts {
+		got := s.Select(nodes[1:], &constant.Metadata{Host: host}).Name()
+		if picked[host] != removed.Name() && got != picked[host] {
+			t.Errorf("%s moved from %s to %s", host, picked[host], got)
+		}
+	}
+
+	ip := &constant.Metadata{DstIP: netip.MustParseAddr("1.1.1.1")}
+	if s.Select(nodes, ip) != s.Select(nodes, ip) {
+		t.Errorf("ip destination not sticky")
+	}
+}
+
+func TestRandomTopN(t *testing.T) {
+	nodes := newNodes(t, 100, 100, 100, 100)
+	s := NewRandomTopN(2)
+
+	count := map[string]int{}
+	for i := 0; i < 200; i++ {
+		count[s.Select(nodes, nil).Name()]++
+	}
+
+	if count["c"] != 0 || count["d"] != 0 {
+		t.Errorf("selected outside top 2: %v", count)
+	}
+	if count["a"] == 0 || count["b"] == 0 {
+		t.Errorf("top 2 not both selected: %v", count)
+	}
+}
@@ -0,0 +1,248 @@
+package selector
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/darabuchi/nico/adapter"
+)
+
+type lowestLatency struct {
+	tolerance time.Duration
+
+	lock    sync.Mutex
+	current string
+}
+
+// NewLowestLatency 选延迟最低的节点，当前节点与最优节点相差不超过 tolerance 时不切换，避免来回抖动
+func NewLowestLatency(tolerance time.Duration) Selector {
+	return &lowestLatency{
+		tolerance: tolerance,
+	}
+}
+
+func (p *lowestLatency) Select(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	best := nodes[0]
+	var current adapter.AdapterProxy
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, node := range nodes {
+		if delayOf(node) < delayOf(best) {
+			best = node
+		}
+		if node.UniqueId() == p.current {
+			current = node
+		}
+	}
+
+	if current != nil && delayOf(current) <= delayOf(best)+p.tolerance {
+		return current
+	}
+
+	p.current = best.UniqueId()
+	return best
+}
+
+type roundRobin struct {
+	weight func(node adapter.AdapterProxy) int
+
+	lock    sync.Mutex
+	current map[string]int
+}
+
+// NewRoundRobin 平滑加权轮询，weight 为空时按节点评分计算权重
+func NewRoundRobin(weight func(node adapter.AdapterProxy) int) Selector {
+	if weight == nil {
+		weight = scoreWeight
+	}
+
+	return &roundRobin{
+		weight:  weight,
+		current: map[string]int{},
+	}
+}
+
+func scoreWeight(node adapter.AdapterProxy) int {
+	w := int(math.Round(node.Score() * 10))
+	if w < 1 {
+		return 1
+	}
+	return w
+}
+
+func (p *roundRobin) Select(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var best adapter.AdapterProxy
+	var total int
+	seen := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		w := p.weight(node)
+		if w < 1 {
+			w = 1
+		}
+		total += w
+
+		id := node.UniqueId()
+		seen[id] = true
+		p.current[id] += w
+		if best == nil || p.current[id] > p.current[best.UniqueId()] {
+			best = node
+		}
+	}
+
+	// 已经下线的节点不再参与
+	for id := range p.current {
+		if !seen[id] {
+			delete(p.current, id)
+		}
+	}
+
+	p.current[best.UniqueId()] -= total
+	return best
+}
+
+type leastConn struct {
+	lock   sync.Mutex
+	active map[string]int
+}
+
+// NewLeastConn 选当前活跃连接最少的节点，数量相同时取排在前面的
+func NewLeastConn() Selector {
+	return &leastConn{
+		active: map[string]int{},
+	}
+}
+
+func (p *leastConn) Select(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	best := nodes[0]
+	for _, node := range nodes[1:] {
+		if p.active[node.UniqueId()] < p.active[best.UniqueId()] {
+			best = node
+		}
+	}
+
+	return best
+}
+
+func (p *leastConn) Acquire(node adapter.AdapterProxy) func() {
+	id := node.UniqueId()
+
+	p.lock.Lock()
+	p.active[id]++
+	p.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+
+			p.active[id]--
+			if p.active[id] <= 0 {
+				delete(p.active, id)
+			}
+		})
+	}
+}
+
+type consistentHash struct{}
+
+// NewConsistentHash 按目标域名做一致性哈希（rendezvous），同一目标总是落在同一节点上，
+// 节点增减时只影响原本落在该节点上的目标
+func NewConsistentHash() Selector {
+	return consistentHash{}
+}
+
+func (consistentHash) Select(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	key := hashKey(metadata)
+	if key == "" {
+		return nodes[0]
+	}
+
+	var best adapter.AdapterProxy
+	var bestSum uint64
+	for _, node := range nodes {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(node.UniqueId()))
+
+		sum := h.Sum64()
+		if best == nil || sum > bestSum {
+			best, bestSum = node, sum
+		}
+	}
+
+	return best
+}
+
+func hashKey(metadata *constant.Metadata) string {
+	if metadata == nil {
+		return ""
+	}
+
+	if metadata.Host != "" {
+		return metadata.Host
+	}
+
+	if metadata.DstIP.IsValid() {
+		return metadata.DstIP.String()
+	}
+
+	return ""
+}
+
+type randomTopN struct {
+	n int
+}
+
+// NewRandomTopN 从排在最前的 n 个节点中随机选一个
+func NewRandomTopN(n int) Selector {
+	if n <= 0 {
+		n = 3
+	}
+
+	return &randomTopN{
+		n: n,
+	}
+}
+
+func (p *randomTopN) Select(nodes adapter.ProxyList, metadata *constant.Metadata) adapter.AdapterProxy {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	n := p.n
+	if n > len(nodes) {
+		n = len(nodes)
+	}
+
+	return nodes[rand.Intn(n)]
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/darabuchi/log"
+)
+
+// FileStore 以 json 文件保存，写入时先写临时文件再 rename，避免中途退出损坏文件
+type FileStore struct {
+	lock sync.Mutex
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+type fileContent struct {
+	Version int          `json:"version"`
+	Nodes   []*NodeState `json:"nodes"`
+}
+
+const fileVersion = 1
+
+func (p *FileStore) Load() ([]*NodeState, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	buf, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	var c fileContent
+	err = json.Unmarshal(buf, &c)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return nil, err
+	}
+
+	return c.Nodes, nil
+}
+
+func (p *FileStore) Save(states []*NodeState) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	buf, err := json.Marshal(&fileContent{
+		Version: fileVersion,
+		Nodes:   states,
+	})
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	dir := filepath.Dir(p.path)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	err = os.Rename(f.Name(), p.path)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	return nil
+}
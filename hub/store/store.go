@@ -0,0 +1,86 @@
+package store
+
+import (
+	"time"
+
+	"github.com/darabuchi/nico/adapter"
+)
+
+// NodeState 需要跨重启保留的节点状态，以 UniqueId 区分
+type NodeState struct {
+	UniqueId string `json:"unique_id"`
+
+	// Node 节点定义，即 ToNico() 的结果
+	Node map[string]any `json:"node"`
+
+	Alive    bool    `json:"alive"`
+	Delay    uint16  `json:"delay"`
+	Speed    float64 `json:"speed"`
+	SpeedStr string  `json:"speed_str,omitempty"`
+	Source   string  `json:"source,omitempty"`
+	AddedAt  int64   `json:"added_at,omitempty"`
+
+	DelayHistory []adapter.DelayRecord `json:"delay_history,omitempty"`
+	SpeedHistory []adapter.SpeedRecord `json:"speed_history,omitempty"`
+
+	Upload   uint64 `json:"upload"`
+	Download uint64 `json:"download"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store 节点状态的持久化
+type Store interface {
+	Load() ([]*NodeState, error)
+	Save(states []*NodeState) error
+}
+
+// Dump 生成节点的状态快照
+func Dump(node adapter.AdapterProxy) *NodeState {
+	return &NodeState{
+		UniqueId:     node.UniqueId(),
+		Node:         node.ToNico(),
+		Alive:        node.LoadBool(adapter.CacheAlive),
+		Delay:        node.LoadUint16(adapter.CacheDelay),
+		Speed:        node.LoadFloat64(adapter.CacheSpeed),
+		SpeedStr:     node.LoadString(adapter.CacheSpeedStr),
+		Source:       node.LoadString(adapter.CacheSource),
+		AddedAt:      node.LoadInt64(adapter.CacheAddedAt),
+		DelayHistory: node.DelayRecords(),
+		SpeedHistory: adapter.LoadHistory[adapter.SpeedRecord](node, adapter.CacheSpeedHistory),
+		Upload:       node.GetTotalUpload(),
+		Download:     node.GetTotalDownload(),
+		UpdatedAt:    time.Now(),
+	}
+}
+
+// Restore 由快照重建节点并恢复缓存
+func Restore(state *NodeState) (adapter.AdapterProxy, error) {
+	node, err := adapter.ParseClash(state.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	node.Store(adapter.CacheAlive, state.Alive)
+	node.Store(adapter.CacheDelay, state.Delay)
+	node.Store(adapter.CacheSpeed, state.Speed)
+	if state.SpeedStr != "" {
+		node.Store(adapter.CacheSpeedStr, state.SpeedStr)
+	}
+	if state.Source != "" {
+		node.Store(adapter.CacheSource, state.Source)
+	}
+	if state.AddedAt != 0 {
+		node.Store(adapter.CacheAddedAt, state.AddedAt)
+	}
+	for _, r := range state.DelayHistory {
+		node.RecordDelay(r)
+	}
+	if len(state.SpeedHistory) > 0 {
+		node.Store(adapter.CacheSpeedHistory, state.SpeedHistory)
+	}
+
+	node.RestoreTotal(state.Upload, state.Download)
+
+	return node, nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/darabuchi/nico/adapter"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "nico.json")
+
+	s := NewFileStore(path)
+
+	states, err := s.Load()
+	if err != nil || len(states) != 0 {
+		t.Errorf("load empty store got %v, err:%v", states, err)
+		return
+	}
+
+	node, err := adapter.ParseClash(map[string]any{"name": "trojan", "type": "trojan", "server": "trojan.example.com", "port": 443, "password": "pass", "country_code": "JP"})
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	node.Store(adapter.CacheAlive, true)
+	node.Store(adapter.CacheDelay, uint16(120))
+	node.Store(adapter.CacheSpeed, 512.5)
+	node.Store(adapter.CacheSpeedStr, "4.00Mbps")
+	node.Store(adapter.CacheSource, "https://sub.example.com")
+	node.RecordDelay(adapter.DelayRecord{Time: time.Unix(1, 0), Delay: 100})
+	node.RecordDelay(adapter.DelayRecord{Time: time.Unix(2, 0)})
+	node.RestoreTotal(10, 20)
+
+	err = s.Save([]*NodeState{Dump(node)})
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("temp file should be removed, got %v, err:%v", entries, err)
+	}
+
+	states, err = NewFileStore(path).Load()
+	if err != nil || len(states) != 1 {
+		t.Errorf("got %v, err:%v", states, err)
+		return
+	}
+
+	restored, err := Restore(states[0])
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	if restored.UniqueId() != node.UniqueId() {
+		t.Errorf("unique id changed %s -> %s", node.UniqueId(), restored.UniqueId())
+	}
+	if restored.Name() != "trojan" || restored.GetExtraInfo().CountryCode != "JP" {
+		t.Errorf("got %s %+v", restored.Name(), restored.GetExtraInfo())
+	}
+	if !restored.LoadBool(adapter.CacheAlive) || restored.LoadUint16(adapter.CacheDelay) != 120 || restored.LoadFloat64(adapter.CacheSpeed) != 512.5 {
+		t.Errorf("cache not restored")
+	}
+	if restored.LoadString(adapter.CacheSource) != "https://sub.example.com" {
+		t.Errorf("source not restored")
+	}
+
+	history := restored.DelayRecords()
+	if len(history) != 2 || history[0].Delay != 100 || !history[1].Time.Equal(time.Unix(2, 0)) {
+		t.Errorf("got history %+v", history)
+	}
+
+	if restored.GetTotalUpload() != 10 || restored.GetTotalDownload() != 20 {
+		t.Errorf("traffic not restored")
+	}
+}
+
+func TestFileStore_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nico.json")
+	err := os.WriteFile(path, []byte("{"), 0644)
+	if err != nil {
+		t.Errorf("err:%v", err)
+		return
+	}
+
+	_, err = NewFileStore(path).Load()
+	if err == nil {
+		t.Errorf("expect error")
+	}
+}
@@ -0,0 +1,90 @@
+package hub
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/darabuchi/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchConfig 应用配置文件，并在文件变化时自动重新加载，直到 ctx 取消
+func WatchConfig(ctx context.Context, filePath string) error {
+	useConfigPath(filePath)
+
+	return defaultHub.Watch(ctx, filePath)
+}
+
+// Watch 先应用一次配置，之后文件变化时重新加载，同时按间隔更新订阅
+// 监听的是文件所在目录，编辑器先写临时文件再重命名的保存方式也能感知到
+func (h *Hub) Watch(ctx context.Context, filePath string) error {
+	_, err := h.Refresh(filePath)
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Errorf("err:%v", err)
+		return err
+	}
+
+	err = watcher.Add(filepath.Dir(filePath))
+	if err != nil {
+		log.Errorf("err:%v", err)
+		_ = watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		// 一次保存往往会触发多个事件，合并后再加载
+		debounce := time.NewTimer(time.Hour)
+		debounce.Stop()
+		defer debounce.Stop()
+
+		subTicker := time.NewTicker(time.Minute)
+		defer subTicker.Stop()
+
+		target := filepath.Clean(filePath)
+
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) != target {
+					continue
+				}
+				if e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				debounce.Reset(time.Millisecond * 200)
+
+			case <-debounce.C:
+				_, err := h.Refresh(filePath)
+				if err != nil {
+					log.Errorf("reload %s fail, keep running config:%v", filePath, err)
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("err:%v", err)
+
+			case <-subTicker.C:
+				h.UpdateSubscriptions(false)
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package testutil 测试共用的本地 socks5、echo 服务端
+package testutil
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/transport/socks5"
+	"github.com/darabuchi/nico/adapter"
+)
+
+// Socks5Server 本地的 socks5 服务端，记录每个连接请求的目标
+type Socks5Server struct {
+	net.Listener
+
+	lock    sync.Mutex
+	targets []string
+}
+
+func NewSocks5Server(t testing.TB) *Socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	s := &Socks5Server{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *Socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	target, _, err := socks5.ServerHandshake(conn, nil)
+	if err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.targets = append(s.targets, target.String())
+	s.lock.Unlock()
+
+	remote, err := net.Dial("tcp", target.String())
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+
+	go func() {
+		_, _ = io.Copy(remote, conn)
+		_ = remote.Close()
+	}()
+	_, _ = io.Copy(conn, remote)
+}
+
+// Targets 按顺序返回请求过的目标
+func (s *Socks5Server) Targets() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string(nil), s.targets...)
+}
+
+// Node 指向这个服务端的节点，dialerProxy 不为空时通过该跳板连接
+func (s *Socks5Server) Node(t testing.TB, name, dialerProxy string) *adapter.ProxyAdapter {
+	m := map[string]any{
+		"name":   name,
+		"type":   "socks5",
+		"server": "127.0.0.1",
+		"port":   s.Addr().(*net.TCPAddr).Port,
+	}
+	if dialerProxy != "" {
+		m[adapter.DialerProxyKey] = dialerProxy
+	}
+
+	p, err := adapter.ParseClash(m)
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+	return p
+}
+
+// EchoListener 原样返回收到的数据
+func EchoListener(t testing.TB) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l
+}
+
+// TcpMetadata 连接 ipv4 地址 addr 的 metadata
+func TcpMetadata(addr string) *constant.Metadata {
+	ap := netip.MustParseAddrPort(addr)
+	return &constant.Metadata{
+		NetWork:  constant.TCP,
+		AddrType: constant.AtypIPv4,
+		DstIP:    ap.Addr(),
+		DstPort:  strconv.Itoa(int(ap.Port())),
+	}
+}